@@ -0,0 +1,86 @@
+package value
+
+// array is an Array backed by a slice of values
+type array []Value
+
+// NewArray creates an Array from a slice of values
+func NewArray(vals []Value) Array {
+	return array(vals)
+}
+
+// Iterate returns an iterator over the array's values
+func (a array) Iterate() Iterator {
+	return NewIterator(a)
+}
+
+// iteratorArray is an Array that reads values from a source iterator as
+// they're needed. values read from the source are kept so the array can be
+// iterated more than once
+type iteratorArray struct {
+	src  Iterator
+	vals []Value
+	done bool
+	err  error
+}
+
+// NewArrayFromIterator creates an Array that lazily reads its values from an
+// iterator, letting large sequences be used without reading them whole.
+// iterations that stop early leave the rest of the source unread, the source
+// is closed once it's exhausted. the array takes ownership of the source and
+// isn't safe for concurrent use
+func NewArrayFromIterator(src Iterator) Array {
+	return &iteratorArray{src: src}
+}
+
+// Iterate returns an iterator over the array's values, reading from the
+// source iterator as needed
+func (a *iteratorArray) Iterate() Iterator {
+	return &iteratorArrayIterator{a: a, i: -1}
+}
+
+// fill reads values from the source until the array holds more than i
+// values, reporting if value i exists
+func (a *iteratorArray) fill(i int) bool {
+	for len(a.vals) <= i && !a.done {
+		if !a.src.Next() {
+			a.done = true
+			a.err = a.src.Close()
+			break
+		}
+		var v Value
+		if a.err = a.src.Scan(&v); a.err != nil {
+			a.done = true
+			a.src.Close()
+			break
+		}
+		a.vals = append(a.vals, v)
+	}
+	return i < len(a.vals)
+}
+
+// iteratorArrayIterator iterates the values of an iteratorArray
+type iteratorArrayIterator struct {
+	a *iteratorArray
+	i int
+}
+
+// Next advances the iterator, returning false if no iterations remain
+func (it *iteratorArrayIterator) Next() bool {
+	if !it.a.fill(it.i + 1) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+// Scan reads the current iteration value into dest
+func (it *iteratorArrayIterator) Scan(dest Value) error { return scanValue(dest, it.a.vals[it.i]) }
+
+// Key returns the current index
+func (it *iteratorArrayIterator) Key() interface{} { return it.i }
+
+// Close returns any error encountered reading from the source iterator
+func (it *iteratorArrayIterator) Close() error { return it.a.err }
+
+// IsOrdered returns true, values are iterated in the order of the source
+func (it *iteratorArrayIterator) IsOrdered() bool { return true }
@@ -0,0 +1,72 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// drain reads every value from an iterator
+func drain(t *testing.T, it Iterator) (vals []Value) {
+	t.Helper()
+	for it.Next() {
+		var v Value
+		if err := it.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, v)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return vals
+}
+
+func TestNewArray(t *testing.T) {
+	arr := NewArray([]Value{"a", 2, nil})
+	expect := []Value{"a", 2, nil}
+	if diff := cmp.Diff(expect, drain(t, arr.Iterate())); diff != "" {
+		t.Errorf("values mismatch (-want +got):\n%s", diff)
+	}
+	// arrays can be iterated more than once
+	if diff := cmp.Diff(expect, drain(t, arr.Iterate())); diff != "" {
+		t.Errorf("second iteration mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewArrayFromIterator(t *testing.T) {
+	src := &countingIterator{Iterator: NewIterator([]Value{1, 2, 3, 4})}
+	arr := NewArrayFromIterator(src)
+
+	it := arr.Iterate()
+	for i := 0; i < 2; i++ {
+		if !it.Next() {
+			t.Fatalf("expected value %d", i)
+		}
+	}
+	if src.scanned != 2 {
+		t.Errorf("expected reading 2 values to scan the source twice, got %d", src.scanned)
+	}
+	if src.closed {
+		t.Errorf("expected source to stay open until it's exhausted")
+	}
+	if it.Key() != 1 {
+		t.Errorf("expected key 1, got %v", it.Key())
+	}
+
+	expect := []Value{1, 2, 3, 4}
+	if diff := cmp.Diff(expect, drain(t, arr.Iterate())); diff != "" {
+		t.Errorf("values mismatch (-want +got):\n%s", diff)
+	}
+	if !src.closed {
+		t.Errorf("expected exhausting the source to close it")
+	}
+	// replaying doesn't read from the source again
+	scanned := src.scanned
+	if diff := cmp.Diff(expect, drain(t, arr.Iterate())); diff != "" {
+		t.Errorf("replayed values mismatch (-want +got):\n%s", diff)
+	}
+	if src.scanned != scanned {
+		t.Errorf("expected replaying to use buffered values")
+	}
+}
@@ -0,0 +1,192 @@
+package value
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Canonicalize encodes a value as canonical JSON following the JSON
+// Canonicalization Scheme (JCS, RFC 8785): object keys are sorted by their
+// UTF-16 code units, no insignificant whitespace is written, strings use the
+// minimal escaping of ECMAScript's JSON.stringify, and numbers are formatted
+// with ECMAScript's Number.prototype.toString rules. Equal values always
+// produce identical bytes, making the output suitable for hashing & signing.
+//
+// All numbers are IEEE 754 doubles under JCS, ints outside ±2^53 lose
+// precision. []byte values encode as base64 strings, matching MarshalJSON.
+// NaN, infinities & invalid UTF-8 strings are an error
+func Canonicalize(v Value) ([]byte, error) {
+	v, err := toJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := writeCanonical(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(x))
+	case uint8:
+		return writeCanonicalNumber(buf, float64(x))
+	case int:
+		return writeCanonicalNumber(buf, float64(x))
+	case float64:
+		return writeCanonicalNumber(buf, x)
+	case string:
+		return writeCanonicalString(buf, x)
+	case []byte:
+		return writeCanonicalString(buf, base64.StdEncoding.EncodeToString(x))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, el := range x {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, el); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalString(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, x[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("cannot canonicalize %T", v)
+	}
+	return nil
+}
+
+// lessUTF16 orders strings by their UTF-16 code units
+func lessUTF16(a, b string) bool {
+	x, y := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return x[i] < y[i]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// writeCanonicalString writes a quoted string, escaping only quotes,
+// backslashes & control characters
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("cannot canonicalize invalid UTF-8 string %q", s)
+	}
+
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return nil
+}
+
+// writeCanonicalNumber formats a number following ECMAScript's
+// Number.prototype.toString: the shortest digits that round trip, written
+// in decimal notation for exponents from -7 through 20 & in scientific
+// notation otherwise
+func writeCanonicalNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("cannot canonicalize %v", f)
+	}
+	if f == 0 {
+		// also covers negative zero
+		buf.WriteByte('0')
+		return nil
+	}
+	if f < 0 {
+		buf.WriteByte('-')
+		f = -f
+	}
+
+	// shortest round-trip digits in the form d.ddde±x
+	sci := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp := sci[:strings.IndexByte(sci, 'e')], sci[strings.IndexByte(sci, 'e')+1:]
+	digits := strings.Replace(mantissa, ".", "", 1)
+	e, err := strconv.Atoi(exp)
+	if err != nil {
+		return err
+	}
+	// n is the position of the decimal point relative to the digits
+	k, n := len(digits), e+1
+
+	switch {
+	case k <= n && n <= 21:
+		buf.WriteString(digits)
+		buf.WriteString(strings.Repeat("0", n-k))
+	case 0 < n && n <= 21:
+		buf.WriteString(digits[:n])
+		buf.WriteByte('.')
+		buf.WriteString(digits[n:])
+	case -6 < n && n <= 0:
+		buf.WriteString("0.")
+		buf.WriteString(strings.Repeat("0", -n))
+		buf.WriteString(digits)
+	default:
+		buf.WriteString(digits[:1])
+		if k > 1 {
+			buf.WriteByte('.')
+			buf.WriteString(digits[1:])
+		}
+		buf.WriteByte('e')
+		if n-1 >= 0 {
+			buf.WriteByte('+')
+		}
+		buf.WriteString(strconv.Itoa(n - 1))
+	}
+	return nil
+}
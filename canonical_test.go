@@ -0,0 +1,118 @@
+package value
+
+import (
+	"math"
+	"testing"
+)
+
+// number serialization test vectors from RFC 8785, Appendix B
+func TestCanonicalizeNumbers(t *testing.T) {
+	cases := []struct {
+		bits   uint64
+		expect string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+	}
+
+	for _, c := range cases {
+		f := math.Float64frombits(c.bits)
+		got, err := Canonicalize(f)
+		if err != nil {
+			t.Errorf("%016x unexpected error: %s", c.bits, err)
+			continue
+		}
+		if string(got) != c.expect {
+			t.Errorf("%016x mismatch. want: %s, got: %s", c.bits, c.expect, got)
+		}
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := Canonicalize(f); err == nil {
+			t.Errorf("expected canonicalizing %v to error", f)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		description string
+		in          string
+		expect      string
+	}{
+		// RFC 8785, section 3.2.2
+		{"rfc example", `{
+			"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+			"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+			"literals": [null, true, false]
+		}`, `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`},
+		// RFC 8785, section 3.2.3
+		{"utf-16 key sorting", `{
+			"\u20ac": "Euro Sign",
+			"\r": "Carriage Return",
+			"\ufb33": "Hebrew Letter Dalet With Dagesh",
+			"1": "One",
+			"\ud83d\ude00": "Emoji: Grinning Face",
+			"\u0080": "Control",
+			"\u00f6": "Latin Small Letter O With Diaeresis"
+		}`, "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"},
+		{"nesting & whitespace", `{ "b": [ 1, { "d": 2, "c": "<&>" } ], "a": {} }`, `{"a":{},"b":[1,{"c":"<&>","d":2}]}`},
+		{"ints", `[0, -1, 100000000000000000000]`, `[0,-1,100000000000000000000]`},
+	}
+
+	for _, c := range cases {
+		v, err := UnmarshalJSON([]byte(c.in))
+		if err != nil {
+			t.Fatalf("%s: %s", c.description, err)
+		}
+		got, err := Canonicalize(v)
+		if err != nil {
+			t.Errorf("%s unexpected error: %s", c.description, err)
+			continue
+		}
+		if string(got) != c.expect {
+			t.Errorf("%s mismatch.\nwant: %s\ngot:  %s", c.description, c.expect, got)
+		}
+	}
+}
+
+func TestCanonicalizeComplex(t *testing.T) {
+	got, err := Canonicalize(map[interface{}]interface{}{
+		"list":  NewIterator([]Value{byte(2), 1.5}),
+		"bytes": []byte("hi"),
+		1:       "one",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"1":"one","bytes":"aGk=","list":[2,1.5]}`
+	if string(got) != expect {
+		t.Errorf("mismatch.\nwant: %s\ngot:  %s", expect, got)
+	}
+
+	if _, err := Canonicalize("\xff"); err == nil {
+		t.Errorf("expected canonicalizing invalid UTF-8 to error")
+	}
+}
@@ -0,0 +1,46 @@
+package value
+
+// Clone deep copies a value. maps & slices are copied recursively & byte
+// slices are copied, so modifying a clone never affects the original.
+// scalars are returned as-is. complex values (Links, Maps, Arrays, Iterators
+// & ByteReaders) are defined by behaviour & are returned by reference, a clone
+// shares them with the original
+func Clone(v Value) Value {
+	switch x := v.(type) {
+	case []byte:
+		if x == nil {
+			return x
+		}
+		cp := make([]byte, len(x))
+		copy(cp, x)
+		return cp
+	case []interface{}:
+		if x == nil {
+			return x
+		}
+		cp := make([]interface{}, len(x))
+		for i, el := range x {
+			cp[i] = Clone(el)
+		}
+		return cp
+	case map[string]interface{}:
+		if x == nil {
+			return x
+		}
+		cp := make(map[string]interface{}, len(x))
+		for key, el := range x {
+			cp[key] = Clone(el)
+		}
+		return cp
+	case map[interface{}]interface{}:
+		if x == nil {
+			return x
+		}
+		cp := make(map[interface{}]interface{}, len(x))
+		for key, el := range x {
+			cp[key] = Clone(el)
+		}
+		return cp
+	}
+	return v
+}
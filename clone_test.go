@@ -0,0 +1,49 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClone(t *testing.T) {
+	l := NewLink("/a")
+	orig := map[string]interface{}{
+		"list":  []interface{}{1, map[string]interface{}{"b": "c"}},
+		"bytes": []byte("abc"),
+		"keyed": map[interface{}]interface{}{1: []interface{}{true}},
+		"link":  l,
+		"num":   2.5,
+	}
+	expect := map[string]interface{}{
+		"list":  []interface{}{1, map[string]interface{}{"b": "c"}},
+		"bytes": []byte("abc"),
+		"keyed": map[interface{}]interface{}{1: []interface{}{true}},
+		"link":  l,
+		"num":   2.5,
+	}
+
+	clone := Clone(orig).(map[string]interface{})
+	if diff := cmp.Diff(orig, clone, cmp.AllowUnexported(link{})); diff != "" {
+		t.Fatalf("clone mismatch (-want +got):\n%s", diff)
+	}
+
+	clone["num"] = 0
+	clone["list"].([]interface{})[0] = "changed"
+	clone["list"].([]interface{})[1].(map[string]interface{})["b"] = "changed"
+	clone["bytes"].([]byte)[0] = 'z'
+	clone["keyed"].(map[interface{}]interface{})[1].([]interface{})[0] = false
+
+	if diff := cmp.Diff(expect, orig, cmp.AllowUnexported(link{})); diff != "" {
+		t.Errorf("modifying a clone changed the original (-want +got):\n%s", diff)
+	}
+	if clone["link"] != l {
+		t.Errorf("expected links to be shared by reference")
+	}
+
+	for _, v := range []Value{nil, 1, "a", true, []interface{}(nil), map[string]interface{}(nil)} {
+		if diff := cmp.Diff(v, Clone(v)); diff != "" {
+			t.Errorf("clone of %#v mismatch (-want +got):\n%s", v, diff)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package value
+
+import (
+	"math"
+	"strconv"
+)
+
+// AsInt coerces a value to an int. ints & bytes convert directly. float64s
+// convert only when they're whole numbers that fit in an int. strings &
+// byte slices convert when they hold a base 10 integer, or a float that
+// converts by the float rule. all other values report false.
+//
+// numeric strings are always coerced, there's no option to turn it off.
+// callers that only want numbers should check for a string first
+func AsInt(v Value) (int, bool) {
+	switch x := v.(type) {
+	case int:
+		return x, true
+	case uint8:
+		return int(x), true
+	case float64:
+		return floatToInt(x)
+	case string:
+		return parseInt(x)
+	case []byte:
+		return parseInt(string(x))
+	}
+	return 0, false
+}
+
+// AsFloat coerces a value to a float64. floats, ints & bytes convert
+// directly. strings & byte slices convert when they hold a finite number as
+// parsed by strconv.ParseFloat, so "NaN" & "Inf" report false. all other
+// values report false. like AsInt, numeric strings are always coerced
+func AsFloat(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case string:
+		return parseFloat(x)
+	case []byte:
+		return parseFloat(string(x))
+	}
+	return 0, false
+}
+
+// AsString coerces a value to a string. strings convert directly, byte
+// slices are read as text. numbers format in their shortest decimal form,
+// with whole floats written without a decimal point, and bools become "true"
+// or "false". all other values, including nil, report false
+func AsString(v Value) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	case int:
+		return strconv.Itoa(x), true
+	case uint8:
+		return strconv.Itoa(int(x)), true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(x), true
+	}
+	return "", false
+}
+
+// AsBool coerces a value to a bool. bools convert directly. the strings
+// "true" & "false" convert to their bool values. all other values, including
+// numbers, report false
+func AsBool(v Value) (bool, bool) {
+	switch x := v.(type) {
+	case bool:
+		return x, true
+	case string:
+		return parseBool(x)
+	case []byte:
+		return parseBool(string(x))
+	}
+	return false, false
+}
+
+func floatToInt(f float64) (int, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	i := int(f)
+	if float64(i) != f {
+		// f doesn't fit in a 32 bit int
+		return 0, false
+	}
+	return i, true
+}
+
+func parseInt(s string) (int, bool) {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i, true
+	}
+	if f, ok := parseFloat(s); ok {
+		return floatToInt(f)
+	}
+	return 0, false
+}
+
+// parseFloat reads a finite number from a string. ParseFloat also reads
+// "NaN" & "Inf", which aren't numbers in JSON
+func parseFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+func parseBool(s string) (bool, bool) {
+	switch s {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,125 @@
+package value
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAsInt(t *testing.T) {
+	cases := []struct {
+		in     Value
+		expect int
+		ok     bool
+	}{
+		{1, 1, true},
+		{byte(7), 7, true},
+		{float64(3), 3, true},
+		{float64(-2), -2, true},
+		{"42", 42, true},
+		{"4.0", 4, true},
+		{[]byte("12"), 12, true},
+
+		{1.5, 0, false},
+		{math.NaN(), 0, false},
+		{math.Inf(1), 0, false},
+		{1e300, 0, false},
+		{"4.5", 0, false},
+		{"forty", 0, false},
+		{"NaN", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+		{[]interface{}{1}, 0, false},
+	}
+
+	for _, c := range cases {
+		got, ok := AsInt(c.in)
+		if got != c.expect || ok != c.ok {
+			t.Errorf("AsInt(%#v): expected (%d, %t), got (%d, %t)", c.in, c.expect, c.ok, got, ok)
+		}
+	}
+}
+
+func TestAsFloat(t *testing.T) {
+	cases := []struct {
+		in     Value
+		expect float64
+		ok     bool
+	}{
+		{1.5, 1.5, true},
+		{2, 2, true},
+		{byte(3), 3, true},
+		{"-0.25", -0.25, true},
+		{"1e3", 1000, true},
+		{[]byte("2.5"), 2.5, true},
+
+		{"", 0, false},
+		{"one", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"-Infinity", 0, false},
+		{"1e400", 0, false},
+		{false, 0, false},
+		{nil, 0, false},
+		{map[string]interface{}{}, 0, false},
+	}
+
+	for _, c := range cases {
+		got, ok := AsFloat(c.in)
+		if got != c.expect || ok != c.ok {
+			t.Errorf("AsFloat(%#v): expected (%v, %t), got (%v, %t)", c.in, c.expect, c.ok, got, ok)
+		}
+	}
+}
+
+func TestAsString(t *testing.T) {
+	cases := []struct {
+		in     Value
+		expect string
+		ok     bool
+	}{
+		{"a", "a", true},
+		{[]byte("bytes"), "bytes", true},
+		{12, "12", true},
+		{byte(1), "1", true},
+		{float64(2), "2", true},
+		{0.125, "0.125", true},
+		{true, "true", true},
+
+		{nil, "", false},
+		{[]interface{}{"a"}, "", false},
+		{NewLink("/a"), "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := AsString(c.in)
+		if got != c.expect || ok != c.ok {
+			t.Errorf("AsString(%#v): expected (%q, %t), got (%q, %t)", c.in, c.expect, c.ok, got, ok)
+		}
+	}
+}
+
+func TestAsBool(t *testing.T) {
+	cases := []struct {
+		in     Value
+		expect bool
+		ok     bool
+	}{
+		{true, true, true},
+		{false, false, true},
+		{"true", true, true},
+		{"false", false, true},
+		{[]byte("true"), true, true},
+
+		{"TRUE", false, false},
+		{"1", false, false},
+		{1, false, false},
+		{nil, false, false},
+	}
+
+	for _, c := range cases {
+		got, ok := AsBool(c.in)
+		if got != c.expect || ok != c.ok {
+			t.Errorf("AsBool(%#v): expected (%t, %t), got (%t, %t)", c.in, c.expect, c.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package value
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// CSVOptions configures reading CSV data. there's no option for the quote
+// character: CSV is read with encoding/csv, which only quotes fields with a
+// double quote & has no setting to change it
+type CSVOptions struct {
+	// Delimiter separates fields, defaulting to a comma
+	Delimiter rune
+	// Comment marks lines to skip when it's the first character of a line.
+	// zero disables comments
+	Comment rune
+	// LazyQuotes allows quotes to appear in unquoted fields & unescaped quotes
+	// in quoted fields
+	LazyQuotes bool
+	// NoHeader reads the first row as data, yielding each row as an array
+	// instead of an object keyed by header
+	NoHeader bool
+	// InferTypes converts fields that look like ints, floats, or the bools
+	// "true" & "false" to numbers & bools. all other fields are strings
+	InferTypes bool
+}
+
+// csvIterator yields rows of CSV data
+type csvIterator struct {
+	r      *csv.Reader
+	src    io.Reader
+	opts   CSVOptions
+	header []string
+	i      int
+	row    Value
+	err    error
+}
+
+// NewCSVIterator creates an iterator that reads rows from r. by default the
+// first row is a header, and rows are yielded as objects keyed by header.
+// rows are read one at a time as the iterator advances. Key returns the index
+// of the current row, not counting the header. Close returns any error
+// encountered while reading, and closes r if it's an io.Closer
+func NewCSVIterator(r io.Reader, opts CSVOptions) Iterator {
+	cr := csv.NewReader(r)
+	if opts.Delimiter != 0 {
+		cr.Comma = opts.Delimiter
+	}
+	cr.Comment = opts.Comment
+	cr.LazyQuotes = opts.LazyQuotes
+
+	return &csvIterator{
+		r:    cr,
+		src:  r,
+		opts: opts,
+		i:    -1,
+	}
+}
+
+// Next advances the iterator, returning false if no iterations remain
+func (it *csvIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.header == nil && !it.opts.NoHeader {
+		if it.header, it.err = it.r.Read(); it.err != nil {
+			return false
+		}
+	}
+
+	rec, err := it.r.Read()
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.i++
+
+	if it.opts.NoHeader {
+		row := make([]interface{}, len(rec))
+		for i, field := range rec {
+			row[i] = it.field(field)
+		}
+		it.row = row
+		return true
+	}
+
+	row := make(map[string]interface{}, len(rec))
+	for i, field := range rec {
+		row[it.header[i]] = it.field(field)
+	}
+	it.row = row
+	return true
+}
+
+// field converts a CSV field to a value
+func (it *csvIterator) field(s string) Value {
+	if !it.opts.InferTypes {
+		return s
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	if f, ok := parseFloat(s); ok {
+		return f
+	}
+	if b, ok := parseBool(s); ok {
+		return b
+	}
+	return s
+}
+
+// Scan reads the current row into dest
+func (it *csvIterator) Scan(dest Value) error { return scanValue(dest, it.row) }
+
+// Key returns the index of the current row
+func (it *csvIterator) Key() interface{} { return it.i }
+
+// Close returns any read error, closing the underlying reader if it's an
+// io.Closer
+func (it *csvIterator) Close() (err error) {
+	if it.err != nil && it.err != io.EOF {
+		err = fmt.Errorf("reading CSV: %w", it.err)
+	}
+	if c, ok := it.src.(io.Closer); ok {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// IsOrdered returns true, CSV rows are always read in order
+func (it *csvIterator) IsOrdered() bool { return true }
+
+// WriteCSV writes each value of an iterator as a CSV row, closing the
+// iterator when finished. values must be objects or arrays. object fields are
+// written in header order, with missing fields left empty & fields not in
+// the header dropped. When headers is empty, headers are the sorted keys of
+// the first value if it's an object, and no header row is written if it's an
+// array. Scalar fields are formatted with AsString, null fields are empty,
+// and compound fields are written as JSON
+func WriteCSV(w io.Writer, it Iterator, headers []string) (err error) {
+	cw := csv.NewWriter(w)
+	first := true
+
+	err = eachIteration(it, func(_, v Value) error {
+		v, err := toJSON(v)
+		if err != nil {
+			return err
+		}
+
+		if first {
+			first = false
+			if obj, ok := v.(map[string]interface{}); ok && len(headers) == 0 {
+				headers = make([]string, 0, len(obj))
+				for key := range obj {
+					headers = append(headers, key)
+				}
+				sort.Strings(headers)
+			}
+			if len(headers) > 0 {
+				if err := cw.Write(headers); err != nil {
+					return err
+				}
+			}
+		}
+
+		var fields []interface{}
+		switch x := v.(type) {
+		case map[string]interface{}:
+			fields = make([]interface{}, len(headers))
+			for i, key := range headers {
+				fields[i] = x[key]
+			}
+		case []interface{}:
+			fields = x
+		default:
+			return fmt.Errorf("cannot write %s as a CSV row", schemaTypeName(v))
+		}
+
+		rec := make([]string, len(fields))
+		for i, field := range fields {
+			if rec[i], err = csvField(field); err != nil {
+				return err
+			}
+		}
+		return cw.Write(rec)
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvField formats a value as a CSV field. numbers are written the way
+// Canonicalize writes them, so 1e21 is 1e+21
+func csvField(v Value) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if f, ok := v.(float64); ok {
+		buf := &bytes.Buffer{}
+		err := writeCanonicalNumber(buf, f)
+		return buf.String(), err
+	}
+	if s, ok := AsString(v); ok {
+		return s, nil
+	}
+	data, err := MarshalJSON(v)
+	return string(data), err
+}
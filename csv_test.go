@@ -0,0 +1,131 @@
+package value
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCSVIterator(t *testing.T) {
+	cases := []struct {
+		description string
+		in          string
+		opts        CSVOptions
+		expect      []Value
+	}{
+		{"header", "name,age\nalice,30\nbob,25\n", CSVOptions{}, []Value{
+			map[string]interface{}{"name": "alice", "age": "30"},
+			map[string]interface{}{"name": "bob", "age": "25"},
+		}},
+		{"no header", "alice,30\nbob,25\n", CSVOptions{NoHeader: true}, []Value{
+			[]interface{}{"alice", "30"},
+			[]interface{}{"bob", "25"},
+		}},
+		{"custom delimiter", "name;note\n\"a;b\";\"say \"\"hi\"\"\"\n", CSVOptions{Delimiter: ';'}, []Value{
+			map[string]interface{}{"name": "a;b", "note": `say "hi"`},
+		}},
+		{"comments", "# skip me\nx\n1\n", CSVOptions{Comment: '#'}, []Value{
+			map[string]interface{}{"x": "1"},
+		}},
+		{"type inference", "i,f,b,s,e,n\n1,2.5,true,abc,,NaN\n", CSVOptions{InferTypes: true}, []Value{
+			map[string]interface{}{"i": 1, "f": 2.5, "b": true, "s": "abc", "e": "", "n": "NaN"},
+		}},
+		{"header only", "a,b\n", CSVOptions{}, nil},
+		{"empty", "", CSVOptions{}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			it := NewCSVIterator(strings.NewReader(c.in), c.opts)
+			var got []Value
+			for i := 0; it.Next(); i++ {
+				if it.Key() != i {
+					t.Errorf("expected key %d, got %v", i, it.Key())
+				}
+				var v Value
+				if err := it.Scan(&v); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v)
+			}
+			if err := it.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expect, got); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCSVIteratorReadError(t *testing.T) {
+	it := NewCSVIterator(strings.NewReader("a,b\n1,2\n1,2,3\n"), CSVOptions{})
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 row before the error, got %d", n)
+	}
+	if err := it.Close(); err == nil {
+		t.Errorf("expected close to return the read error")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	cases := []struct {
+		description string
+		in          []Value
+		headers     []string
+		expect      string
+	}{
+		{"derived headers", []Value{
+			map[string]interface{}{"name": "alice", "age": 30},
+			map[string]interface{}{"name": "bob"},
+			map[string]interface{}{"age": 2.5, "extra": true},
+		}, nil, "age,name\n30,alice\n,bob\n2.5,\n"},
+		{"given headers", []Value{
+			map[string]interface{}{"a": nil, "b": []interface{}{1, "x"}, "c": map[string]interface{}{"d": false}},
+		}, []string{"c", "b", "a"}, "c,b,a\n\"{\"\"d\"\":false}\",\"[1,\"\"x\"\"]\",\n"},
+		{"arrays", []Value{
+			[]interface{}{"a,b", byte(1), []byte("bytes")},
+			[]interface{}{true},
+		}, nil, "\"a,b\",1,bytes\ntrue\n"},
+		{"arrays with headers", []Value{
+			[]interface{}{1, 2},
+		}, []string{"x", "y"}, "x,y\n1,2\n"},
+		{"numbers", []Value{
+			[]interface{}{1e21, 1e-7, 0.000001, 123.5, math.Copysign(0, -1)},
+		}, nil, "1e+21,1e-7,0.000001,123.5,0\n"},
+		{"empty", nil, nil, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			buf := &strings.Builder{}
+			if err := WriteCSV(buf, NewIterator(c.in), c.headers); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expect, buf.String()); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if err := WriteCSV(&strings.Builder{}, NewIterator([]Value{"scalar"}), nil); err == nil {
+		t.Errorf("expected writing a scalar row to error")
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	src := "age,name\n30,alice\n25,bob\n"
+	buf := &strings.Builder{}
+	if err := WriteCSV(buf, NewCSVIterator(strings.NewReader(src), CSVOptions{InferTypes: true}), nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(src, buf.String()); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
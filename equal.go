@@ -0,0 +1,148 @@
+package value
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// Equal reports whether two values are deeply equal. numbers compare by
+// value regardless of type, so 1 and 1.0 are equal. objects are equal when
+// they hold equal values for the same keys, whether they're
+// map[string]interface{}, map[interface{}]interface{} or Map values, with
+// non-string keys compared by their formatted text. arrays & Array values
+// compare element-wise. resolved links compare by the value they point to,
+// unresolved links by path. Iterators are only equal to themselves, as
+// comparing them would consume them
+func Equal(a, b Value) bool {
+	a, b = linkTarget(a), linkTarget(b)
+
+	if x, ok := equalNumber(a); ok {
+		y, ok := equalNumber(b)
+		return ok && x == y
+	}
+
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case []byte:
+		y, ok := b.([]byte)
+		return ok && bytes.Equal(x, y)
+	case Link:
+		y, ok := b.(Link)
+		return ok && x.Path() == y.Path()
+	case Iterator:
+		return a == b
+	}
+
+	if x, ok := equalObject(a); ok {
+		y, ok := equalObject(b)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, el := range x {
+			other, ok := y[key]
+			if !ok || !Equal(el, other) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if x, ok := equalArray(a); ok {
+		y, ok := equalArray(b)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// linkTarget returns the value a resolved link points to, following chains
+// of resolved links. other values are returned as-is
+func linkTarget(v Value) Value {
+	for {
+		l, ok := v.(Link)
+		if !ok {
+			return v
+		}
+		target, resolved := l.Value()
+		if !resolved {
+			return v
+		}
+		v = target
+	}
+}
+
+func equalNumber(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// equalObject reads map-like values into a map keyed by string
+func equalObject(v Value) (map[string]Value, bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return x, true
+	case map[interface{}]interface{}:
+		m := make(map[string]Value, len(x))
+		for key, el := range x {
+			m[fmt.Sprintf("%v", key)] = el
+		}
+		return m, true
+	case Map:
+		m := map[string]Value{}
+		it := x.Iterate()
+		for it.Next() {
+			var el Value
+			if err := it.Scan(&el); err != nil {
+				it.Close()
+				return nil, false
+			}
+			m[fmt.Sprintf("%v", it.Key())] = el
+		}
+		return m, it.Close() == nil
+	}
+	return nil, false
+}
+
+// equalArray reads array-like values into a slice
+func equalArray(v Value) ([]Value, bool) {
+	switch x := v.(type) {
+	case []interface{}:
+		return x, true
+	case Array:
+		var vals []Value
+		it := x.Iterate()
+		for it.Next() {
+			var el Value
+			if err := it.Scan(&el); err != nil {
+				it.Close()
+				return nil, false
+			}
+			vals = append(vals, el)
+		}
+		return vals, it.Close() == nil
+	}
+	return nil, false
+}
@@ -0,0 +1,64 @@
+package value
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	resolved := NewResolvedLink("/a", map[string]interface{}{"x": 1})
+
+	cases := []struct {
+		a, b   Value
+		expect bool
+	}{
+		{nil, nil, true},
+		{nil, false, false},
+		{1, 1.0, true},
+		{uint8(2), 2, true},
+		{1, 1.5, false},
+		{1, "1", false},
+		{"a", "a", true},
+		{"a", []byte("a"), false},
+		{[]byte("ab"), []byte("ab"), true},
+		{true, true, true},
+		{true, 1, false},
+		{[]interface{}{1, "b"}, []interface{}{1.0, "b"}, true},
+		{[]interface{}{1}, []interface{}{1, 2}, false},
+		{map[string]interface{}{"a": 1}, map[interface{}]interface{}{"a": 1.0}, true},
+		{map[string]interface{}{"1": true}, map[interface{}]interface{}{1: true}, true},
+		{map[string]interface{}{"a": 1}, map[string]interface{}{"b": 1}, false},
+		{map[string]interface{}{"a": nil}, map[string]interface{}{}, false},
+		{map[string]interface{}{"a": 1}, []interface{}{1}, false},
+		{
+			map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": 2.0}}},
+			map[interface{}]interface{}{"a": []interface{}{1.0, map[interface{}]interface{}{"b": 2}}},
+			true,
+		},
+		{
+			map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": 2}}},
+			map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": 3}}},
+			false,
+		},
+		{NewMap([]interface{}{"a", "b"}, []Value{1, 2}), map[string]interface{}{"b": 2, "a": 1.0}, true},
+		{NewArray([]Value{1, "x"}), []interface{}{1.0, "x"}, true},
+		{resolved, map[string]interface{}{"x": 1.0}, true},
+		{NewLink("/a"), NewLink("/a"), true},
+		{NewLink("/a"), NewLink("/b"), false},
+		{NewLink("/a"), resolved, false},
+	}
+
+	for i, c := range cases {
+		if got := Equal(c.a, c.b); got != c.expect {
+			t.Errorf("case %d: expected Equal(%#v, %#v) to be %t", i, c.a, c.b, c.expect)
+		}
+		if got := Equal(c.b, c.a); got != c.expect {
+			t.Errorf("case %d: expected Equal to be symmetric", i)
+		}
+	}
+
+	it := NewIterator([]Value{1})
+	if !Equal(it, it) {
+		t.Errorf("expected an iterator to equal itself")
+	}
+	if Equal(it, NewIterator([]Value{1})) {
+		t.Errorf("expected distinct iterators not to be equal")
+	}
+}
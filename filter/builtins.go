@@ -0,0 +1,778 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/qri-io/value"
+)
+
+// firstValue returns the first value a filter produced, and false if the
+// filter produced no values at all
+func firstValue(in interface{}) (v interface{}, ok bool) {
+	if vs, isStream := in.(*valueStream); isStream {
+		ok = vs.Next(&v)
+		vs.Close()
+		return v, ok
+	}
+	return in, true
+}
+
+// resolveLink gets the value a link points to, using the link's cached value
+// if it's already been resolved. newly resolved values are always cached on
+// the link
+func resolveLink(ctx context.Context, e *env, l value.Link) (v interface{}, err error) {
+	if v, resolved := l.Value(); resolved {
+		return v, nil
+	}
+	if e.resolver == nil {
+		return nil, fmt.Errorf("cannot resolve link %q without a resolver", l.Path())
+	}
+	if v, err = e.resolver.Resolve(ctx, l); err != nil {
+		return nil, err
+	}
+	l.Resolved(v)
+	return v, nil
+}
+
+// resolveAll resolves every link within a value. resolving links tracks the
+// paths of links being resolved, leaving links that refer back to them as-is.
+// complex values that contain links are converted to native maps & slices.
+// with the CacheResolvedLinks option links are kept, with their resolved
+// values cached on the link
+func resolveAll(ctx context.Context, e *env, in interface{}, resolving map[string]bool) (out interface{}, err error) {
+	switch v := in.(type) {
+	case value.Link:
+		if resolving[v.Path()] {
+			return v, nil
+		}
+		if out, err = resolveLink(ctx, e, v); err != nil {
+			return nil, err
+		}
+		resolving[v.Path()] = true
+		out, err = resolveAll(ctx, e, out, resolving)
+		delete(resolving, v.Path())
+		if err != nil || !e.opts.CacheResolvedLinks {
+			return out, err
+		}
+		v.Resolved(out)
+		return v, nil
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		obj, err := toObject(v)
+		if err != nil {
+			return nil, err
+		}
+		res := make(map[string]interface{}, len(obj))
+		for key, x := range obj {
+			if res[key], err = resolveAll(ctx, e, x, resolving); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case []interface{}, value.Array, value.Iterator:
+		vals, err := collect(ctx, e, v)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]interface{}, len(vals))
+		for i, x := range vals {
+			if res[i], err = resolveAll(ctx, e, x, resolving); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return in, nil
+}
+
+// errStopIteration is returned by an each callback to end iteration early
+var errStopIteration = fmt.Errorf("stop iteration")
+
+// each calls fn for every element of an array-like input, closing any
+// iterators when done. fn can return errStopIteration to end iteration early
+func each(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) (err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return err
+		}
+	}
+
+	switch v := in.(type) {
+	case []interface{}:
+		for _, x := range v {
+			if err = fn(x); err != nil {
+				break
+			}
+		}
+	case *valueStream:
+		var x interface{}
+		for v.Next(&x) {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			if err = fn(x); err != nil {
+				break
+			}
+		}
+		v.Close()
+	case value.Iterator:
+		for v.Next() {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			var x interface{}
+			if err = v.Scan(&x); err != nil {
+				break
+			}
+			if err = fn(x); err != nil {
+				break
+			}
+		}
+		if closeErr := v.Close(); err == nil {
+			err = closeErr
+		}
+	case value.Array:
+		return each(ctx, e, v.Iterate(), fn)
+	default:
+		return fmt.Errorf("cannot iterate over %s", typeName(in))
+	}
+
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fMapValues applies a filter to each value of an object, keeping keys.
+// keys where the filter produces no output are dropped
+type fMapValues struct {
+	f filter
+}
+
+func (f fMapValues) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	if m, ok := in.(value.Map); ok {
+		res := map[string]interface{}{}
+		it := m.Iterate()
+		for it.Next() {
+			var v interface{}
+			if err = it.Scan(&v); err != nil {
+				it.Close()
+				return nil, err
+			}
+			if err = f.set(ctx, e, res, fmt.Sprintf("%v", it.Key()), v); err != nil {
+				it.Close()
+				return nil, err
+			}
+		}
+		return res, it.Close()
+	}
+
+	switch v := in.(type) {
+	case *valueStream:
+		return applyToStream(ctx, e, v, f)
+	case map[string]interface{}:
+		res := map[string]interface{}{}
+		for _, key := range sortedKeys(v) {
+			if err = f.set(ctx, e, res, key, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		vals := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			k := fmt.Sprintf("%v", key)
+			keys = append(keys, k)
+			vals[k] = val
+		}
+		sort.Strings(keys)
+
+		res := map[string]interface{}{}
+		for _, key := range keys {
+			if err = f.set(ctx, e, res, key, vals[key]); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("cannot map values of %s", typeName(in))
+}
+
+// set applies the value filter to v, writing the first result to res[key]
+func (f fMapValues) set(ctx context.Context, e *env, res map[string]interface{}, key string, v interface{}) error {
+	v, err := e.eval(ctx, f.f, v)
+	if err != nil {
+		return err
+	}
+	if v, ok := firstValue(v); ok {
+		res[key] = v
+	}
+	return nil
+}
+
+// fWalk applies a filter to every value in the input bottom-up, replacing
+// each value with the filter's first result. values the filter produces
+// nothing for are dropped from their parent. Walk never modifies the input,
+// links are resolved & walked like any other value, and complex maps and
+// arrays are materialized as native maps & slices
+type fWalk struct {
+	f filter
+}
+
+func (f fWalk) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	v, ok, err := f.walk(ctx, e, in)
+	if err != nil || ok {
+		return v, err
+	}
+	return newStream([]interface{}{})
+}
+
+func (f fWalk) walk(ctx context.Context, e *env, in interface{}) (out interface{}, ok bool, err error) {
+	if link, isLink := in.(value.Link); isLink {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, false, err
+		}
+	}
+
+	switch v := in.(type) {
+	case value.Map:
+		m := map[string]interface{}{}
+		it := v.Iterate()
+		for it.Next() {
+			var x interface{}
+			if err = it.Scan(&x); err != nil {
+				it.Close()
+				return nil, false, err
+			}
+			m[fmt.Sprintf("%v", it.Key())] = x
+		}
+		if err = it.Close(); err != nil {
+			return nil, false, err
+		}
+		return f.walk(ctx, e, m)
+	case value.Array:
+		return f.walk(ctx, e, value.Iterator(v.Iterate()))
+	case value.Iterator:
+		vals := []interface{}{}
+		for v.Next() {
+			var x interface{}
+			if err = v.Scan(&x); err != nil {
+				v.Close()
+				return nil, false, err
+			}
+			vals = append(vals, x)
+		}
+		if err = v.Close(); err != nil {
+			return nil, false, err
+		}
+		return f.walk(ctx, e, vals)
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for _, key := range sortedKeys(v) {
+			x, ok, err := f.walk(ctx, e, v[key])
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				m[key] = x
+			}
+		}
+		in = m
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, x := range v {
+			m[fmt.Sprintf("%v", key)] = x
+		}
+		return f.walk(ctx, e, m)
+	case []interface{}:
+		vals := make([]interface{}, 0, len(v))
+		for _, x := range v {
+			x, ok, err := f.walk(ctx, e, x)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				vals = append(vals, x)
+			}
+		}
+		in = vals
+	}
+
+	if out, err = e.eval(ctx, f.f, in); err != nil {
+		return nil, false, err
+	}
+	out, ok = firstValue(out)
+	return out, ok, nil
+}
+
+// fFromJSON decodes a JSON string or ByteReader into a value
+type fFromJSON byte
+
+func (f fFromJSON) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	switch v := in.(type) {
+	case *valueStream:
+		return applyToStream(ctx, e, v, f)
+	case string:
+		return value.UnmarshalJSON([]byte(v))
+	case []byte:
+		return value.UnmarshalJSON(v)
+	case value.ByteReader:
+		return e.decodeJSONReader(v)
+	}
+	return nil, fmt.Errorf("cannot decode %s as JSON", typeName(in))
+}
+
+// fMatchesSchema checks the input against a JSON Schema. By default it
+// outputs a boolean. with errors set it outputs a list of mismatch messages,
+// which is empty when the input is valid
+type fMatchesSchema struct {
+	schema filter
+	errors bool
+}
+
+func (f fMatchesSchema) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	schema, err := e.eval(ctx, f.schema, in)
+	if err != nil {
+		return nil, err
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	ok, errs := value.ValidateSchema(in, schema)
+	if !f.errors {
+		return ok, nil
+	}
+	msgs := make([]interface{}, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return msgs, nil
+}
+
+// isTruthy reports if a value counts as true in a condition. only false and
+// null are false
+func isTruthy(v interface{}) bool {
+	b, isBool := v.(bool)
+	return v != nil && (!isBool || b)
+}
+
+// condition evaluates a filter against a value, reporting if its first
+// result is truthy
+func condition(ctx context.Context, e *env, f filter, v interface{}) (bool, error) {
+	res, err := e.eval(ctx, f, v)
+	if err != nil {
+		return false, err
+	}
+	res, _ = firstValue(res)
+	return isTruthy(res), nil
+}
+
+// collect gathers the elements of an array-like input into a slice
+func collect(ctx context.Context, e *env, in interface{}) (vals []interface{}, err error) {
+	vals = []interface{}{}
+	err = each(ctx, e, in, func(v interface{}) error {
+		vals = append(vals, v)
+		return nil
+	})
+	return vals, err
+}
+
+// eachOutput calls fn with each value a filter produced
+func eachOutput(out interface{}, fn func(v interface{}) error) (err error) {
+	vs, ok := out.(*valueStream)
+	if !ok {
+		return fn(out)
+	}
+	var v interface{}
+	for vs.Next(&v) {
+		if err = fn(v); err != nil {
+			break
+		}
+	}
+	vs.Close()
+	return err
+}
+
+// fForeach runs a stateful scan over the outputs of a source filter. each
+// output is bound to a variable while the update filter computes the next
+// state from the previous one, starting from the first output of init. the
+// extract filter is applied to every state & its outputs are emitted,
+// defaulting to emitting each state
+type fForeach struct {
+	source  filter
+	name    string
+	init    filter
+	update  filter
+	extract filter
+}
+
+func (f fForeach) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	state, err := e.eval(ctx, f.init, in)
+	if err != nil {
+		return nil, err
+	}
+	state, _ = firstValue(state)
+
+	source, err := e.eval(ctx, f.source, in)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []interface{}{}
+	err = eachOutput(source, func(x interface{}) error {
+		scoped := e.bind(f.name, x)
+		next, err := scoped.eval(ctx, f.update, state)
+		if err != nil {
+			return err
+		}
+		state, _ = firstValue(next)
+
+		if f.extract == nil {
+			res = append(res, state)
+			return nil
+		}
+		extracted, err := scoped.eval(ctx, f.extract, state)
+		if err != nil {
+			return err
+		}
+		return eachOutput(extracted, func(v interface{}) error {
+			res = append(res, v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStream(res)
+}
+
+// fReduce folds the outputs of a source filter into a single value. each
+// output is bound to a variable while the update filter computes the next
+// accumulated value from the previous one, starting from the first output of
+// init
+type fReduce struct {
+	source filter
+	name   string
+	init   filter
+	update filter
+}
+
+func (f fReduce) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	acc, err := e.eval(ctx, f.init, in)
+	if err != nil {
+		return nil, err
+	}
+	acc, _ = firstValue(acc)
+
+	source, err := e.eval(ctx, f.source, in)
+	if err != nil {
+		return nil, err
+	}
+	err = eachOutput(source, func(x interface{}) error {
+		next, err := e.bind(f.name, x).eval(ctx, f.update, acc)
+		if err != nil {
+			return err
+		}
+		acc, _ = firstValue(next)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// fBind binds each output of a source filter to a variable, applying the
+// body to the input once per binding: SOURCE as $name | BODY
+type fBind struct {
+	source filter
+	name   string
+	body   filter
+}
+
+func (f fBind) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	source, err := e.eval(ctx, f.source, in)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := source.(*valueStream); !ok {
+		return e.bind(f.name, source).eval(ctx, f.body, in)
+	}
+
+	res := []interface{}{}
+	err = eachOutput(source, func(x interface{}) error {
+		out, err := e.bind(f.name, x).eval(ctx, f.body, in)
+		if err != nil {
+			return err
+		}
+		return eachOutput(out, func(v interface{}) error {
+			res = append(res, v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStream(res)
+}
+
+// fAnd is true when both its operands are truthy. the right operand is only
+// applied when the left is truthy
+type fAnd struct {
+	left, right filter
+}
+
+func (f fAnd) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyLogical(ctx, e, in, f, f.left, f.right, false)
+}
+
+// fOr is true when either of its operands are truthy. the right operand is
+// only applied when the left is falsy
+type fOr struct {
+	left, right filter
+}
+
+func (f fOr) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyLogical(ctx, e, in, f, f.left, f.right, true)
+}
+
+// applyLogical implements and & or. short is the truthiness of a left value
+// that decides the result without applying right. left & right can produce
+// multiple outputs, producing a result for each combination
+func applyLogical(ctx context.Context, e *env, in interface{}, f, left, right filter, short bool) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	l, err := e.eval(ctx, left, in)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []interface{}
+	err = eachOutput(l, func(lv interface{}) error {
+		if isTruthy(lv) == short {
+			res = append(res, short)
+			return nil
+		}
+		r, err := e.eval(ctx, right, in)
+		if err != nil {
+			return err
+		}
+		return eachOutput(r, func(rv interface{}) error {
+			res = append(res, isTruthy(rv))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 1 {
+		return res[0], nil
+	}
+	return newStream(res)
+}
+
+// fNot inverts the truthiness of its input
+type fNot byte
+
+func (f fNot) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	return !isTruthy(in), nil
+}
+
+// fValues passes its input through unless it's null, producing no output
+// for nulls
+type fValues byte
+
+func (f fValues) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if in == nil {
+		return newStream([]interface{}{})
+	}
+	return in, nil
+}
+
+// fType is the type name of its input: "null", "boolean", "number",
+// "string", "array" or "object". links report the type of the value they
+// point to
+type fType byte
+
+func (f fType) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+	return typeName(in), nil
+}
+
+// fEmpty produces no output
+type fEmpty byte
+
+func (f fEmpty) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return newStream([]interface{}{})
+}
+
+// fError raises an error. the message is the input, or the value of an
+// optional argument. string messages are used as-is, other values are
+// written as JSON
+type fError struct {
+	args []filter
+}
+
+func (f fError) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	msg := in
+	if len(f.args) > 0 {
+		if msg, err = argValue(ctx, e, f.args[0], in); err != nil {
+			return nil, err
+		}
+	}
+	if s, ok := msg.(string); ok {
+		return nil, fmt.Errorf("%s", s)
+	}
+	data, err := value.MarshalJSON(msg)
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s", data)
+}
+
+// fSelect passes its input through when a predicate is truthy, producing no
+// output otherwise
+type fSelect struct {
+	f filter
+}
+
+func (f fSelect) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	ok, err := condition(ctx, e, f.f, in)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return newStream([]interface{}{})
+	}
+	return in, nil
+}
+
+// fConditional applies the then branch to its input when the condition is
+// truthy, and the else branch otherwise. without an else branch the input
+// passes through unchanged
+type fConditional struct {
+	cond, then, els filter
+}
+
+func (f fConditional) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	cond, err := e.eval(ctx, f.cond, in)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []interface{}
+	err = eachOutput(cond, func(c interface{}) error {
+		branch := f.then
+		if !isTruthy(c) {
+			if branch = f.els; branch == nil {
+				res = append(res, in)
+				return nil
+			}
+		}
+		v, err := e.eval(ctx, branch, in)
+		if err != nil {
+			return err
+		}
+		res = append(res, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 1 {
+		return res[0], nil
+	}
+	return newStream(res)
+}
+
+// fTry suppresses errors from a filter, producing no output in place of an
+// error. cancellation isn't suppressed, it still stops evaluation
+type fTry struct {
+	f filter
+}
+
+func (f fTry) isSelector() {}
+
+func (f fTry) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if out, err = e.eval(ctx, f.f, in); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return newStream([]interface{}{})
+	}
+	return out, nil
+}
@@ -0,0 +1,770 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/qri-io/value"
+)
+
+// fReverse reverses an array, or the characters of a string
+type fReverse byte
+
+func (f fReverse) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := in.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case string:
+		return reverseString(v), nil
+	case []byte:
+		return []byte(reverseString(string(v))), nil
+	}
+
+	if typeName(in) != "array" {
+		return nil, fmt.Errorf("cannot reverse %s", typeName(in))
+	}
+	vals, err := collect(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
+		vals[i], vals[j] = vals[j], vals[i]
+	}
+	return vals, nil
+}
+
+// reverseString reverses a string by runes, keeping multi-byte characters
+// intact
+func reverseString(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// fFlatten expands nested arrays into a single array. an optional depth
+// argument limits how many levels of nesting are expanded
+type fFlatten struct {
+	args []filter
+}
+
+func (f fFlatten) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	depth := -1
+	if len(f.args) > 0 {
+		d, err := argValue(ctx, e, f.args[0], in)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := toFloat(d)
+		if !ok {
+			return nil, fmt.Errorf("flatten depth must be a number, got %s", typeName(d))
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("flatten depth must not be negative")
+		}
+		depth = int(n)
+	}
+
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+	if typeName(in) != "array" {
+		return nil, fmt.Errorf("cannot flatten %s", typeName(in))
+	}
+	return flatten(ctx, e, []interface{}{}, in, depth)
+}
+
+// flatten appends the elements of an array to res, expanding nested arrays
+// up to depth levels deep. a negative depth expands all levels
+func flatten(ctx context.Context, e *env, res []interface{}, in interface{}, depth int) ([]interface{}, error) {
+	err := each(ctx, e, in, func(v interface{}) (err error) {
+		if depth != 0 && typeName(v) == "array" {
+			res, err = flatten(ctx, e, res, v, depth-1)
+			return err
+		}
+		res = append(res, v)
+		return nil
+	})
+	return res, err
+}
+
+// fRange generates a stream of numbers. range(n) counts from zero up to n,
+// range(from; to) from "from" up to "to", and range(from; to; by) steps by
+// "by", counting down when the step is negative. the upper bound is never
+// included
+type fRange struct {
+	args []filter
+}
+
+func (f fRange) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	vals := []interface{}{}
+	if err = f.generate(ctx, e, in, func(v interface{}) error {
+		vals = append(vals, v)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return newStream(vals)
+}
+
+func (f fRange) generate(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) error {
+	bounds := make([]float64, len(f.args))
+	for i, arg := range f.args {
+		v, err := argValue(ctx, e, arg, in)
+		if err != nil {
+			return err
+		}
+		n, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("range bounds must be numbers, got %s", typeName(v))
+		}
+		bounds[i] = n
+	}
+
+	from, to, by := 0.0, bounds[0], 1.0
+	if len(bounds) > 1 {
+		from, to = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		by = bounds[2]
+	}
+	if by == 0 {
+		return fmt.Errorf("range step cannot be zero")
+	}
+
+	for x := from; (by > 0 && x < to) || (by < 0 && x > to); x += by {
+		if err := fn(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fZip pairs the elements of an array of arrays by index, producing an
+// array of tuples. by default zip stops at the shortest array. when the
+// optional argument is true, shorter arrays are padded with null to the
+// length of the longest
+type fZip struct {
+	args []filter
+}
+
+func (f fZip) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	pad := false
+	if len(f.args) > 0 {
+		if pad, err = condition(ctx, e, f.args[0], in); err != nil {
+			return nil, err
+		}
+	}
+
+	var arrays [][]interface{}
+	err = each(ctx, e, in, func(v interface{}) error {
+		arr, err := collect(ctx, e, v)
+		if err != nil {
+			return fmt.Errorf("zip expects an array of arrays: %s", err)
+		}
+		arrays = append(arrays, arr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for i, arr := range arrays {
+		if i == 0 || (pad && len(arr) > n) || (!pad && len(arr) < n) {
+			n = len(arr)
+		}
+	}
+
+	res := make([]interface{}, n)
+	for i := range res {
+		tuple := make([]interface{}, len(arrays))
+		for j, arr := range arrays {
+			if i < len(arr) {
+				tuple[j] = arr[i]
+			}
+		}
+		res[i] = tuple
+	}
+	return res, nil
+}
+
+// fEnumerate pairs each element of an array with its index as [index, value]
+// tuples. objects pair values with their keys in sorted key order. iterators,
+// Arrays & Maps are enumerated lazily, producing an iterator that pairs
+// values with the iterator's own keys
+type fEnumerate byte
+
+func (f fEnumerate) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := in.(type) {
+	case *valueStream:
+		return applyToStream(ctx, e, v, f)
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, x := range v {
+			res[i] = []interface{}{i, x}
+		}
+		return res, nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		obj, err := toObject(v)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]interface{}, 0, len(obj))
+		for _, key := range sortedKeys(obj) {
+			res = append(res, []interface{}{key, obj[key]})
+		}
+		return res, nil
+	case value.Iterator:
+		return &enumerateIterator{Iterator: v}, nil
+	case value.Map:
+		return &enumerateIterator{Iterator: v.Iterate()}, nil
+	case value.Array:
+		return &enumerateIterator{Iterator: v.Iterate()}, nil
+	}
+	return nil, fmt.Errorf("cannot enumerate %s", typeName(in))
+}
+
+// enumerateIterator wraps an iterator, yielding [key, value] tuples
+type enumerateIterator struct {
+	value.Iterator
+}
+
+// Scan reads the current key & value into dest as a tuple
+func (it *enumerateIterator) Scan(dest value.Value) error {
+	var v interface{}
+	if err := it.Iterator.Scan(&v); err != nil {
+		return err
+	}
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("expected pointer value for scan")
+	}
+	dv.Elem().Set(reflect.ValueOf([]interface{}{it.Key(), v}))
+	return nil
+}
+
+// keyString formats a value for use as an object key. strings are used
+// as-is, numbers format like value.Canonicalize, so 1e21 is "1e+21", other
+// scalars format with value.AsString, null is "null", and compound values are
+// written as JSON. NaN & infinities can't be canonicalized & use AsString
+func keyString(v interface{}) (string, error) {
+	if v == nil {
+		return "null", nil
+	}
+	if f, ok := v.(float64); ok {
+		if data, err := value.Canonicalize(f); err == nil {
+			return string(data), nil
+		}
+	}
+	if s, ok := value.AsString(v); ok {
+		return s, nil
+	}
+	data, err := value.MarshalJSON(v)
+	return string(data), err
+}
+
+// groupKey evaluates a key filter against a value, formatting the first
+// result as an object key
+func groupKey(ctx context.Context, e *env, f filter, v interface{}) (string, error) {
+	k, err := e.eval(ctx, f, v)
+	if err != nil {
+		return "", err
+	}
+	k, _ = firstValue(k)
+	return keyString(k)
+}
+
+// fCountBy counts the elements of an array by the value of a key filter,
+// producing an object of key to count
+type fCountBy struct {
+	key filter
+}
+
+func (f fCountBy) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	counts := map[string]interface{}{}
+	err = each(ctx, e, in, func(v interface{}) error {
+		key, err := groupKey(ctx, e, f.key, v)
+		if err != nil {
+			return err
+		}
+		n, _ := counts[key].(int)
+		counts[key] = n + 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// fIndexBy builds an object from the elements of an array, keyed by the
+// value of a key filter. when elements share a key the last one wins,
+// matching jq's INDEX
+type fIndexBy struct {
+	key filter
+}
+
+func (f fIndexBy) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	res := map[string]interface{}{}
+	err = each(ctx, e, in, func(v interface{}) error {
+		key, err := groupKey(ctx, e, f.key, v)
+		if err != nil {
+			return err
+		}
+		res[key] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fPartition splits the elements of an array in a single pass into
+// [matching, non_matching] arrays by a predicate, preserving order
+type fPartition struct {
+	pred filter
+}
+
+func (f fPartition) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	match, rest := []interface{}{}, []interface{}{}
+	err = each(ctx, e, in, func(v interface{}) error {
+		ok, err := condition(ctx, e, f.pred, v)
+		if err != nil {
+			return err
+		}
+		if ok {
+			match = append(match, v)
+		} else {
+			rest = append(rest, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{match, rest}, nil
+}
+
+// fTakeWhile emits the leading elements of an array while a predicate holds,
+// stopping at the first element it doesn't. iterators, Arrays & Maps produce
+// an iterator that stops advancing the source as soon as the predicate fails
+type fTakeWhile struct {
+	pred filter
+}
+
+func (f fTakeWhile) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyWhile(ctx, e, in, f, f.pred, true)
+}
+
+// fDropWhile skips the leading elements of an array while a predicate holds,
+// emitting the first element it doesn't hold for & every element after.
+// iterators, Arrays & Maps are dropped from lazily
+type fDropWhile struct {
+	pred filter
+}
+
+func (f fDropWhile) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyWhile(ctx, e, in, f, f.pred, false)
+}
+
+// applyWhile implements take_while & drop_while
+func applyWhile(ctx context.Context, e *env, in interface{}, f, pred filter, take bool) (out interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	cond := func(v value.Value) (bool, error) {
+		return condition(ctx, e, pred, v)
+	}
+
+	switch v := in.(type) {
+	case *valueStream:
+		return applyToStream(ctx, e, v, f)
+	case []interface{}:
+		i := 0
+		for ; i < len(v); i++ {
+			ok, err := cond(v[i])
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+		}
+		if take {
+			return v[:i], nil
+		}
+		return v[i:], nil
+	case value.Iterator:
+		return &whileIterator{src: v, cond: cond, take: take}, nil
+	case value.Map:
+		return &whileIterator{src: v.Iterate(), cond: cond, take: take}, nil
+	case value.Array:
+		return &whileIterator{src: v.Iterate(), cond: cond, take: take}, nil
+	}
+
+	name := "take_while"
+	if !take {
+		name = "drop_while"
+	}
+	return nil, fmt.Errorf("cannot %s over %s", name, typeName(in))
+}
+
+// whileIterator lazily takes or drops leading values of a source iterator
+// while a condition holds
+type whileIterator struct {
+	src  value.Iterator
+	cond func(v value.Value) (bool, error)
+	take bool
+	// done is set when take stops, or drop is finished dropping
+	done bool
+	val  value.Value
+	err  error
+}
+
+// Next advances the iterator, returning false if no iterations remain
+func (it *whileIterator) Next() bool {
+	if it.err != nil || (it.take && it.done) {
+		return false
+	}
+
+	for it.src.Next() {
+		it.val = nil
+		if it.err = it.src.Scan(&it.val); it.err != nil {
+			return false
+		}
+		if !it.take && it.done {
+			return true
+		}
+
+		var ok bool
+		if ok, it.err = it.cond(it.val); it.err != nil {
+			return false
+		}
+		if it.take {
+			if !ok {
+				it.done = true
+			}
+			return ok
+		}
+		if !ok {
+			it.done = true
+			return true
+		}
+	}
+	return false
+}
+
+// Scan reads the current iteration value into dest
+func (it *whileIterator) Scan(dest value.Value) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("expected pointer value for scan")
+	}
+	if it.val == nil {
+		dv.Elem().Set(reflect.Zero(dv.Elem().Type()))
+	} else {
+		dv.Elem().Set(reflect.ValueOf(it.val))
+	}
+	return nil
+}
+
+// Key returns the key of the current value in the source iterator
+func (it *whileIterator) Key() interface{} { return it.src.Key() }
+
+// Close closes the source iterator, returning any error encountered while
+// iterating
+func (it *whileIterator) Close() error {
+	err := it.src.Close()
+	if it.err != nil {
+		return it.err
+	}
+	return err
+}
+
+// IsOrdered returns true if the source iterator is ordered
+func (it *whileIterator) IsOrdered() bool { return it.src.IsOrdered() }
+
+// element selects the element at index i of an array, counting back from the
+// end when i is negative. indexes past the end select null. iterators are
+// only advanced as far as index i
+func element(ctx context.Context, e *env, in interface{}, i int) (v interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	switch arr := in.(type) {
+	case nil:
+		return nil, nil
+	case value.Iterator, value.Array:
+		if i < 0 {
+			vals, err := collect(ctx, e, arr)
+			if err != nil {
+				return nil, err
+			}
+			return element(ctx, e, vals, i)
+		}
+		n := 0
+		err = each(ctx, e, arr, func(x interface{}) error {
+			if n == i {
+				v = x
+				return errStopGenerating
+			}
+			n++
+			return nil
+		})
+		if err != nil && err != errStopGenerating {
+			return nil, err
+		}
+		return v, nil
+	case []interface{}:
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i >= len(arr) {
+			return nil, nil
+		}
+		return arr[i], nil
+	}
+	return nil, fmt.Errorf("cannot index %s with number", typeName(in))
+}
+
+// nthOutput produces output n of a filter, stopping the filter once it's
+// been produced. filters with n or fewer outputs produce nothing
+func nthOutput(ctx context.Context, e *env, f filter, in interface{}, n int) (out interface{}, err error) {
+	i, found := 0, false
+	err = generate(ctx, e, f, in, func(v interface{}) error {
+		if i == n {
+			out, found = v, true
+			return errStopGenerating
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return newStream([]interface{}{})
+	}
+	return out, nil
+}
+
+// fFirst selects the first element of an array. given a filter argument, it
+// produces the first output of the filter instead
+type fFirst struct {
+	args []filter
+}
+
+func (f fFirst) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if len(f.args) == 0 {
+		return element(ctx, e, in, 0)
+	}
+	return nthOutput(ctx, e, f.args[0], in, 0)
+}
+
+// fLast selects the last element of an array. given a filter argument, it
+// produces the last output of the filter instead
+type fLast struct {
+	args []filter
+}
+
+func (f fLast) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if len(f.args) == 0 {
+		return element(ctx, e, in, -1)
+	}
+
+	found := false
+	err = generate(ctx, e, f.args[0], in, func(v interface{}) error {
+		out, found = v, true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return newStream([]interface{}{})
+	}
+	return out, nil
+}
+
+// fNth selects element n of an array. with a second filter argument, it
+// produces output n of the filter instead
+type fNth struct {
+	args []filter
+}
+
+func (f fNth) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	v, err := argValue(ctx, e, f.args[0], in)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := pathIndex(v)
+	if !ok {
+		return nil, fmt.Errorf("nth index must be an integer, got %s", typeName(v))
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("nth doesn't support negative indexes")
+	}
+
+	if len(f.args) == 1 {
+		return element(ctx, e, in, n)
+	}
+	return nthOutput(ctx, e, f.args[1], in, n)
+}
+
+// fLimit produces at most n outputs of a filter, stopping the filter once n
+// outputs have been produced
+type fLimit struct {
+	n filter
+	f filter
+}
+
+func (f fLimit) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	v, err := argValue(ctx, e, f.n, in)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := pathIndex(v)
+	if !ok {
+		return nil, fmt.Errorf("limit count must be an integer, got %s", typeName(v))
+	}
+
+	vals := []interface{}{}
+	if n <= 0 {
+		return newStream(vals)
+	}
+	err = generate(ctx, e, f.f, in, func(v interface{}) error {
+		vals = append(vals, v)
+		if len(vals) == n {
+			return errStopGenerating
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStream(vals)
+}
+
+// fAny reports whether any element of an array is truthy. with one argument
+// the condition is applied to each element, with two the condition is
+// applied to each output of a generator filter. iteration stops at the first
+// truthy value
+type fAny struct {
+	args []filter
+}
+
+func (f fAny) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyQuantifier(ctx, e, in, f, f.args, true)
+}
+
+// fAll reports whether every element of an array is truthy, taking the same
+// arguments as any. iteration stops at the first falsy value
+type fAll struct {
+	args []filter
+}
+
+func (f fAll) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyQuantifier(ctx, e, in, f, f.args, false)
+}
+
+// applyQuantifier implements any & all. stop is the truth value that ends
+// iteration early & becomes the result
+func applyQuantifier(ctx context.Context, e *env, in interface{}, f filter, args []filter, stop bool) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	var cond filter = fIdentity(0)
+	if len(args) > 0 {
+		cond = args[len(args)-1]
+	}
+	check := func(v interface{}) error {
+		ok, err := condition(ctx, e, cond, v)
+		if err != nil {
+			return err
+		}
+		if ok == stop {
+			return errStopGenerating
+		}
+		return nil
+	}
+
+	if len(args) == 2 {
+		err = generateOutputs(ctx, e, args[0], in, check)
+	} else {
+		err = each(ctx, e, in, check)
+	}
+	if err == errStopGenerating {
+		return stop, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return !stop, nil
+}
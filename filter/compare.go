@@ -0,0 +1,467 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/value"
+)
+
+// materialize converts complex values into native maps & slices so they can
+// be compared. links are resolved, Maps become map[string]interface{}, and
+// Arrays & Iterators become []interface{}. Iterators are consumed
+func materialize(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := in.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		obj, err := toObject(v)
+		if err != nil {
+			return nil, err
+		}
+		res := make(map[string]interface{}, len(obj))
+		for key, x := range obj {
+			if res[key], err = materialize(ctx, e, x); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case []interface{}, value.Array, value.Iterator:
+		vals, err := collect(ctx, e, v)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]interface{}, len(vals))
+		for i, x := range vals {
+			if res[i], err = materialize(ctx, e, x); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return in, nil
+}
+
+// typeRank orders values by type, following jq: null < false < true <
+// numbers < strings < arrays < objects
+func typeRank(v interface{}) int {
+	switch x := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if !x {
+			return 1
+		}
+		return 2
+	case byte, int, float64, fNumericLiteral:
+		return 3
+	case string, []byte, fStringLiteral:
+		return 4
+	case []interface{}:
+		return 5
+	case map[string]interface{}:
+		return 6
+	}
+	return 7
+}
+
+// compareValues orders two materialized values, returning -1 if a sorts
+// before b, 1 if a sorts after b, and 0 if they're equal. values of different
+// types order by typeRank. numbers compare numerically, strings by bytes,
+// arrays element-wise, and objects first by their sorted keys, then by the
+// values of each key in sorted order
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ra {
+	case 3:
+		x, _ := toFloat(a)
+		y, _ := toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case 4:
+		return strings.Compare(stringValue(a), stringValue(b))
+	case 5:
+		x, y := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareValues(len(x), len(y))
+	case 6:
+		x, y := a.(map[string]interface{}), b.(map[string]interface{})
+		xk, yk := sortedKeys(x), sortedKeys(y)
+		if c := compareValues(stringSlice(xk), stringSlice(yk)); c != 0 {
+			return c
+		}
+		for _, key := range xk {
+			if c := compareValues(x[key], y[key]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// stringValue gets the text of a string-like value
+func stringValue(v interface{}) string {
+	switch s := v.(type) {
+	case []byte:
+		return string(s)
+	case fStringLiteral:
+		return string(s)
+	}
+	return fmt.Sprintf("%s", v)
+}
+
+// stringSlice converts a slice of strings to a slice of values
+func stringSlice(strs []string) []interface{} {
+	vals := make([]interface{}, len(strs))
+	for i, s := range strs {
+		vals[i] = s
+	}
+	return vals
+}
+
+// compare applies a comparison operator to two values
+func compare(ctx context.Context, e *env, op tokenType, left, right interface{}) (bool, error) {
+	left, err := materialize(ctx, e, left)
+	if err != nil {
+		return false, err
+	}
+	right, err = materialize(ctx, e, right)
+	if err != nil {
+		return false, err
+	}
+
+	c := compareValues(left, right)
+	switch op {
+	case tEqual:
+		return c == 0, nil
+	case tNotEqual:
+		return c != 0, nil
+	case tLess:
+		return c < 0, nil
+	case tLessEqual:
+		return c <= 0, nil
+	case tGreater:
+		return c > 0, nil
+	case tGreaterEqual:
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparison operator: %s", op)
+}
+
+// materializeArray materializes an array-like input for a builtin that
+// requires an array
+func materializeArray(ctx context.Context, e *env, name string, in interface{}) ([]interface{}, error) {
+	v, err := materialize(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot %s %s, as it is not an array", name, typeName(v))
+	}
+	return arr, nil
+}
+
+// fSort sorts an array by jq's total ordering of values
+type fSort byte
+
+func (f fSort) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "sort", in)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(arr, func(i, j int) bool { return compareValues(arr[i], arr[j]) < 0 })
+	return arr, nil
+}
+
+// sortKeys computes the key of each element of an array for the *_by
+// builtins. keys are the array of every output of the key filter, so filters
+// producing multiple values compare lexicographically
+func sortKeys(ctx context.Context, e *env, f filter, arr []interface{}) ([]interface{}, error) {
+	keys := make([]interface{}, len(arr))
+	for i, v := range arr {
+		out, err := e.eval(ctx, f, v)
+		if err != nil {
+			return nil, err
+		}
+		key := []interface{}{}
+		if err = eachOutput(out, func(x interface{}) error {
+			x, err := materialize(ctx, e, x)
+			key = append(key, x)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// sortByKeys stably sorts an array by precomputed keys, returning the sorted
+// elements & keys
+func sortByKeys(arr, keys []interface{}) ([]interface{}, []interface{}) {
+	idx := make([]int, len(arr))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return compareValues(keys[idx[i]], keys[idx[j]]) < 0 })
+
+	sorted, sortedKeys := make([]interface{}, len(arr)), make([]interface{}, len(arr))
+	for i, j := range idx {
+		sorted[i], sortedKeys[i] = arr[j], keys[j]
+	}
+	return sorted, sortedKeys
+}
+
+// fSortBy sorts an array by the result of a key filter. elements with equal
+// keys keep their original order
+type fSortBy struct {
+	key filter
+}
+
+func (f fSortBy) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "sort", in)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sortKeys(ctx, e, f.key, arr)
+	if err != nil {
+		return nil, err
+	}
+	sorted, _ := sortByKeys(arr, keys)
+	return sorted, nil
+}
+
+// fUnique sorts an array, removing duplicate values
+type fUnique byte
+
+func (f fUnique) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "unique", in)
+	if err != nil {
+		return nil, err
+	}
+	sorted, keys := sortByKeys(arr, arr)
+	return dedupe(sorted, keys), nil
+}
+
+// fUniqueBy sorts an array by the result of a key filter, keeping the first
+// element with each key
+type fUniqueBy struct {
+	key filter
+}
+
+func (f fUniqueBy) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "unique", in)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sortKeys(ctx, e, f.key, arr)
+	if err != nil {
+		return nil, err
+	}
+	sorted, keys := sortByKeys(arr, keys)
+	return dedupe(sorted, keys), nil
+}
+
+// dedupe drops elements of a sorted array whose key equals the key of the
+// element before it
+func dedupe(sorted, keys []interface{}) []interface{} {
+	res := []interface{}{}
+	for i, v := range sorted {
+		if i == 0 || compareValues(keys[i-1], keys[i]) != 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// extreme picks the element of an array with the smallest or largest key.
+// ties pick the first element for the smallest key and the last for the
+// largest. an empty array has no extreme, giving null
+func extreme(arr, keys []interface{}, largest bool) interface{} {
+	var res, resKey interface{}
+	for i, v := range arr {
+		c := 0
+		if i > 0 {
+			c = compareValues(keys[i], resKey)
+		}
+		if i == 0 || (largest && c >= 0) || (!largest && c < 0) {
+			res, resKey = v, keys[i]
+		}
+	}
+	return res
+}
+
+// fMin is the smallest element of an array
+type fMin byte
+
+func (f fMin) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "find the minimum of", in)
+	if err != nil {
+		return nil, err
+	}
+	return extreme(arr, arr, false), nil
+}
+
+// fMax is the largest element of an array
+type fMax byte
+
+func (f fMax) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "find the maximum of", in)
+	if err != nil {
+		return nil, err
+	}
+	return extreme(arr, arr, true), nil
+}
+
+// fMinBy is the element of an array with the smallest key, the first
+// element wins ties
+type fMinBy struct {
+	key filter
+}
+
+func (f fMinBy) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "find the minimum of", in)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sortKeys(ctx, e, f.key, arr)
+	if err != nil {
+		return nil, err
+	}
+	return extreme(arr, keys, false), nil
+}
+
+// fMaxBy is the element of an array with the largest key, the last element
+// wins ties
+type fMaxBy struct {
+	key filter
+}
+
+func (f fMaxBy) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arr, err := materializeArray(ctx, e, "find the maximum of", in)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sortKeys(ctx, e, f.key, arr)
+	if err != nil {
+		return nil, err
+	}
+	return extreme(arr, keys, true), nil
+}
+
+// contains reports whether a contains b following jq's recursive rules:
+// strings contain their substrings, arrays contain b when every element of b
+// is contained by some element of a, and objects contain b when every key of
+// b is present with a value containing b's value. other values contain only
+// equal values, and values of different types never contain one another.
+// a & b must be materialized
+func contains(a, b interface{}) bool {
+	if typeName(a) != typeName(b) {
+		return false
+	}
+
+	switch x := a.(type) {
+	case string, []byte:
+		return strings.Contains(stringValue(x), stringValue(b))
+	case []interface{}:
+		for _, bv := range b.([]interface{}) {
+			found := false
+			for _, av := range x {
+				if found = contains(av, bv); found {
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for key, bv := range b.(map[string]interface{}) {
+			if av, ok := x[key]; !ok || !contains(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return compareValues(a, b) == 0
+}
+
+// fContains checks whether its input contains the value of an argument
+type fContains struct {
+	arg filter
+	// inside reverses the check, testing whether the input is contained by
+	// the argument
+	inside bool
+}
+
+func (f fContains) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	arg, err := argValue(ctx, e, f.arg, in)
+	if err != nil {
+		return nil, err
+	}
+	a, err := materialize(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	if arg, err = materialize(ctx, e, arg); err != nil {
+		return nil, err
+	}
+	if f.inside {
+		a, arg = arg, a
+	}
+	if ta, tb := typeName(a), typeName(arg); ta != tb {
+		return nil, fmt.Errorf("%s and %s cannot have their containment checked", ta, tb)
+	}
+	return contains(a, arg), nil
+}
@@ -6,58 +6,209 @@ import (
 	"io"
 	"io/ioutil"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/qri-io/value"
 )
 
-// Filter applies piplelined transformations to an input values
+// Filter applies piplelined transformations to an input values. filters
+// are parsed once when they're created & can be applied concurrently
 type Filter struct {
 	src      string
 	resolver value.Resolver
+	opts     Options
+
+	filters  []filter
+	parseErr error
+}
+
+// Options configures the behaviour of a filter
+type Options struct {
+	// Tracer, when set, observes the application of every filter node
+	Tracer Tracer
+	// DecodeJSONReaders makes key selectors decode ByteReader inputs as JSON
+	// before selecting. Readers can only be read once, so each reader is
+	// decoded a single time & the decoded value is kept for the duration of
+	// a call to Apply
+	DecodeJSONReaders bool
+	// CaseInsensitiveKeys makes key selectors match object keys regardless of
+	// case. Exact matches are preferred, otherwise when multiple keys match
+	// the first in sorted order is selected
+	CaseInsensitiveKeys bool
+	// AutoResolveLinks resolves any links in the output of Apply with the
+	// filter's resolver, including links nested within other values. Links
+	// that refer back to a link being resolved are left unresolved to break
+	// cycles. Maps, Arrays & Iterators containing links become native maps &
+	// slices
+	AutoResolveLinks bool
+	// CacheResolvedLinks resolves links in the output of Apply like
+	// AutoResolveLinks, but keeps links in the output instead of replacing
+	// them. resolved values are cached on each link, and can be read with the
+	// link's Value method
+	CacheResolvedLinks bool
 }
 
-// New creates a new Filter
-func New(filterStr string, resolver value.Resolver) *Filter {
-	return &Filter{
+// New creates a new Filter. filters that fail to parse return the parse
+// error from Apply, use Compile to check for errors up front
+func New(filterStr string, resolver value.Resolver, opts ...func(o *Options)) *Filter {
+	f := &Filter{
 		src:      filterStr,
 		resolver: resolver,
 	}
+	for _, opt := range opts {
+		opt(&f.opts)
+	}
+
+	p := parser{s: newScanner(strings.NewReader(filterStr))}
+	f.filters, f.parseErr = p.filters()
+	return f
+}
+
+// Compile creates a new Filter, returning an error if the filter string
+// can't be parsed
+func Compile(filterStr string, resolver value.Resolver, opts ...func(o *Options)) (*Filter, error) {
+	f := New(filterStr, resolver, opts...)
+	if f.parseErr != nil {
+		return nil, f.parseErr
+	}
+	return f, nil
 }
 
 // Apply executes a filter string against a given source, returning a filtered result
 func (filt *Filter) Apply(ctx context.Context, source interface{}) (val interface{}, err error) {
-	// fmt.Printf("parse %s\n", filterStr)
-	r := strings.NewReader(filt.src)
-	p := parser{s: newScanner(r)}
-	filters, err := p.filters()
-	if err != nil {
-		return nil, err
+	if filt.parseErr != nil {
+		return nil, filt.parseErr
 	}
 
+	e := filt.newEnv()
 	val = source
-	for _, f := range filters {
+	for _, f := range filt.filters {
 		// fmt.Printf("run filter: %#v\n", f)
-		if val, err = f.apply(ctx, filt.resolver, val); err != nil {
+		if val, err = e.eval(ctx, f, val); err != nil {
 			// panic(err)
 			return val, err
 		}
 		// fmt.Printf("result: %#v\n", val)
 	}
 
-	return unpackValueStreams(val)
+	if val, err = unpackValueStreams(ctx, val); err != nil {
+		return nil, err
+	}
+	if filt.opts.AutoResolveLinks || filt.opts.CacheResolvedLinks {
+		return resolveAll(ctx, e, val, map[string]bool{})
+	}
+	return val, nil
+}
+
+// newEnv creates the environment for a single application of a filter
+func (filt *Filter) newEnv() *env {
+	return &env{
+		resolver: filt.resolver,
+		opts:     filt.opts,
+		tracer:   scopeTracer(filt.opts.Tracer),
+		decoded:  map[value.ByteReader]interface{}{},
+		regexps:  map[string]*regexp.Regexp{},
+	}
 }
 
 type filter interface {
-	apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error)
+	apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error)
+}
+
+// env is the environment filters are applied within
+type env struct {
+	resolver value.Resolver
+	opts     Options
+	tracer   Tracer
+	// decoded caches the values of ByteReaders decoded as JSON
+	decoded map[value.ByteReader]interface{}
+	// regexps caches compiled regular expressions by pattern & flags
+	regexps map[string]*regexp.Regexp
+	// vars are the variables in scope
+	vars *scope
+}
+
+// scope is a variable binding, linked to the bindings of enclosing scopes
+type scope struct {
+	name   string
+	value  interface{}
+	parent *scope
+}
+
+// bind creates a child environment with a variable bound to a value
+func (e *env) bind(name string, v interface{}) *env {
+	child := *e
+	child.vars = &scope{name: name, value: v, parent: e.vars}
+	return &child
+}
+
+// lookup finds the value of the innermost variable with a name
+func (e *env) lookup(name string) (v interface{}, ok bool) {
+	for s := e.vars; s != nil; s = s.parent {
+		if s.name == name {
+			return s.value, true
+		}
+	}
+	return nil, false
+}
+
+// eval applies a filter to an input. all filters that apply other filters
+// must do so through eval
+func (e *env) eval(ctx context.Context, f filter, in interface{}) (out interface{}, err error) {
+	if e.tracer == nil {
+		return f.apply(ctx, e, in)
+	}
+	// filters fan streams out, evaluating each value. only trace the values
+	if _, ok := in.(*valueStream); ok {
+		return f.apply(ctx, e, in)
+	}
+
+	node := describe(f)
+	e.tracer.Enter(node, in)
+	out, err = f.apply(ctx, e, in)
+	e.tracer.Exit(node, in, out, err)
+	return out, err
+}
+
+// decodeJSONReader reads a ByteReader as JSON, closing the reader. decoded
+// values are buffered so a reader can be decoded more than once
+func (e *env) decodeJSONReader(rdr value.ByteReader) (v interface{}, err error) {
+	cacheable := reflect.TypeOf(rdr).Comparable()
+	if cacheable {
+		if v, ok := e.decoded[rdr]; ok {
+			return v, nil
+		}
+	}
+
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = value.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		if e.decoded == nil {
+			e.decoded = map[value.ByteReader]interface{}{}
+		}
+		e.decoded[rdr] = v
+	}
+	return v, nil
 }
 
-func unpackValueStreams(in interface{}) (val interface{}, err error) {
+func unpackValueStreams(ctx context.Context, in interface{}) (val interface{}, err error) {
 	if vs, ok := in.(*valueStream); ok {
 		vals := []interface{}{}
 		var v interface{}
 		for vs.Next(&v) {
-			if val, err = unpackValueStreams(v); err != nil {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			if val, err = unpackValueStreams(ctx, v); err != nil {
 				return nil, err
 			}
 			vals = append(vals, val)
@@ -70,26 +221,70 @@ func unpackValueStreams(in interface{}) (val interface{}, err error) {
 
 type fStringLiteral string
 
-func (f fStringLiteral) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fStringLiteral) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	if v, ok := in.(*valueStream); ok {
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	}
 	return string(f), nil
 }
 
 type fNumericLiteral float64
 
-func (f fNumericLiteral) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
-	return f, nil
+func (f fNumericLiteral) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if v, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, v, f)
+	}
+	return float64(f), nil
+}
+
+// fBoolLiteral is the literal true or false
+type fBoolLiteral bool
+
+func (f fBoolLiteral) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if v, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, v, f)
+	}
+	return bool(f), nil
+}
+
+// fNullLiteral is the literal null
+type fNullLiteral byte
+
+func (f fNullLiteral) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if v, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, v, f)
+	}
+	return nil, nil
+}
+
+// fVariable is a reference to a variable bound in an enclosing scope
+type fVariable string
+
+func (f fVariable) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	v, ok := e.lookup(string(f))
+	if !ok {
+		return nil, fmt.Errorf("$%s is not defined", string(f))
+	}
+	return v, nil
 }
 
 type fLength byte
 
-func (f fLength) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fLength) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if arr, ok := in.(value.Array); ok {
+		in = arr.Iterate()
+	}
 
 	if it, ok := in.(value.Iterator); ok {
 		i := 0
 		for it.Next() {
+			if err = ctx.Err(); err != nil {
+				it.Close()
+				return nil, err
+			}
 			i++
 		}
 		return i, it.Close()
@@ -97,7 +292,7 @@ func (f fLength) apply(ctx context.Context, r value.Resolver, in interface{}) (o
 
 	switch v := in.(type) {
 	case *valueStream:
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	case string:
 		return len(v), nil
 	case []byte:
@@ -123,10 +318,10 @@ type selector interface {
 
 type fSelector []selector
 
-func (f fSelector) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fSelector) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	out = in
 	for _, sel := range f {
-		out, err = sel.apply(ctx, r, out)
+		out, err = e.eval(ctx, sel, out)
 		if err != nil {
 			return out, err
 		}
@@ -134,12 +329,46 @@ func (f fSelector) apply(ctx context.Context, r value.Resolver, in interface{})
 	return out, err
 }
 
+// fPipe is a sequence of filters, where the output of each filter is the
+// input to the next
+type fPipe []filter
+
+func (f fPipe) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	out = in
+	for _, fi := range f {
+		if out, err = e.eval(ctx, fi, out); err != nil {
+			return out, err
+		}
+	}
+	return out, err
+}
+
+// fComma produces the outputs of each of its filters in turn, all applied to
+// the same input
+type fComma []filter
+
+func (f fComma) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if v, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, v, f)
+	}
+
+	vals := []interface{}{}
+	err = f.generate(ctx, e, in, func(v interface{}) error {
+		vals = append(vals, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStream(vals)
+}
+
 // fIdentity is the identity filter, it returns whatever it's given
 type fIdentity byte
 
 func (f fIdentity) isSelector() {}
 
-func (f fIdentity) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fIdentity) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	return in, nil
 }
 
@@ -147,15 +376,27 @@ type fKeySelector string
 
 func (f fKeySelector) isSelector() {}
 
-func (f fKeySelector) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fKeySelector) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 
 	if link, ok := in.(value.Link); ok {
-		in, err = r.Resolve(ctx, link)
+		in, err = resolveLink(ctx, e, link)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if rdr, ok := in.(value.ByteReader); ok && e.opts.DecodeJSONReaders {
+		if in, err = e.decodeJSONReader(rdr); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.opts.CaseInsensitiveKeys {
+		if f, err = f.foldKey(in); err != nil {
+			return nil, err
+		}
+	}
+
 	if m, ok := in.(value.Map); ok {
 		v, _ := m.ValueForKey(string(f))
 		return v, nil
@@ -163,7 +404,7 @@ func (f fKeySelector) apply(ctx context.Context, r value.Resolver, in interface{
 
 	switch v := in.(type) {
 	case *valueStream:
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	case map[interface{}]interface{}:
 		return v[string(f)], err
 	case map[string]interface{}:
@@ -171,7 +412,7 @@ func (f fKeySelector) apply(ctx context.Context, r value.Resolver, in interface{
 	case []interface{}:
 		res := make([]interface{}, len(v))
 		for i, d := range v {
-			res[i], err = f.apply(ctx, r, d)
+			res[i], err = f.apply(ctx, e, d)
 			if err != nil {
 				return nil, err
 			}
@@ -215,22 +456,132 @@ func (f fKeySelector) apply(ctx context.Context, r value.Resolver, in interface{
 	return nil, fmt.Errorf("unexpected type: %T", in)
 }
 
+// fOptionalKeySelector is a key selector written with a trailing "?". inputs
+// that aren't maps or links produce no output instead of null
+type fOptionalKeySelector string
+
+func (f fOptionalKeySelector) isSelector() {}
+
+func (f fOptionalKeySelector) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := in.(type) {
+	case *valueStream:
+		return applyToStream(ctx, e, v, f)
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		return fKeySelector(f).apply(ctx, e, in)
+	case value.ByteReader:
+		if e.opts.DecodeJSONReaders {
+			if in, err = e.decodeJSONReader(v); err != nil {
+				return nil, err
+			}
+			return f.apply(ctx, e, in)
+		}
+	}
+
+	return newStream([]interface{}{})
+}
+
+// foldKey finds the key in a map-like input that matches the selector
+// ignoring case. An exact match is always preferred. When more than one key
+// matches ignoring case, the first matching key in sorted order is picked.
+// inputs that aren't maps & maps without a match return the selector as-is
+func (f fKeySelector) foldKey(in interface{}) (fKeySelector, error) {
+	var keys []string
+	switch v := in.(type) {
+	case map[string]interface{}:
+		if _, ok := v[string(f)]; ok {
+			return f, nil
+		}
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[interface{}]interface{}:
+		if _, ok := v[string(f)]; ok {
+			return f, nil
+		}
+		for key := range v {
+			if s, ok := key.(string); ok {
+				keys = append(keys, s)
+			}
+		}
+	case value.Map:
+		it := v.Iterate()
+		for it.Next() {
+			if s, ok := it.Key().(string); ok {
+				if s == string(f) {
+					it.Close()
+					return f, nil
+				}
+				keys = append(keys, s)
+			}
+		}
+		if err := it.Close(); err != nil {
+			return f, err
+		}
+	default:
+		return f, nil
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.EqualFold(key, string(f)) {
+			return fKeySelector(key), nil
+		}
+	}
+	return f, nil
+}
+
+// iterableArray reports if a value is an Array that isn't also a Map. Maps
+// satisfy the Array interface, but aren't positionally indexable
+func iterableArray(in interface{}) (value.Array, bool) {
+	if _, ok := in.(value.Map); ok {
+		return nil, false
+	}
+	arr, ok := in.(value.Array)
+	return arr, ok
+}
+
 type fIndexSelector int
 
 func (f fIndexSelector) isSelector() {}
 
-func (f fIndexSelector) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fIndexSelector) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 
 	if link, ok := in.(value.Link); ok {
-		in, err = r.Resolve(ctx, link)
+		in, err = resolveLink(ctx, e, link)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if arr, ok := iterableArray(in); ok {
+		in = arr.Iterate()
+	}
+
 	if it, ok := in.(value.Iterator); ok {
+		if f < 0 {
+			// indexes counted from the end need the length of the sequence
+			vals, err := collect(ctx, e, it)
+			if err != nil {
+				return nil, err
+			}
+			if i, ok := f.index(len(vals)); ok {
+				return vals[i], nil
+			}
+			return nil, nil
+		}
+
 		i := 0
 		for it.Next() {
+			if err = ctx.Err(); err != nil {
+				it.Close()
+				return nil, err
+			}
 			if i == int(f) {
 				if err = it.Scan(&out); err != nil {
 					return nil, err
@@ -244,13 +595,22 @@ func (f fIndexSelector) apply(ctx context.Context, r value.Resolver, in interfac
 
 	switch v := in.(type) {
 	case *valueStream:
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	case string:
-		return v[int(f)], nil
+		if i, ok := f.index(len(v)); ok {
+			return v[i], nil
+		}
+		return nil, nil
 	case []byte:
-		return v[int(f)], nil
+		if i, ok := f.index(len(v)); ok {
+			return v[i], nil
+		}
+		return nil, nil
 	case []interface{}:
-		return v[int(f)], nil
+		if i, ok := f.index(len(v)); ok {
+			return v[i], nil
+		}
+		return nil, nil
 
 	case nil, bool, byte, int, float64, map[string]interface{}, map[interface{}]interface{}:
 		// TODO (b5) - should we error here?
@@ -276,57 +636,142 @@ func (f fIndexSelector) apply(ctx context.Context, r value.Resolver, in interfac
 	return nil, fmt.Errorf("unexpected type: %T", in)
 }
 
+// index returns the position this selector points to in a sequence of
+// length n, counting negative indexes from the end of the sequence. ok is
+// false if the index is out of range
+func (f fIndexSelector) index(n int) (i int, ok bool) {
+	i = int(f)
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
+
+// fIterateAllSeletor produces each element of its input. true marks the
+// optional form .[]?, which produces nothing for inputs that can't be iterated.
+// apply reads Iterator & Array inputs whole, filters that consume outputs one
+// at a time use generate instead
 type fIterateAllSeletor bool
 
 func (f fIterateAllSeletor) isSelector() {}
 
-func (f fIterateAllSeletor) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fIterateAllSeletor) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	if link, ok := in.(value.Link); ok {
-		in, err = r.Resolve(ctx, link)
+		in, err = resolveLink(ctx, e, link)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if it, ok := in.(value.Iterator); ok {
-		return it, nil
+	switch in.(type) {
+	case *valueStream:
+		if f {
+			return applyToStream(ctx, e, in.(*valueStream), f)
+		}
+	case value.Iterator, value.Array:
+		vals, err := collect(ctx, e, in)
+		if err != nil {
+			return nil, err
+		}
+		return newStream(vals)
+	}
+	if bool(f) && isScalar(in) {
+		return newStream([]interface{}{})
 	}
 
 	return newStream(in)
 }
 
+// fGlobSelector selects all values of an object in sorted key order, or all
+// elements of an array
+type fGlobSelector byte
+
+func (f fGlobSelector) isSelector() {}
+
+func (f fGlobSelector) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := in.(type) {
+	case *valueStream:
+		return applyToStream(ctx, e, v, f)
+	case []interface{}:
+		return newStream(v)
+	case value.Map:
+		return f.apply(ctx, e, v.Iterate())
+	case value.Iterator, value.Array:
+		vals, err := collect(ctx, e, v)
+		if err != nil {
+			return nil, err
+		}
+		return newStream(vals)
+	case map[string]interface{}, map[interface{}]interface{}:
+		obj, err := toObject(v)
+		if err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, 0, len(obj))
+		for _, key := range sortedKeys(obj) {
+			vals = append(vals, obj[key])
+		}
+		return newStream(vals)
+	}
+
+	return nil, fmt.Errorf("cannot select all values of %s", typeName(in))
+}
+
 type fIndexRangeSelector struct {
 	start int
 	stop  int
 	all   bool
+	// open is set when the range has no stop, selecting through the end
+	open bool
 }
 
 func (f *fIndexRangeSelector) isSelector() {}
 
-func (f *fIndexRangeSelector) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f *fIndexRangeSelector) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	if link, ok := in.(value.Link); ok {
-		in, err = r.Resolve(ctx, link)
+		in, err = resolveLink(ctx, e, link)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if arr, ok := iterableArray(in); ok {
+		in = arr.Iterate()
+	}
+
 	if it, ok := in.(value.Iterator); ok {
+		if f.start < 0 || f.stop < 0 {
+			// positions counted from the end need the length of the sequence
+			vals, err := collect(ctx, e, it)
+			if err != nil {
+				return nil, err
+			}
+			start, stop := f.bounds(len(vals))
+			return vals[start:stop], nil
+		}
+
+		// read only as far as the end of the range
 		res := []interface{}{}
-		offset := f.start
-		limit := f.stop
-		for it.Next() {
-			if offset--; offset > 0 {
+		for i := 0; (f.open || i < f.stop) && it.Next(); i++ {
+			if err = ctx.Err(); err != nil {
+				it.Close()
+				return nil, err
+			}
+			if i < f.start {
 				continue
 			}
 			var v interface{}
 			if err = it.Scan(&v); err != nil {
+				it.Close()
 				return nil, err
 			}
 			res = append(res, v)
-			if limit--; limit == 0 {
-				break
-			}
 		}
 		return res, it.Close()
 	}
@@ -334,42 +779,60 @@ func (f *fIndexRangeSelector) apply(ctx context.Context, r value.Resolver, in in
 	if rdr, ok := in.(io.ReadCloser); ok {
 		defer rdr.Close()
 
-		var buf []byte
+		if f.start < 0 || f.stop < 0 {
+			// positions counted from the end need the length of the data
+			data, err := ioutil.ReadAll(rdr)
+			if err != nil {
+				return nil, err
+			}
+			start, stop := f.bounds(len(data))
+			return data[start:stop], nil
+		}
+
+		// readers can return less than asked for from a single read, skip &
+		// read whole lengths. ranges past the end of the data are clamped
 		if f.start > 0 {
-			buf = make([]byte, f.start)
-			if _, err = rdr.Read(buf); err != nil {
+			if _, err = io.CopyN(ioutil.Discard, rdr, int64(f.start)); err == io.EOF {
+				return []byte{}, nil
+			} else if err != nil {
 				return nil, err
 			}
 		}
-		if f.stop == 0 {
+		if f.open {
 			return ioutil.ReadAll(rdr)
 		}
-		buf = make([]byte, f.stop)
-		_, err = rdr.Read(buf)
-		return buf, err
+		if f.stop <= f.start {
+			return []byte{}, nil
+		}
+		buf := make([]byte, f.stop-f.start)
+		n, err := io.ReadFull(rdr, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return buf[:n], err
 	}
 
 	switch v := in.(type) {
 	case *valueStream:
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	case string:
 		if f.all {
 			return v, nil
 		}
-		return v[f.start:f.stop], nil
+		start, stop := f.bounds(len(v))
+		return v[start:stop], nil
 	case []byte:
 		if f.all {
 			return v, nil
 		}
-		return v[f.start:f.stop], nil
+		start, stop := f.bounds(len(v))
+		return v[start:stop], nil
 	case []interface{}:
 		if f.all {
 			return v, nil
 		}
-		if f.stop == 0 {
-			return v[f.start:], nil
-		}
-		return v[f.start:f.stop], nil
+		start, stop := f.bounds(len(v))
+		return v[start:stop], nil
 
 	case nil, bool, byte, int, float64, map[string]interface{}, map[interface{}]interface{}:
 		// TODO (b5) - should we error here?
@@ -379,39 +842,233 @@ func (f *fIndexRangeSelector) apply(ctx context.Context, r value.Resolver, in in
 	return nil, fmt.Errorf("unexpected type: %T", in)
 }
 
+// bounds clamps the range to a sequence of length n. open ranges select
+// through the end of the sequence, and negative positions count from the end
+func (f *fIndexRangeSelector) bounds(n int) (start, stop int) {
+	start, stop = f.start, f.stop
+	if f.open {
+		stop = n
+	}
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+
+	if start < 0 {
+		start = 0
+	} else if start > n {
+		start = n
+	}
+	if stop > n {
+		stop = n
+	} else if stop < start {
+		stop = start
+	}
+	return start, stop
+}
+
 type fBinaryOp struct {
 	left  filter
 	op    tokenType
 	right filter
 }
 
-func (f fBinaryOp) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
-	left, err := f.left.apply(ctx, r, in)
+// apply produces a result for each combination of the outputs of the left &
+// right operands. like jq, left outputs vary fastest
+func (f fBinaryOp) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if v, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, v, f)
+	}
+
+	left, err := e.eval(ctx, f.left, in)
+	if err != nil {
+		return nil, err
+	}
+	var lefts []interface{}
+	if err = eachOutput(left, func(lv interface{}) error {
+		lefts = append(lefts, lv)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	right, err := e.eval(ctx, f.right, in)
 	if err != nil {
 		return nil, err
 	}
-	left, lk := normalizeValue(left)
 
-	right, err := f.right.apply(ctx, r, in)
+	res := []interface{}{}
+	err = eachOutput(right, func(rv interface{}) error {
+		for _, lv := range lefts {
+			v, err := f.operate(ctx, e, lv, rv)
+			if err != nil {
+				return err
+			}
+			res = append(res, v)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	right, rk := normalizeValue(right)
+	if len(res) == 1 {
+		return res[0], nil
+	}
+	return newStream(res)
+}
+
+// operate applies the operator to a single pair of values
+func (f fBinaryOp) operate(ctx context.Context, e *env, left, right interface{}) (out interface{}, err error) {
+	left, lk := normalizeValue(left)
 
 	switch f.op {
-	case tStar:
-		if lk == reflect.Float64 && rk == reflect.Float64 {
-			return left.(float64) * right.(float64), nil
+	case tEqual, tNotEqual, tLess, tLessEqual, tGreater, tGreaterEqual:
+		return compare(ctx, e, f.op, left, right)
+	}
+	right, rk := normalizeValue(right)
+
+	if f.op == tPlus {
+		return add(ctx, e, left, right)
+	}
+
+	if lk == reflect.Float64 && rk == reflect.Float64 {
+		l, r := left.(float64), right.(float64)
+		switch f.op {
+		case tStar:
+			return l * r, nil
+		case tMinus:
+			return l - r, nil
+		case tForwardSlash:
+			if r == 0 {
+				return nil, fmt.Errorf("cannot divide %v by zero", l)
+			}
+			return l / r, nil
+		}
+	}
+
+	return nil, newBinaryOpError(f.op, left, right)
+}
+
+// add combines two values following jq's addition rules: numbers sum,
+// strings concatenate, arrays append, objects merge with keys from right
+// replacing keys from left, and null added to anything is the other value
+func add(ctx context.Context, e *env, left, right interface{}) (interface{}, error) {
+	left, _ = normalizeValue(left)
+	right, _ = normalizeValue(right)
+	if left == nil {
+		return right, nil
+	} else if right == nil {
+		return left, nil
+	}
+
+	switch typeName(left) {
+	case "number":
+		if l, ok := toFloat(left); ok {
+			if r, ok := toFloat(right); ok {
+				return l + r, nil
+			}
+		}
+	case "string":
+		if typeName(right) == "string" {
+			return stringValue(left) + stringValue(right), nil
+		}
+	case "array":
+		if typeName(right) == "array" {
+			l, err := collect(ctx, e, left)
+			if err != nil {
+				return nil, err
+			}
+			r, err := collect(ctx, e, right)
+			if err != nil {
+				return nil, err
+			}
+			return append(append(make([]interface{}, 0, len(l)+len(r)), l...), r...), nil
 		}
-	case tPlus:
-		if lk == reflect.Float64 && rk == reflect.Float64 {
-			return left.(float64) + right.(float64), nil
+	case "object":
+		if typeName(right) == "object" {
+			l, err := toObject(left)
+			if err != nil {
+				return nil, err
+			}
+			r, err := toObject(right)
+			if err != nil {
+				return nil, err
+			}
+			res := make(map[string]interface{}, len(l)+len(r))
+			for key, v := range l {
+				res[key] = v
+			}
+			for key, v := range r {
+				res[key] = v
+			}
+			return res, nil
 		}
 	}
 
-	return nil, fmt.Errorf("binary operations are not finished cannot %#v %s %#v", left, f.op, right)
+	return nil, newBinaryOpError(tPlus, left, right)
+}
+
+// BinaryOpError is returned when a binary operator is applied to operands
+// of incompatible types
+type BinaryOpError struct {
+	Op    string
+	Left  string
+	Right string
+}
+
+// newBinaryOpError creates a BinaryOpError from an operator token & operands
+func newBinaryOpError(op tokenType, left, right interface{}) error {
+	return BinaryOpError{
+		Op:    op.String(),
+		Left:  typeName(left),
+		Right: typeName(right),
+	}
+}
+
+// Error implements the error interface
+func (e BinaryOpError) Error() string {
+	verb := e.Op
+	switch e.Op {
+	case tStar.String():
+		verb = "multiply"
+	case tPlus.String():
+		verb = "add"
+	case tMinus.String():
+		verb = "subtract"
+	case tForwardSlash.String():
+		verb = "divide"
+	}
+	return fmt.Sprintf("cannot %s %s and %s", verb, e.Left, e.Right)
+}
+
+// typeName gives the name of the kind of value v is, using jq's type names.
+// byte (uint8) values are numbers, []byte values are (byte) strings
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case byte, int, float64, fNumericLiteral:
+		return "number"
+	case string, []byte, fStringLiteral:
+		return "string"
+	// Maps also satisfy the Array interface, check for objects first
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		return "object"
+	case []interface{}, value.Array, value.Iterator:
+		return "array"
+	case *valueStream:
+		return "stream"
+	case value.Link:
+		return "link"
+	}
+	return fmt.Sprintf("%T", v)
 }
 
+// normalizeValue converts numbers to float64 for arithmetic. a lone byte is a
+// number, while a []byte is a byte string & is left as-is
 func normalizeValue(in interface{}) (out interface{}, rk reflect.Kind) {
 	if nl, ok := in.(fNumericLiteral); ok {
 		return float64(nl), reflect.Float64
@@ -419,6 +1076,12 @@ func normalizeValue(in interface{}) (out interface{}, rk reflect.Kind) {
 		return string(sl), reflect.String
 	}
 
+	if in == nil {
+		return nil, reflect.Invalid
+	} else if b, ok := in.(byte); ok {
+		return float64(b), reflect.Float64
+	}
+
 	rk = reflect.TypeOf(in).Kind()
 	switch rk {
 	case reflect.Int:
@@ -435,39 +1098,83 @@ type fSlice []filter
 
 func (fSlice) isSelector() {}
 
-func (f fSlice) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fSlice) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	if link, ok := in.(value.Link); ok {
-		in, err = r.Resolve(ctx, link)
+		in, err = resolveLink(ctx, e, link)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if v, ok := in.(*valueStream); ok {
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	}
 
-	vals := make([]interface{}, len(f))
-	for i, fi := range f {
-		if vals[i], err = fi.apply(ctx, r, in); err != nil {
+	// filters that produce streams contribute each of their values. elements
+	// are generated so iterators are read one value at a time
+	vals := make([]interface{}, 0, len(f))
+	for _, fi := range f {
+		err = generate(ctx, e, fi, in, func(x interface{}) error {
+			vals = append(vals, x)
+			return nil
+		})
+		if err != nil {
 			return nil, err
 		}
 	}
 	return vals, nil
 }
 
+// fObjectMapping constructs objects. values that produce more than one
+// output construct an object for each combination of outputs, with keys
+// varied in sorted order, the last key varying fastest
 type fObjectMapping map[string]filter
 
-func (f fObjectMapping) apply(ctx context.Context, r value.Resolver, in interface{}) (out interface{}, err error) {
+func (f fObjectMapping) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
 	if v, ok := in.(*valueStream); ok {
-		return applyToStream(ctx, r, v, f)
+		return applyToStream(ctx, e, v, f)
 	}
 
-	vals := map[string]interface{}{}
-	for key, f := range f {
-		if vals[key], err = f.apply(ctx, r, in); err != nil {
+	keys := make([]string, 0, len(f))
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	objs := []map[string]interface{}{{}}
+	for _, key := range keys {
+		v, err := e.eval(ctx, f[key], in)
+		if err != nil {
+			return nil, err
+		}
+		var outs []interface{}
+		if err = eachOutput(v, func(x interface{}) error {
+			outs = append(outs, x)
+			return nil
+		}); err != nil {
 			return nil, err
 		}
+
+		next := make([]map[string]interface{}, 0, len(objs)*len(outs))
+		for _, obj := range objs {
+			for _, x := range outs {
+				cp := make(map[string]interface{}, len(obj)+1)
+				for k, v := range obj {
+					cp[k] = v
+				}
+				cp[key] = x
+				next = append(next, cp)
+			}
+		}
+		objs = next
 	}
-	return vals, nil
+
+	if len(objs) == 1 {
+		return objs[0], nil
+	}
+	res := make([]interface{}, len(objs))
+	for i, obj := range objs {
+		res[i] = obj
+	}
+	return newStream(res)
 }
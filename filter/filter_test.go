@@ -4,9 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/qri-io/value"
 )
 
 // d for "data", this quick test function makes for cleaner test writing
@@ -62,10 +71,24 @@ func TestApply(t *testing.T) {
 					map[string]interface{}{"a": "c"}}}, []interface{}{"a", "b", "c"}},
 		{".bar * 5", map[string]interface{}{"bar": 5}, float64(25)},
 
-		// {"( .bar | length ) x 5", map[string]interface{}{ "bar": []string{"a","b","c"} }, 15},
+		{"(.bar | length) * 5", map[string]interface{}{"bar": []interface{}{"a", "b", "c"}}, float64(15)},
+		{"5 * (.bar | length)", map[string]interface{}{"bar": []interface{}{"a", "b", "c"}}, float64(15)},
+		{".bar * 5 + 1", map[string]interface{}{"bar": 5}, float64(26)},
+		{"1 + .bar * 5", map[string]interface{}{"bar": 5}, float64(26)},
+		{".bar * 5 | . + 1", map[string]interface{}{"bar": 5}, float64(26)},
+		{"(.a + .b) * 2", d(`{"a":1,"b":2}`), float64(6)},
+		{"( .items | length ) * 5", d(`{"items":[1,2,3]}`), float64(15)},
+		{"2 * ((.a + 1) - .b)", d(`{"a":3,"b":1}`), float64(6)},
+		{".a | (. + 1) * (. - 1)", d(`{"a":3}`), float64(8)},
 	}
 
 	runGoodCases(t, cases)
+
+	for _, str := range []string{`(.a`, `.a)`, `()`} {
+		if _, err := New(str, nil).Apply(context.Background(), nil); err == nil {
+			t.Errorf("expected unbalanced parens %q to error", str)
+		}
+	}
 }
 
 func TestPipe(t *testing.T) {
@@ -91,8 +114,7 @@ func TestArrayMapping(t *testing.T) {
 		{`[.]`, d(`["a","b","c"]`), d(`[["a","b","c"]]`)},
 		{"[ .foo, .bar ]", map[string]interface{}{"bar": "a", "foo": "b", "camp": "lucky"}, []interface{}{"b", "a"}},
 
-		// TODO (b5) - implicit array mapping
-		// {".foo, .bar", map[string]interface{}{"bar": "a", "foo": "b", "camp": "lucky"}, []interface{}{"b", "a"}},
+		{".foo, .bar", map[string]interface{}{"bar": "a", "foo": "b", "camp": "lucky"}, []interface{}{"b", "a"}},
 
 		// TODO (b5) - current parser will choke on floating point literals in first position
 		// {`[34.5, .]`, d("a"), d(`[34.5, "a"]`)},
@@ -101,16 +123,6 @@ func TestArrayMapping(t *testing.T) {
 	runGoodCases(t, cases)
 }
 
-func TestObjectMapping(t *testing.T) {
-	cases := []goodCase{
-		{`{ foo: . }`, d(`["a","b","c"]`), d(`{ "foo": ["a","b","c"] }`)},
-		{`{ foo: .[0], bar: .[1:] }`, d(`["a","b","c"]`), d(`{ "foo": "a", "bar": ["b","c"]}`)},
-		{`.[] | {"value": .}`, d(`["a","b","c"]`), d(`[{"value":"a"},{"value":"b"},{"value":"c"}]`)},
-	}
-
-	runGoodCases(t, cases)
-}
-
 func TestLength(t *testing.T) {
 	cases := []goodCase{
 		{`length`, d(`"abcde"`), 5},
@@ -118,8 +130,2995 @@ func TestLength(t *testing.T) {
 		{`length`, d(`{ "a": 0, "b": 1, "c": 2, "d": 3, "e": 4 }`), 5},
 		{`length`, []byte{0, 1, 2, 3, 4}, 5},
 		{`length`, map[interface{}]interface{}{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}, 5},
-		{`.[] | length`, d(`["abcde", "fg"]`), []interface{}{5, 2}},
 	}
 
 	runGoodCases(t, cases)
 }
+
+func TestOutputValues(t *testing.T) {
+	cases := []struct {
+		filter string
+		source interface{}
+	}{
+		{".", 1},
+		{"5", nil},
+		{"[5, .]", "a"},
+		{"{ a: 5 }", nil},
+	}
+
+	for _, c := range cases {
+		got, err := New(c.filter, nil).Apply(context.Background(), c.source)
+		if err != nil {
+			t.Fatalf("%s error: %s", c.filter, err)
+		}
+		if !value.IsValue(got) {
+			t.Errorf("%s expected output to be a value. got: %#v", c.filter, got)
+		}
+		if vals, ok := got.([]interface{}); ok {
+			for _, v := range vals {
+				if _, ok := v.(fNumericLiteral); ok {
+					t.Errorf("%s output contains a numeric literal: %#v", c.filter, got)
+				}
+			}
+		}
+	}
+}
+
+func TestKeywordLiterals(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`true`, nil, true},
+		{`false`, nil, false},
+		{`null`, d(`1`), nil},
+		{`[true, false, null]`, nil, d(`[true,false,null]`)},
+		{`{a: false}`, nil, d(`{"a":false}`)},
+		{`.[] | true`, d(`[1,2]`), d(`[true,true]`)},
+	})
+}
+
+func TestStringLiteralOutput(t *testing.T) {
+	cases := []struct {
+		filter string
+		source interface{}
+	}{
+		{`"swoosh"`, nil},
+		{`.[] | "swoosh"`, d(`[1]`)},
+		{`["swoosh"]`, nil},
+		{`{ a: "swoosh" }`, nil},
+	}
+
+	for _, c := range cases {
+		got, err := New(c.filter, nil).Apply(context.Background(), c.source)
+		if err != nil {
+			t.Fatalf("%s error: %s", c.filter, err)
+		}
+		var str interface{}
+		switch v := got.(type) {
+		case []interface{}:
+			str = v[0]
+		case map[string]interface{}:
+			str = v["a"]
+		default:
+			str = v
+		}
+		if typ := reflect.TypeOf(str); typ != reflect.TypeOf("") {
+			t.Errorf("%s expected output type to be string. got: %s", c.filter, typ)
+		}
+	}
+}
+
+func TestBinaryOps(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`5 - 2`, nil, float64(3)},
+		{`10 / 4`, nil, 2.5},
+		{`.a - .b`, d(`{"a":1,"b":3}`), float64(-2)},
+		{`.total / .count`, d(`{"total":9,"count":3}`), float64(3)},
+		{`10 - 2 - 3`, nil, float64(5)},
+		{`12 / 2 / 3`, nil, float64(2)},
+		{`1 + 2 * 3 - 4 / 2`, nil, float64(5)},
+		{`"a" + "b"`, nil, "ab"},
+		{`.a + "!"`, d(`{"a":"hi"}`), "hi!"},
+		{`[1,2] + [3]`, nil, d(`[1,2,3]`)},
+		{`.a + .b`, d(`{"a":[],"b":[1]}`), d(`[1]`)},
+		{`{a: 1, b: 2} + {b: 3, c: 4}`, nil, d(`{"a":1,"b":3,"c":4}`)},
+		{`. + {b: 3}`, newTestMap("a", 1, "b", 2), map[string]interface{}{"a": 1, "b": float64(3)}},
+		{`null + 1`, nil, float64(1)},
+		{`.a + null`, d(`{"a":"x"}`), "x"},
+	})
+
+	if _, err := New(`.a / .b`, nil).Apply(context.Background(), d(`{"a":1,"b":0}`)); err == nil {
+		t.Errorf("expected dividing by zero to error")
+	} else if err.Error() != "cannot divide 1 by zero" {
+		t.Errorf("error mismatch. want: %q, got: %q", "cannot divide 1 by zero", err.Error())
+	}
+}
+
+func TestBinaryOpErrors(t *testing.T) {
+	cases := []struct {
+		filter string
+		source interface{}
+		err    string
+	}{
+		{`.a * .b`, d(`{"a":"x","b":[1]}`), "cannot multiply string and array"},
+		{`.a + .b`, d(`{"a":{},"b":true}`), "cannot add object and boolean"},
+		{`.a * 5`, d(`{"a":null}`), "cannot multiply null and number"},
+		{`.a + .b`, d(`{"a":[1],"b":1}`), "cannot add array and number"},
+		{`.a - .b`, d(`{"a":"x","b":"y"}`), "cannot subtract string and string"},
+		{`.a / .b`, d(`{"a":[1],"b":2}`), "cannot divide array and number"},
+	}
+
+	for _, c := range cases {
+		_, err := New(c.filter, nil).Apply(context.Background(), c.source)
+		if err == nil {
+			t.Errorf("%s expected error, got nil", c.filter)
+			continue
+		}
+		if _, ok := err.(BinaryOpError); !ok {
+			t.Errorf("%s expected error to be a BinaryOpError. got: %T", c.filter, err)
+		}
+		if c.err != err.Error() {
+			t.Errorf("%s error mismatch. want: %q, got: %q", c.filter, c.err, err.Error())
+		}
+	}
+}
+
+func TestConstructionPipe(t *testing.T) {
+	cases := []goodCase{
+		{`{a: .x} | .a`, d(`{"x": "y"}`), "y"},
+		{`{a: .x} | .a | length`, d(`{"x": "yz"}`), 2},
+		{`[.a, .b] | length`, d(`{"a": 1, "b": 2}`), 2},
+		{`[.a, .b] | .[1]`, d(`{"a": 1, "b": 2}`), float64(2)},
+	}
+
+	runGoodCases(t, cases)
+}
+
+func TestMapValues(t *testing.T) {
+	cases := []goodCase{
+		{`map_values(. + 1)`, d(`{"a":1,"b":2}`), d(`{"a":2,"b":3}`)},
+		{`map_values(.[])`, d(`{"a":[],"b":[1]}`), d(`{"b":1}`)},
+		{`map_values(length)`, map[interface{}]interface{}{"a": "abc", 1: "d"}, map[string]interface{}{"a": 3, "1": 1}},
+		{`.[] | map_values(.x)`, d(`[{"a":{"x":1}},{"b":{"x":2}}]`), d(`[{"a":1},{"b":2}]`)},
+		{`map_values(. * 2)`, newTestMap("a", 1, "b", 2), map[string]interface{}{"a": float64(2), "b": float64(4)}},
+	}
+
+	runGoodCases(t, cases)
+}
+
+// testMap is an ordered value.Map implementation for tests
+type testMap struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func newTestMap(keysAndVals ...interface{}) *testMap {
+	m := &testMap{vals: map[string]interface{}{}}
+	for i := 0; i < len(keysAndVals); i += 2 {
+		key := keysAndVals[i].(string)
+		m.keys = append(m.keys, key)
+		m.vals[key] = keysAndVals[i+1]
+	}
+	return m
+}
+
+func (m *testMap) ValueForKey(key interface{}) (value.Value, error) {
+	v, ok := m.vals[key.(string)]
+	if !ok {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+	return v, nil
+}
+
+func (m *testMap) Iterate() value.Iterator {
+	return &testMapIterator{m: m, i: -1}
+}
+
+type testMapIterator struct {
+	m *testMap
+	i int
+}
+
+func (it *testMapIterator) Next() bool {
+	if it.i >= len(it.m.keys)-1 {
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *testMapIterator) Scan(dest value.Value) error {
+	*(dest.(*interface{})) = it.m.vals[it.m.keys[it.i]]
+	return nil
+}
+
+func (it *testMapIterator) Key() interface{} { return it.m.keys[it.i] }
+func (it *testMapIterator) Close() error     { return nil }
+func (it *testMapIterator) IsOrdered() bool  { return true }
+
+// fTrimSpace trims whitespace from strings, passing all other values through
+type fTrimSpace struct{}
+
+func (fTrimSpace) apply(ctx context.Context, e *env, in interface{}) (interface{}, error) {
+	if s, ok := in.(string); ok {
+		return strings.TrimSpace(s), nil
+	}
+	return in, nil
+}
+
+// memResolver resolves links from an in-memory map of paths to values
+type memResolver map[string]interface{}
+
+func (m memResolver) Resolve(ctx context.Context, l value.Link) (value.Value, error) {
+	v, ok := m[l.Path()]
+	if !ok {
+		return nil, fmt.Errorf("not found: %s", l.Path())
+	}
+	return v, nil
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	source := d(`{
+		"a": " apple ",
+		"b": [" banana", 1, null, { "c": "cherry  ", "d": [[" date"]] }],
+		"e": { "f": true, "g": "\tgrape\n" }
+	}`)
+	expect := d(`{
+		"a": "apple",
+		"b": ["banana", 1, null, { "c": "cherry", "d": [["date"]] }],
+		"e": { "f": true, "g": "grape" }
+	}`)
+	before := d(`{
+		"a": " apple ",
+		"b": [" banana", 1, null, { "c": "cherry  ", "d": [[" date"]] }],
+		"e": { "f": true, "g": "\tgrape\n" }
+	}`)
+
+	got, err := fWalk{f: fTrimSpace{}}.apply(ctx, &env{}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(before, source); diff != "" {
+		t.Errorf("walk modified input (-want +got):\n%s", diff)
+	}
+
+	// links are resolved & walked
+	r := memResolver{"/nested": []interface{}{" x ", newTestMap("y", " z ")}}
+	source = map[string]interface{}{"link": value.NewLink("/nested")}
+	got, err = fWalk{f: fTrimSpace{}}.apply(ctx, &env{resolver: r}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = d(`{ "link": ["x", { "y": "z" }] }`)
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("link result mismatch (-want +got):\n%s", diff)
+	}
+
+	// resolved links cache their values, use a fresh link
+	source = map[string]interface{}{"link": value.NewLink("/nested")}
+	if _, err = (fWalk{f: fTrimSpace{}}).apply(ctx, &env{}, source); err == nil {
+		t.Errorf("expected walking an unresolvable link to error")
+	}
+
+	runGoodCases(t, []goodCase{
+		{`walk(length)`, d(`[[1,2],"abc"]`), 2},
+	})
+}
+
+func TestAggregates(t *testing.T) {
+	cases := []goodCase{
+		{`sum`, d(`[1,2,3.5]`), float64(6.5)},
+		{`sum`, d(`[]`), float64(0)},
+		{`sum`, d(`[1,null,2]`), float64(3)},
+		{`sum`, value.NewIterator([]value.Value{1, 2, float64(3)}), float64(6)},
+		{`avg`, d(`[1,2,3,4]`), float64(2.5)},
+		{`mean`, d(`[1,null,2]`), float64(1.5)},
+		{`avg`, d(`[]`), nil},
+		{`avg`, d(`[null]`), nil},
+		{`count`, d(`[1,null,"a",false]`), 3},
+		{`count`, d(`[]`), 0},
+		{`.[] | sum`, d(`[[1,2],[3]]`), d(`[3,3]`)},
+	}
+
+	runGoodCases(t, cases)
+
+	for _, f := range []string{"sum", "avg"} {
+		if _, err := New(f, nil).Apply(context.Background(), d(`[1,"a"]`)); err == nil {
+			t.Errorf("expected %s of a non-number to error", f)
+		}
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	cases := []goodCase{
+		{`median`, d(`[3,1,2]`), float64(2)},
+		{`median`, d(`[4,1,3,2]`), float64(2.5)},
+		{`median`, d(`[5]`), float64(5)},
+		{`median`, d(`[1,null,3]`), float64(2)},
+		{`median`, d(`[]`), nil},
+		{`stddev`, d(`[2,4,4,4,5,5,7,9]`), float64(2)},
+		{`stddev`, d(`[1,1,1]`), float64(0)},
+		{`stddev`, d(`[]`), nil},
+		{`stddev`, value.NewIterator([]value.Value{1, 3}), float64(1)},
+	}
+
+	runGoodCases(t, cases)
+
+	for _, f := range []string{"median", "stddev"} {
+		if _, err := New(f, nil).Apply(context.Background(), d(`[1,"a"]`)); err == nil {
+			t.Errorf("expected %s of a non-number to error", f)
+		}
+	}
+}
+
+// recordingTracer records each trace event as a string
+type recordingTracer []string
+
+func (rt *recordingTracer) Enter(node string, in interface{}) {
+	*rt = append(*rt, fmt.Sprintf("enter %s", node))
+}
+
+func (rt *recordingTracer) Exit(node string, in, out interface{}, err error) {
+	*rt = append(*rt, fmt.Sprintf("exit %s %v %v", node, out, err))
+}
+
+func TestTracer(t *testing.T) {
+	rt := &recordingTracer{}
+	filt := New(".a | length * 2", nil, OptTracer(rt))
+	got, err := filt.Apply(context.Background(), d(`{"a":"xyz"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(float64(6), got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	expect := []string{
+		"enter selector",
+		"enter .",
+		"exit . map[a:xyz] <nil>",
+		"enter .a",
+		"exit .a xyz <nil>",
+		"exit selector xyz <nil>",
+		"enter *",
+		"enter length",
+		"exit length 3 <nil>",
+		"enter 2",
+		"exit 2 2 <nil>",
+		"exit * 6 <nil>",
+	}
+	if diff := cmp.Diff(expect, []string(*rt)); diff != "" {
+		t.Errorf("trace mismatch (-want +got):\n%s", diff)
+	}
+
+	rt = &recordingTracer{}
+	if _, err = New(".a * .b", nil, OptTracer(rt)).Apply(context.Background(), d(`{"a":"x","b":1}`)); err == nil {
+		t.Fatal("expected error")
+	}
+	if last := (*rt)[len(*rt)-1]; last != "exit * <nil> cannot multiply string and number" {
+		t.Errorf("expected final trace to report error. got: %s", last)
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := &Stats{}
+	rt := &recordingTracer{}
+	filt := New(".[] | length", nil, OptTracer(rt), OptStats(stats))
+	if _, err := filt.Apply(context.Background(), d(`["a","bb","ccc","dddd","eeeee"]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stats.Node("length").Count; got != 5 {
+		t.Errorf("expected length to run once per input value. want: 5, got: %d", got)
+	}
+	if got := stats.Node("selector").Count; got != 1 {
+		t.Errorf("expected selector to run once. got: %d", got)
+	}
+	if got := stats.Node("missing").Count; got != 0 {
+		t.Errorf("expected unknown node count to be zero. got: %d", got)
+	}
+	if len(*rt) == 0 {
+		t.Errorf("expected stats to combine with a tracer")
+	}
+
+	nodes := stats.Nodes()
+	for i := 1; i < len(nodes); i++ {
+		if nodes[i].Total > nodes[i-1].Total {
+			t.Errorf("expected nodes to be sorted by total time, most expensive first")
+		}
+	}
+	if len(nodes) != 4 {
+		t.Errorf("expected 4 distinct nodes. got: %v", nodes)
+	}
+}
+
+func TestDecodeJSONReaders(t *testing.T) {
+	ctx := context.Background()
+	reader := func(s string) value.ByteReader {
+		return ioutil.NopCloser(strings.NewReader(s))
+	}
+
+	got, err := New(".a.b", nil, func(o *Options) { o.DecodeJSONReaders = true }).
+		Apply(ctx, reader(`{"a":{"b":[1,2]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{1, 2}, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	// the same reader can be selected from more than once
+	got, err = New("{ a: .a, b: .b }", nil, func(o *Options) { o.DecodeJSONReaders = true }).
+		Apply(ctx, reader(`{"a":"x","b":"y"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"a": "x", "b": "y"}, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	// without the option readers aren't decoded
+	if _, err = New(".a", nil).Apply(ctx, reader(`{"a":1}`)); err == nil {
+		t.Errorf("expected selecting a key from an undecoded reader to error")
+	}
+
+	if _, err = New(".a", nil, func(o *Options) { o.DecodeJSONReaders = true }).Apply(ctx, reader(`{`)); err == nil {
+		t.Errorf("expected invalid JSON to error")
+	}
+
+	runGoodCases(t, []goodCase{
+		{`fromjson`, reader(`{"a":[true]}`), map[string]interface{}{"a": []interface{}{true}}},
+		{`fromjson | .a`, `{"a":1.5}`, 1.5},
+		{`.[] | fromjson`, d(`["1","[2]"]`), []interface{}{1, []interface{}{2}}},
+	})
+}
+
+func TestBytes(t *testing.T) {
+	// a lone byte is a number-like scalar
+	runGoodCases(t, []goodCase{
+		{`.a`, byte(7), nil},
+		{`.[0]`, byte(7), nil},
+		{`.[0:1]`, byte(7), nil},
+		{`length`, byte(7), nil},
+		{`. * 2`, byte(7), float64(14)},
+		{`. + 1`, byte(7), float64(8)},
+	})
+
+	// a []byte is a byte string
+	runGoodCases(t, []goodCase{
+		{`.a`, []byte("abc"), nil},
+		{`.[0]`, []byte("abc"), byte('a')},
+		{`.[5]`, []byte("abc"), nil},
+		{`.[1:]`, []byte("abc"), []byte("bc")},
+		{`length`, []byte("abc"), 3},
+		{`.[0] * 2`, []byte("abc"), float64(194)},
+	})
+
+	if _, err := New(`. * 2`, nil).Apply(context.Background(), []byte("abc")); err == nil {
+		t.Errorf("expected multiplying a byte string to error")
+	}
+}
+
+func TestFlattenObject(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`flatten_object`, d(`{"a":{"b":1}}`), d(`{"a.b":1}`)},
+		{`flatten_object`, d(`{"a":{"b":[1,{"c":2}]},"d":null}`), d(`{"a.b.0":1,"a.b.1.c":2,"d":null}`)},
+		{`flatten_object("_")`, d(`{"a":{"b":1,"c":{}}}`), d(`{"a_b":1,"a_c":{}}`)},
+		{`flatten_object`, newTestMap("a", newTestMap("b", "c")), d(`{"a.b":"c"}`)},
+		{`unflatten`, d(`{"a.b":1}`), d(`{"a":{"b":1}}`)},
+		{`unflatten("/")`, d(`{"a/0":1,"a/1":2,"b":"c"}`), d(`{"a":[1,2],"b":"c"}`)},
+		{`unflatten`, d(`{"a.0":1,"a.2":2}`), d(`{"a":{"0":1,"2":2}}`)},
+	})
+
+	roundTrips := []string{
+		`{"a":{"b":1}}`,
+		`{"a":{"b":[1,{"c":[true,null]}],"d":"e"},"f":[[1,2],[3]]}`,
+		`{"a":[],"b":{},"c":{"d":[{}]}}`,
+	}
+	for _, src := range roundTrips {
+		for _, sep := range []string{"", `("|")`} {
+			filt := fmt.Sprintf("flatten_object%s | unflatten%s", sep, sep)
+			got, err := New(filt, nil).Apply(context.Background(), d(src))
+			if err != nil {
+				t.Fatalf("%s error: %s", filt, err)
+			}
+			if diff := cmp.Diff(d(src), got); diff != "" {
+				t.Errorf("%s round trip mismatch (-want +got):\n%s", filt, diff)
+			}
+		}
+	}
+
+	for _, bad := range []string{`flatten_object`, `unflatten`, `flatten_object(1)`} {
+		if _, err := New(bad, nil).Apply(context.Background(), d(`[1]`)); err == nil {
+			t.Errorf("%s expected error on array input", bad)
+		}
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	ctx := context.Background()
+	caseInsensitive := func(o *Options) { o.CaseInsensitiveKeys = true }
+	cases := []struct {
+		filter string
+		source interface{}
+		expect interface{}
+	}{
+		{`.name`, d(`{"name":"exact"}`), "exact"},
+		{`.Name`, d(`{"name":"lower"}`), "lower"},
+		{`.NAME`, map[interface{}]interface{}{"nAmE": "mixed", 1: "one"}, "mixed"},
+		{`.Name`, d(`{"name":"lower","NAME":"upper","Name":"exact"}`), "exact"},
+		{`.name`, d(`{"nAME":"b","NAme":"a"}`), "a"},
+		{`.nAmE`, d(`{"name":"lower","NAME":"upper"}`), "upper"},
+		{`.Name`, newTestMap("name", "lower"), "lower"},
+		{`.Name`, newTestMap("name", "lower", "Name", "exact"), "exact"},
+		{`.a.B`, d(`{"A":{"b":1}}`), 1},
+		{`.missing`, d(`{"name":"lower"}`), nil},
+		{`.[].Id`, d(`[{"id":1},{"ID":2}]`), []interface{}{1, 2}},
+	}
+
+	for _, c := range cases {
+		got, err := New(c.filter, nil, caseInsensitive).Apply(ctx, c.source)
+		if err != nil {
+			t.Fatalf("%s error: %s", c.filter, err)
+		}
+		// compare numbers regardless of go type
+		if diff := cmp.Diff(normalizeNumbers(c.expect), normalizeNumbers(got)); diff != "" {
+			t.Errorf("%s result mismatch (-want +got):\n%s", c.filter, diff)
+		}
+	}
+
+	got, err := New(`.Name`, nil).Apply(ctx, d(`{"name":"lower"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("expected keys to be case sensitive by default. got: %v", got)
+	}
+}
+
+// normalizeNumbers converts ints to float64s for comparison with decoded JSON
+func normalizeNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case []interface{}:
+		res := make([]interface{}, len(x))
+		for i, el := range x {
+			res[i] = normalizeNumbers(el)
+		}
+		return res
+	}
+	return v
+}
+
+func TestGlobSelector(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.*`, d(`{"b":2,"a":1,"c":3}`), d(`[1,2,3]`)},
+		{`.a.*`, d(`{"a":{"y":"why","x":"ex"}}`), d(`["ex","why"]`)},
+		{`.a.* | length`, d(`{"a":{"y":"why","x":"ex"}}`), []interface{}{2, 3}},
+		{`.*`, d(`[1,2]`), d(`[1,2]`)},
+		{`.*.b`, d(`{"x":{"b":1},"y":{"b":2}}`), d(`[1,2]`)},
+		{`.*`, newTestMap("z", 1, "a", 2), []interface{}{1, 2}},
+		{`. * 2`, d(`3`), float64(6)},
+		{`.a * 2`, d(`{"a":3}`), float64(6)},
+	})
+
+	if _, err := New(".*", nil).Apply(context.Background(), "str"); err == nil {
+		t.Errorf("expected globbing a string to error")
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`find_all("id")`, d(`{"id":1,"a":{"id":2,"b":[{"id":3},{"x":{"id":4}}]},"c":{"no":5}}`), d(`[1,2,3,4]`)},
+		{`find_all("id")`, d(`{"id":{"id":1}}`), d(`[{"id":1},1]`)},
+		{`find_all("id")`, d(`[1,"two"]`), d(`[]`)},
+		{`find_all("a") | length`, d(`{"a":"xyz","b":{"a":[1,2]}}`), []interface{}{3, 2}},
+	})
+
+	// links are resolved during descent, & cycles are only visited once
+	r := memResolver{
+		"/a": map[string]interface{}{"id": "a", "next": value.NewLink("/b")},
+		"/b": map[string]interface{}{"id": "b", "next": value.NewLink("/a")},
+	}
+	got, err := New(`find_all("id")`, r).Apply(context.Background(), value.NewLink("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`["a","b"]`), got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	// a link reached from more than one key isn't a cycle, each occurrence
+	// is descended into
+	r = memResolver{
+		"/shared": map[string]interface{}{"id": "shared"},
+	}
+	in := map[string]interface{}{"a": value.NewLink("/shared"), "b": value.NewLink("/shared")}
+	got, err = New(`find_all("id")`, r).Apply(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`["shared","shared"]`), got); diff != "" {
+		t.Errorf("shared link result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMatchesSchema(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`matches_schema({type: "object", required: ["a"]})`, d(`{"a":1}`), true},
+		{`matches_schema({type: "object", required: ["a"]})`, d(`{"b":1}`), false},
+		{`.[] | matches_schema({type: "number"})`, d(`[1,"two"]`), []interface{}{true, false}},
+		{`matches_schema(.schema)`, d(`{"schema":{"type":"object"}}`), true},
+		{`validate({properties: {a: {enum: [1, 2]}}})`, d(`{"a":3}`), d(`[".a: value is not one of the allowed enum values"]`)},
+		{`validate({type: "string"})`, d(`"ok"`), d(`[]`)},
+	})
+}
+
+func TestCSVSource(t *testing.T) {
+	src := "name,age,city\nalice,30,nyc\nbob,25,sf\n"
+	it := value.NewCSVIterator(strings.NewReader(src), value.CSVOptions{InferTypes: true})
+	got, err := New(`.[] | {name, age}`, nil).Apply(context.Background(), it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{
+		map[string]interface{}{"name": "alice", "age": 30},
+		map[string]interface{}{"name": "bob", "age": 25},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestZip(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`zip`, d(`[[1,2],["a","b"]]`), d(`[[1,"a"],[2,"b"]]`)},
+		{`zip`, d(`[[1,2,3],["a"],[true,false]]`), d(`[[1,"a",true]]`)},
+		{`zip(true)`, d(`[[1,2,3],["a"]]`), d(`[[1,"a"],[2,null],[3,null]]`)},
+		{`zip(false)`, d(`[[1,2,3],["a"]]`), d(`[[1,"a"]]`)},
+		{`zip`, d(`[]`), d(`[]`)},
+		{`zip`, []interface{}{value.NewIterator([]value.Value{1.0, 2.0}), d(`["a","b"]`)}, d(`[[1,"a"],[2,"b"]]`)},
+	})
+
+	if _, err := New(`zip`, nil).Apply(context.Background(), d(`[1,2]`)); err == nil {
+		t.Errorf("expected zipping an array of numbers to error")
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`enumerate`, d(`["a","b"]`), []interface{}{[]interface{}{0, "a"}, []interface{}{1, "b"}}},
+		{`enumerate`, d(`{"b":1,"a":2}`), d(`[["a",2],["b",1]]`)},
+		{`enumerate`, d(`[]`), d(`[]`)},
+		{`enumerate | .[] | .[0]`, d(`["x","y"]`), []interface{}{0, 1}},
+	})
+
+	ctx := context.Background()
+	cases := []struct {
+		in     interface{}
+		expect []interface{}
+	}{
+		{value.NewIterator([]value.Value{"a", "b"}), []interface{}{[]interface{}{0, "a"}, []interface{}{1, "b"}}},
+		{newTestMap("z", 1, "a", 2), []interface{}{[]interface{}{"z", 1}, []interface{}{"a", 2}}},
+	}
+	for _, c := range cases {
+		got, err := New(`enumerate`, nil).Apply(ctx, c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(c.expect, drainIterator(t, got)); diff != "" {
+			t.Errorf("%T result mismatch (-want +got):\n%s", c.in, diff)
+		}
+	}
+}
+
+func TestCountBy(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`count_by(.status)`, d(`[{"status":"ok"},{"status":"fail"},{"status":"ok"},{"status":"ok"}]`), map[string]interface{}{"ok": 3, "fail": 1}},
+		{`count_by(.n)`, d(`[{"n":1},{"n":1.0},{"n":2.5},{},{"n":true},{"n":[1]}]`), map[string]interface{}{"1": 2, "2.5": 1, "null": 1, "true": 1, "[1]": 1}},
+		{`count_by(.)`, value.NewIterator([]value.Value{"a", "b", "a"}), map[string]interface{}{"a": 2, "b": 1}},
+		{`count_by(.a)`, d(`[]`), map[string]interface{}{}},
+		{`count_by(.)`, d(`[1e21,1e-7,1000]`), map[string]interface{}{"1e+21": 1, "1e-7": 1, "1000": 1}},
+	})
+}
+
+func TestIndexBy(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`index_by(.id)`, d(`[{"id":"a","n":1},{"id":"b","n":2}]`), d(`{"a":{"id":"a","n":1},"b":{"id":"b","n":2}}`)},
+		// the last element with a key wins
+		{`index_by(.id)`, d(`[{"id":1,"n":1},{"id":2,"n":2},{"id":1,"n":3}]`), d(`{"1":{"id":1,"n":3},"2":{"id":2,"n":2}}`)},
+		{`index_by(.id) | length`, value.NewIterator([]value.Value{d(`{"id":"x"}`), d(`{"id":"y"}`)}), 2},
+		{`index_by(.id)`, d(`[]`), d(`{}`)},
+	})
+}
+
+func TestPartition(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`partition(.active)`,
+			d(`[{"id":1,"active":true},{"id":2,"active":false},{"id":3},{"id":4,"active":"yes"},{"id":5,"active":null}]`),
+			d(`[[{"id":1,"active":true},{"id":4,"active":"yes"}],[{"id":2,"active":false},{"id":3},{"id":5,"active":null}]]`)},
+		{`partition(.)`, value.NewIterator([]value.Value{true, false, true}), d(`[[true,true],[false]]`)},
+		{`partition(.)`, d(`[]`), d(`[[],[]]`)},
+	})
+}
+
+// drainIterator reads all values of a filter result that must be an iterator
+func drainIterator(t *testing.T, got interface{}) (vals []interface{}) {
+	t.Helper()
+	it, ok := got.(value.Iterator)
+	if !ok {
+		t.Fatalf("expected an iterator, got %T", got)
+	}
+	for it.Next() {
+		var v interface{}
+		if err := it.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, v)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return vals
+}
+
+func TestComparison(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`. < 3`, d(`2`), true},
+		{`. <= 2`, d(`2`), true},
+		{`. > 2`, d(`2`), false},
+		{`. >= 2`, d(`2`), true},
+		{`. == 1`, 1, true},
+		{`. != 1`, 1.5, true},
+		{`.a == "x"`, d(`{"a":"x"}`), true},
+		{`.a < .b`, d(`{"a":"apple","b":"banana"}`), true},
+		{`.a == .b`, d(`{"a":[1,{"c":2}],"b":[1.0,{"c":2}]}`), true},
+		{`.a < .b`, d(`{"a":[1,2],"b":[1,2,0]}`), true},
+		{`.a < .b`, d(`{"a":{"a":2},"b":{"b":1}}`), true},
+		{`. == .`, newTestMap("a", 1), true},
+		{`.a + 1 > 2`, d(`{"a":2}`), true},
+		{`.[] | . < 2`, d(`[1,2]`), d(`[true,false]`)},
+		// values of different types order null < false < true < numbers <
+		// strings < arrays < objects
+		{`[null < false, false < true, true < 0, 0 < "", "" < [], [] < {}]`, nil, d(`[true,true,true,true,true,true]`)},
+	})
+
+	for _, str := range []string{`. = 1`, `. ! 1`} {
+		if _, err := New(str, nil).Apply(context.Background(), 1); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+}
+
+func TestTakeWhile(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`take_while(. < 3)`, d(`[1,2,3,4,1]`), d(`[1,2]`)},
+		{`take_while(. < 0)`, d(`[1,2]`), d(`[]`)},
+		{`take_while(. < 9)`, d(`[1,2]`), d(`[1,2]`)},
+	})
+
+	src := &countingIterator{Iterator: value.NewIterator([]value.Value{1, 2, 3, 4, 5})}
+	got, err := New(`take_while(. < 3)`, nil).Apply(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{1, 2}, drainIterator(t, got)); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	if src.nexts != 3 {
+		t.Errorf("expected take_while to stop advancing after 3 values, advanced %d times", src.nexts)
+	}
+	if !src.closed {
+		t.Errorf("expected closing take_while to close the source iterator")
+	}
+}
+
+func TestDropWhile(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`drop_while(. < 3)`, d(`[1,2,3,4,1]`), d(`[3,4,1]`)},
+		{`drop_while(. < 0)`, d(`[1,2]`), d(`[1,2]`)},
+		{`drop_while(. < 9)`, d(`[1,2]`), d(`[]`)},
+	})
+
+	src := value.NewIterator([]value.Value{1, 2, 3, 4, 1})
+	got, err := New(`drop_while(. < 3)`, nil).Apply(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{3, 4, 1}, drainIterator(t, got)); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// countingIterator records how many times an iterator is advanced & if it's
+// been closed
+type countingIterator struct {
+	value.Iterator
+	nexts  int
+	closed bool
+}
+
+func (it *countingIterator) Next() bool {
+	it.nexts++
+	return it.Iterator.Next()
+}
+
+func (it *countingIterator) Close() error {
+	it.closed = true
+	return it.Iterator.Close()
+}
+
+func TestForeach(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`foreach .[] as $x (0; . + $x)`, d(`[1,2,3,4]`), d(`[1,3,6,10]`)},
+		{`foreach .[] as $x (0; . + $x; .)`, d(`[1,2,3,4]`), d(`[1,3,6,10]`)},
+		{`foreach .[] as $x (0; . + $x; [$x, .])`, d(`[1,2]`), d(`[[1,1],[2,3]]`)},
+		{`foreach .[] as $item (.[0]; . * $item; . * 10)`, d(`[2,3]`), d(`[40,120]`)},
+		{`foreach .items[] as $x (.start; . + $x.n)`, d(`{"start":10,"items":[{"n":1},{"n":2}]}`), d(`[11,13]`)},
+		{`foreach .[] as $x (0; . + 1)`, d(`[]`), d(`[]`)},
+		{`foreach .[] as $pair (0; . + $pair[1])`, d(`[["a",1],["b",2]]`), d(`[1,3]`)},
+		{`[foreach .[] as $x (0; . + $x; .)]`, d(`[1,2,3]`), d(`[1,3,6]`)},
+		{`foreach range(4) as $i (0; . + $i; select(. > 1))`, nil, d(`[3,6]`)},
+		{`foreach .[] as $x (0; . + $x) | . * 2`, d(`[1,2,3]`), d(`[2,6,12]`)},
+		{`(.a.b)[0]`, d(`{"a":{"b":[3]}}`), d(`3`)},
+		{`(.a).b`, d(`{"a":{"b":[3]}}`), d(`[3]`)},
+	})
+
+	errCases := []string{
+		`foreach .[] ($x) (0; .)`,
+		`foreach .[] as x (0; .)`,
+		`foreach .[] as $x (0)`,
+		`foreach .[] as $x (0; .; .; .)`,
+		`$undefined`,
+	}
+	for _, str := range errCases {
+		if _, err := New(str, nil).Apply(context.Background(), d(`[1]`)); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.active and .verified`, d(`{"active":true,"verified":true}`), true},
+		{`.active and .verified`, d(`{"active":true,"verified":null}`), false},
+		{`.active or .verified`, d(`{"active":false,"verified":"yes"}`), true},
+		{`.active or .verified`, d(`{}`), false},
+		{`.x > 1 or .y > 1`, d(`{"x":0,"y":2}`), true},
+		{`.x > 1 and .y > 1`, d(`{"x":2,"y":2}`), true},
+		// and binds more tightly than or
+		{`true or false and false`, nil, true},
+		{`(true or false) and false`, nil, false},
+		// only null & false are falsy
+		{`0 and "" and [] and {}`, nil, true},
+		{`not`, d(`null`), true},
+		{`not`, d(`0`), false},
+		{`.a | not`, d(`{"a":false}`), true},
+		{`.[] | . > 1 and . < 4`, d(`[1,2,3,4]`), d(`[false,true,true,false]`)},
+	})
+
+	// the right side isn't applied when the left determines the result
+	for _, str := range []string{`false and .a * 2`, `true or .a * 2`} {
+		got, err := New(str, nil).Apply(context.Background(), "not an object")
+		if err != nil {
+			t.Errorf("%s: expected right side to be skipped, got error: %s", str, err)
+		} else if got != strings.HasPrefix(str, "true") {
+			t.Errorf("%s: unexpected result %v", str, got)
+		}
+	}
+
+	if _, err := New(`and`, nil).Apply(context.Background(), nil); err == nil {
+		t.Errorf("expected a bare and to error")
+	}
+}
+
+func TestAutoResolveLinks(t *testing.T) {
+	ctx := context.Background()
+	r := memResolver{
+		"/author": map[string]interface{}{"name": "ada", "friend": value.NewLink("/friend")},
+		"/friend": map[string]interface{}{"name": "bob", "friend": value.NewLink("/author")},
+		"/tags":   []interface{}{"a", value.NewLink("/tag")},
+		"/tag":    "b",
+	}
+	source := map[string]interface{}{
+		"title":  "post",
+		"author": value.NewLink("/author"),
+		"tags":   value.NewLink("/tags"),
+	}
+
+	got, err := New(`{title, author, tags}`, r).Apply(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.(map[string]interface{})["author"].(value.Link); !ok {
+		t.Errorf("expected links to be left unresolved by default")
+	}
+
+	autoResolve := func(o *Options) { o.AutoResolveLinks = true }
+	got, err = New(`{title, author, tags}`, r, autoResolve).Apply(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := got.(map[string]interface{})["author"].(map[string]interface{})
+	friend := author["friend"].(map[string]interface{})
+	if friend["name"] != "bob" {
+		t.Errorf("expected nested link to resolve, got: %v", friend)
+	}
+	// the link back to the author is a cycle, & is left as a link
+	if l, ok := friend["friend"].(value.Link); !ok || l.Path() != "/author" {
+		t.Errorf("expected cyclic link to be left unresolved, got: %v", friend["friend"])
+	}
+	if diff := cmp.Diff([]interface{}{"a", "b"}, got.(map[string]interface{})["tags"]); diff != "" {
+		t.Errorf("tags mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err = New(`.`, nil, autoResolve).Apply(ctx, value.NewLink("/author")); err == nil {
+		t.Errorf("expected auto-resolving without a resolver to error")
+	}
+}
+
+func TestConditional(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`if . then "yes" else "no" end`, true, "yes"},
+		{`if . then "yes" else "no" end`, nil, "no"},
+		{`if .n > 10 then "big" elif .n > 5 then "medium" else "small" end`, d(`{"n":7}`), "medium"},
+		{`if .n > 10 then "big" elif .n > 5 then "medium" else "small" end`, d(`{"n":1}`), "small"},
+		{`if .n > 10 then "big" elif .n > 5 then "medium" end`, d(`{"n":1}`), d(`{"n":1}`)},
+		// no else passes the input through unchanged
+		{`if .a then .a end`, d(`{"b":1}`), d(`{"b":1}`)},
+		{`.[] | if . > 1 then . * 10 end`, d(`[1,2]`), d(`[1,20]`)},
+		// nested conditionals
+		{`if .a then if .b then "ab" else "a" end else "none" end`, d(`{"a":true,"b":false}`), "a"},
+		{`if .a | length > 1 then .a | length else 0 end`, d(`{"a":[1,2,3]}`), 3},
+		{`{kind: (if .x then "x" else "y" end)}`, d(`{"x":1}`), d(`{"kind":"x"}`)},
+	})
+
+	errCases := []string{
+		`if . then 1`,
+		`if . 1 end`,
+		`if . then 1 else 2`,
+		`then`,
+		`if . then 1 elif end`,
+	}
+	for _, str := range errCases {
+		if _, err := New(str, nil).Apply(context.Background(), nil); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+}
+
+func TestCacheResolvedLinks(t *testing.T) {
+	ctx := context.Background()
+	r := memResolver{
+		"/author": map[string]interface{}{"name": "ada", "friend": value.NewLink("/friend")},
+		"/friend": map[string]interface{}{"name": "bob"},
+	}
+
+	// selecting through a link caches the resolved value on the link
+	author := value.NewLink("/author")
+	if _, err := New(`.name`, r).Apply(ctx, author); err != nil {
+		t.Fatal(err)
+	}
+	if v, resolved := author.Value(); !resolved || v.(map[string]interface{})["name"] != "ada" {
+		t.Errorf("expected selecting through a link to cache its value, got: %v %t", v, resolved)
+	}
+
+	cache := func(o *Options) { o.CacheResolvedLinks = true }
+	got, err := New(`.author`, r, cache).Apply(ctx, map[string]interface{}{"author": value.NewLink("/author")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, ok := got.(value.Link)
+	if !ok {
+		t.Fatalf("expected output to be a link, got %T", got)
+	}
+	v, resolved := link.Value()
+	if !resolved {
+		t.Fatalf("expected output link to be resolved")
+	}
+	friend, ok := v.(map[string]interface{})["friend"].(value.Link)
+	if !ok {
+		t.Fatalf("expected nested link to be kept as a link")
+	}
+	if fv, resolved := friend.Value(); !resolved || fv.(map[string]interface{})["name"] != "bob" {
+		t.Errorf("expected nested link to be resolved, got: %v %t", fv, resolved)
+	}
+}
+
+func TestTry(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`(.a * 2)?`, d(`{"a":"x"}`), []interface{}{}},
+		{`(.a * 2)?`, d(`{"a":2}`), float64(4)},
+		{`[.[] | (.a * 2)?]`, d(`[{"a":1},"x",{"a":3}]`), d(`[2,6]`)},
+		{`.[] | (. * 2)?`, d(`[1,"x",3]`), d(`[2,6]`)},
+		{`[.[] | (. * 2)??]`, d(`["x"]`), d(`[]`)},
+		// ? composes with selectors
+		{`.a.b?`, d(`{"a":{"b":1}}`), float64(1)},
+		{`.[]?`, d(`[1,2]`), d(`[1,2]`)},
+		// .[]? skips inputs that can't be iterated
+		{`[.[]?]`, d(`"str"`), d(`[]`)},
+		{`[.[]?]`, d(`3`), d(`[]`)},
+		{`[.[] | .[]?]`, d(`[[1,2],"x",3,[4]]`), d(`[1,2,4]`)},
+		{`.a? | length`, d(`{"a":"abc"}`), 3},
+	})
+
+	for _, str := range []string{`.a?`, `.a.b?`} {
+		if _, err := New(str, nil).Apply(context.Background(), "str"); err != nil {
+			t.Errorf("%s: unexpected error: %s", str, err)
+		}
+	}
+
+	if _, err := New(`?`, nil).Apply(context.Background(), nil); err == nil {
+		t.Errorf("expected a bare ? to error")
+	}
+}
+
+func TestOptionalKeySelector(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.a?`, d(`{"a":1}`), float64(1)},
+		{`.a?`, d(`{"b":1}`), nil},
+		{`.a?`, "str", []interface{}{}},
+		{`.a?`, d(`5`), []interface{}{}},
+		{`.a.b?`, d(`{"a":{"b":"c"}}`), "c"},
+		{`.a.b?`, d(`{"a":[1]}`), d(`[]`)},
+		// only object elements of a mixed array produce output
+		{`.[].foo?`, d(`[{"foo":1},"x",2,{"foo":3},null,[4]]`), d(`[1,3]`)},
+		{`[.[] | .foo?]`, d(`[{"foo":1},true,{"bar":2}]`), d(`[1,null]`)},
+		{`.foo?`, d(`[{"foo":1},"x",{"foo":2}]`), d(`[]`)},
+		{`.[].foo? | . * 10`, d(`[{"foo":1},"x"]`), d(`[10]`)},
+		{`.a?`, newTestMap("a", 1), 1},
+	})
+}
+
+func TestRecurseDescent(t *testing.T) {
+	src := `{"name":"root","kids":[{"name":"a"},{"name":"b","kids":[]}]}`
+	runGoodCases(t, []goodCase{
+		// the input is emitted first, then descendants depth-first in sorted
+		// key order
+		{`..`, d(src), d(`[
+			{"name":"root","kids":[{"name":"a"},{"name":"b","kids":[]}]},
+			[{"name":"a"},{"name":"b","kids":[]}],
+			{"name":"a"},
+			"a",
+			{"name":"b","kids":[]},
+			[],
+			"b",
+			"root"
+		]`)},
+		{`[..] | length`, d(src), 8},
+		{`.. | .name?`, d(src), d(`["root","a","b"]`)},
+		{`..`, d(`5`), d(`[5]`)},
+		{`.kids | ..`, d(`{"kids":[1]}`), d(`[[1],1]`)},
+		{`[.. | .a?] | length`, newTestMap("a", newTestMap("a", 1)), 2},
+	})
+
+	// links are resolved & iterators are read before descending
+	r := memResolver{"/child": map[string]interface{}{"name": "linked"}}
+	newInput := func() interface{} {
+		return map[string]interface{}{"child": value.NewLink("/child"), "list": value.NewIterator([]value.Value{1, 2})}
+	}
+	got, err := New(`[.. | .name?]`, r).Apply(context.Background(), newInput())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`[null,"linked"]`), got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	got, err = New(`[..] | length`, r).Apply(context.Background(), newInput())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 6 {
+		t.Errorf("expected 6 values, got %v", got)
+	}
+
+	// a link reachable from two paths isn't a cycle, & is descended into
+	// from each of them
+	r = memResolver{"/shared": map[string]interface{}{"name": "shared"}}
+	shared := map[string]interface{}{
+		"a": value.NewLink("/shared"),
+		"b": []interface{}{value.NewLink("/shared")},
+	}
+	got, err = New(`[.. | .name?]`, r).Apply(context.Background(), shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`[null,"shared","shared"]`), got); diff != "" {
+		t.Errorf("shared link result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`keys`, d(`{"b":1,"c":2,"a":3}`), d(`["a","b","c"]`)},
+		{`keys`, d(`{}`), d(`[]`)},
+		{`keys`, d(`["x","y","z"]`), []interface{}{0, 1, 2}},
+		{`keys`, d(`[]`), []interface{}{}},
+		{`keys`, map[interface{}]interface{}{"b": 1, 1: 2}, d(`["1","b"]`)},
+		{`keys`, newTestMap("z", 1, "a", 2, "m", 3), d(`["a","m","z"]`)},
+		{`keys_unsorted`, newTestMap("z", 1, "a", 2, "m", 3), d(`["z","a","m"]`)},
+		{`keys_unsorted`, d(`{"b":1,"a":2}`), d(`["a","b"]`)},
+		{`keys`, value.NewIterator([]value.Value{"a", "b"}), []interface{}{0, 1}},
+		{`.[] | keys`, d(`[{"a":1},{"b":2}]`), d(`[["a"],["b"]]`)},
+		{`keys | length`, d(`{"a":1,"b":2}`), 2},
+	})
+
+	for _, in := range []interface{}{"str", 1, true, nil} {
+		if _, err := New(`keys`, nil).Apply(context.Background(), in); err == nil {
+			t.Errorf("expected keys of %v to error", in)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.[] | values`, d(`[1,null,2]`), d(`[1,2]`)},
+		{`[.[] | values]`, d(`[null,false,0,""]`), d(`[false,0,""]`)},
+		{`values`, d(`{"a":1}`), d(`{"a":1}`)},
+		{`values`, nil, []interface{}{}},
+		{`[.. | values] | length`, d(`{"a":null,"b":[null,1]}`), 3},
+	})
+}
+
+func TestHas(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`has("id")`, d(`{"id":1}`), true},
+		{`has("id")`, d(`{"id":null}`), true},
+		{`has("id")`, d(`{"name":"x"}`), false},
+		{`has(.key)`, d(`{"key":"key"}`), true},
+		{`has(0)`, d(`["a","b"]`), true},
+		{`has(1)`, d(`["a","b"]`), true},
+		{`has(2)`, d(`["a","b"]`), false},
+		{`has(-1)`, d(`["a","b"]`), false},
+		{`has("a")`, map[interface{}]interface{}{"a": 1}, true},
+		{`has("a")`, newTestMap("a", 1), true},
+		{`has("b")`, newTestMap("a", 1), false},
+		{`.[] | has("a")`, d(`[{"a":1},{"b":2}]`), d(`[true,false]`)},
+		{`"a" | in({a: 1})`, nil, true},
+		{`.[] | in({a: 1})`, d(`["a","b"]`), d(`[true,false]`)},
+		{`1 | in([0])`, nil, false},
+	})
+
+	errCases := []struct {
+		filter string
+		source interface{}
+	}{
+		{`has(0)`, d(`{"a":1}`)},
+		{`has("a")`, d(`[1]`)},
+		{`has("a")`, "str"},
+		{`has`, d(`{}`)},
+	}
+	for _, c := range errCases {
+		if _, err := New(c.filter, nil).Apply(context.Background(), c.source); err == nil {
+			t.Errorf("%s: expected error", c.filter)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	people := d(`[{"name":"a","age":25},{"name":"b","age":40},{"name":"c","age":35}]`)
+	runGoodCases(t, []goodCase{
+		{`.[] | select(.age > 30)`, people, d(`[{"name":"b","age":40},{"name":"c","age":35}]`)},
+		{`[.[] | select(.age > 30) | .name]`, people, d(`["b","c"]`)},
+		{`[.[] | select(.age > 100)]`, people, d(`[]`)},
+		{`.[] | select(.age < 30 or .name == "c") | .name`, people, d(`["a","c"]`)},
+		{`[.[] | select(.)]`, d(`[1,null,false,0]`), d(`[1,0]`)},
+		{`select(has("a"))`, d(`{"a":1}`), d(`{"a":1}`)},
+		{`select(has("a"))`, d(`{"b":1}`), []interface{}{}},
+		{`[.[] | select(. > 1)] | length`, d(`[1,2,3]`), 2},
+	})
+}
+
+func TestToEntries(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`to_entries`, d(`{"b":2,"a":1}`), []interface{}{
+			map[string]interface{}{"key": "a", "value": float64(1)},
+			map[string]interface{}{"key": "b", "value": float64(2)},
+		}},
+		{`to_entries`, d(`{}`), []interface{}{}},
+		{`to_entries`, newTestMap("z", 1, "a", "x"), []interface{}{
+			map[string]interface{}{"key": "a", "value": "x"},
+			map[string]interface{}{"key": "z", "value": 1},
+		}},
+		{`to_entries`, map[interface{}]interface{}{1: true}, []interface{}{
+			map[string]interface{}{"key": "1", "value": true},
+		}},
+		{`to_entries | .[] | .key`, d(`{"a":1,"b":2}`), d(`["a","b"]`)},
+	})
+
+	if _, err := New(`to_entries`, nil).Apply(context.Background(), d(`[1]`)); err == nil {
+		t.Errorf("expected to_entries of an array to error")
+	}
+}
+
+func TestFromEntries(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`from_entries`, d(`[{"key":"a","value":1},{"key":"b","value":2}]`), d(`{"a":1,"b":2}`)},
+		{`from_entries`, d(`[{"k":"a","v":1},{"name":"b","value":2},{"Key":"c","Value":3}]`), d(`{"a":1,"b":2,"c":3}`)},
+		{`from_entries`, d(`[{"key":1,"value":"one"},{"key":true}]`), d(`{"1":"one","true":null}`)},
+		// keys that are present but false or null are stringified, not skipped
+		{`from_entries`, d(`[{"key":false,"value":1},{"key":null,"value":2}]`), d(`{"false":1,"null":2}`)},
+		{`from_entries`, d(`[{"key":"a","value":false,"v":1}]`), d(`{"a":false}`)},
+		{`from_entries`, d(`[{"key":"a","value":null,"v":1}]`), d(`{"a":null}`)},
+		{`from_entries`, d(`[]`), d(`{}`)},
+		{`from_entries`, []interface{}{newTestMap("key", "a", "value", 1)}, map[string]interface{}{"a": 1}},
+		// with_entries idiom
+		{`to_entries | [.[] | {key: .value, value: .key}] | from_entries`, d(`{"a":"x","b":"y"}`), d(`{"x":"a","y":"b"}`)},
+	})
+
+	// round trip
+	in := d(`{"a":1,"b":[1,2],"c":{"d":null}}`)
+	got, err := New(`to_entries | from_entries`, nil).Apply(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(in, got); diff != "" {
+		t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, src := range []string{`[{"value":1}]`, `[1]`, `{"a":1}`} {
+		if _, err := New(`from_entries`, nil).Apply(context.Background(), d(src)); err == nil {
+			t.Errorf("expected from_entries of %s to error", src)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`add`, d(`[1,2,3]`), float64(6)},
+		{`add`, d(`["a","b"]`), "ab"},
+		{`add`, d(`[]`), nil},
+		{`add`, d(`[[1],[2,3]]`), d(`[1,2,3]`)},
+		{`add`, d(`[{"a":1},{"b":2},{"a":3}]`), d(`{"a":3,"b":2}`)},
+		{`add`, d(`[null,1,null]`), float64(1)},
+		{`[.[] | .amount] | add`, d(`[{"amount":2},{"amount":5}]`), float64(7)},
+		{`add`, value.NewIterator([]value.Value{1, 2}), float64(3)},
+		{`.[] | add`, d(`[[1,2],["a"]]`), d(`[3,"a"]`)},
+	})
+
+	if _, err := New(`add`, nil).Apply(context.Background(), d(`[1,"a"]`)); err == nil {
+		t.Errorf("expected adding a number & string to error")
+	}
+}
+
+func TestSort(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`sort`, d(`[3,1,2]`), d(`[1,2,3]`)},
+		{`sort`, d(`[{"a":1},[2],"b",3,true,false,null,"a",[1]]`), d(`[null,false,true,3,"a","b",[1],[2],{"a":1}]`)},
+		{`sort`, d(`[]`), d(`[]`)},
+		{`sort`, d(`[{"b":1},{"a":2},{"a":1}]`), d(`[{"a":1},{"a":2},{"b":1}]`)},
+		{`sort`, value.NewIterator([]value.Value{"b", "c", "a"}), d(`["a","b","c"]`)},
+		{`sort | .[0]`, d(`[2,1]`), float64(1)},
+	})
+
+	// the input isn't modified
+	in := d(`[3,1,2]`)
+	if _, err := New(`sort`, nil).Apply(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`[3,1,2]`), in); diff != "" {
+		t.Errorf("expected input to be unchanged (-want +got):\n%s", diff)
+	}
+
+	if _, err := New(`sort`, nil).Apply(context.Background(), d(`{"a":1}`)); err == nil {
+		t.Errorf("expected sorting an object to error")
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	people := d(`[
+		{"name":"c","age":30},
+		{"name":"a","age":25},
+		{"name":"d","age":30},
+		{"name":"b","age":25}
+	]`)
+	runGoodCases(t, []goodCase{
+		// ties keep their original order
+		{`sort_by(.age) | [.[] | .name]`, people, d(`["a","b","c","d"]`)},
+		{`sort_by(.age) | [.[] | .name]`, d(`[{"name":"x","age":2},{"name":"y","age":1},{"name":"z","age":2}]`), d(`["y","x","z"]`)},
+		{`sort_by(.name) | [.[] | .age]`, people, d(`[25,25,30,30]`)},
+		{`sort_by(.missing) | [.[] | .name]`, people, d(`["c","a","d","b"]`)},
+		{`sort_by(length)`, d(`["ccc","a","bb"]`), d(`["a","bb","ccc"]`)},
+		{`sort_by(0 - .)`, d(`[1,3,2]`), d(`[3,2,1]`)},
+		// multiple outputs compare as an array
+		{`sort_by(.a, .b)`, d(`[{"a":1,"b":2},{"a":1,"b":1},{"a":0,"b":3}]`), d(`[{"a":0,"b":3},{"a":1,"b":1},{"a":1,"b":2}]`)},
+	})
+}
+
+func TestUnique(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`unique`, d(`[3,1,2,1]`), d(`[1,2,3]`)},
+		{`unique`, []interface{}{1, 1.0, byte(1), "1"}, []interface{}{1, "1"}},
+		{`unique`, d(`[{"a":[1]},{"a":[1]},{"a":[2]}]`), d(`[{"a":[1]},{"a":[2]}]`)},
+		{`unique`, d(`[]`), d(`[]`)},
+		{`unique_by(.id)`, d(`[{"id":2,"n":"a"},{"id":1,"n":"b"},{"id":2,"n":"c"}]`), d(`[{"id":1,"n":"b"},{"id":2,"n":"a"}]`)},
+		{`unique_by(length)`, d(`["ab","c","de","f"]`), d(`["c","ab"]`)},
+		{`unique | length`, d(`["a","a"]`), 1},
+	})
+}
+
+func TestReverse(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`reverse`, d(`[1,2,3]`), d(`[3,2,1]`)},
+		{`reverse`, d(`[]`), d(`[]`)},
+		// combining marks are characters of their own
+		{`reverse`, "ae\u0301", "\u0301ea"},
+		// strings reverse by characters, not bytes
+		{`reverse`, "héllo 世界", "界世 olléh"},
+		{`reverse`, "aé", "́ea"},
+		{`reverse`, []byte("ab"), []byte("ba")},
+		{`reverse`, nil, d(`[]`)},
+		{`reverse`, value.NewIterator([]value.Value{1, 2}), []interface{}{2, 1}},
+		{`.[] | reverse`, d(`["ab",[1,2]]`), d(`["ba",[2,1]]`)},
+	})
+
+	in := d(`[1,2]`)
+	if _, err := New(`reverse`, nil).Apply(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`[1,2]`), in); diff != "" {
+		t.Errorf("expected input to be unchanged (-want +got):\n%s", diff)
+	}
+
+	if _, err := New(`reverse`, nil).Apply(context.Background(), d(`{"a":1}`)); err == nil {
+		t.Errorf("expected reversing an object to error")
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	scores := d(`[{"n":"a","score":2},{"n":"b","score":5},{"n":"c","score":2},{"n":"d","score":5}]`)
+	runGoodCases(t, []goodCase{
+		{`min`, d(`[3,1,2]`), float64(1)},
+		{`max`, d(`[3,1,2]`), float64(3)},
+		{`min`, d(`[]`), nil},
+		{`max`, d(`[]`), nil},
+		{`max`, d(`[1,"a",null,[1]]`), d(`[1]`)},
+		{`min`, d(`[{"a":2},{"a":1}]`), d(`{"a":1}`)},
+		// min_by keeps the first of tied elements, max_by the last
+		{`min_by(.score) | .n`, scores, "a"},
+		{`max_by(.score) | .n`, scores, "d"},
+		{`min_by(.score)`, d(`[]`), nil},
+		{`max_by(length)`, d(`["ab","abc","c"]`), "abc"},
+	})
+
+	for _, str := range []string{`min`, `max`, `min_by(.a)`, `max_by(.a)`} {
+		if _, err := New(str, nil).Apply(context.Background(), "abc"); err == nil {
+			t.Errorf("expected %s of a string to error", str)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`flatten`, d(`[[1],[2,[3]]]`), d(`[1,2,3]`)},
+		{`flatten(1)`, d(`[[1],[2,[3]]]`), d(`[1,2,[3]]`)},
+		{`flatten(0)`, d(`[[1],[2,[3]]]`), d(`[[1],[2,[3]]]`)},
+		{`.items | flatten(1)`, d(`{"items":[[[1]]]}`), d(`[[1]]`)},
+		{`flatten`, d(`[1,[],[[[]]],{"a":[1]}]`), d(`[1,{"a":[1]}]`)},
+		{`flatten`, []interface{}{value.NewIterator([]value.Value{1, []interface{}{2}})}, []interface{}{1, 2}},
+		{`.[] | flatten`, d(`[[[1]],[[2]]]`), d(`[[1],[2]]`)},
+	})
+
+	errCases := []struct {
+		filter string
+		source interface{}
+	}{
+		{`flatten`, d(`{"a":1}`)},
+		{`flatten(-1)`, d(`[]`)},
+		{`flatten("a")`, d(`[]`)},
+	}
+	for _, c := range errCases {
+		if _, err := New(c.filter, nil).Apply(context.Background(), c.source); err == nil {
+			t.Errorf("%s: expected error", c.filter)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`range(3)`, nil, d(`[0,1,2]`)},
+		{`range(2;5)`, nil, d(`[2,3,4]`)},
+		{`range(0;10;2)`, nil, d(`[0,2,4,6,8]`)},
+		{`range(5;0;-2)`, nil, d(`[5,3,1]`)},
+		{`range(0;1;0.25)`, nil, d(`[0,0.25,0.5,0.75]`)},
+		{`[range(0)]`, nil, d(`[]`)},
+		{`[range(3;1)]`, nil, d(`[]`)},
+		{`[range(.n)]`, d(`{"n":2}`), d(`[0,1]`)},
+		{`range(3) | . * 2`, nil, d(`[0,2,4]`)},
+		{`[range(2) | [., 1]]`, nil, d(`[[0,1],[1,1]]`)},
+	})
+
+	for _, str := range []string{`range(0;5;0)`, `range("a")`, `range`, `range(1;2;3;4)`} {
+		if _, err := New(str, nil).Apply(context.Background(), nil); err == nil {
+			t.Errorf("expected %s to error", str)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`contains("bar")`, "foobar", true},
+		{`contains("baz")`, "foobar", false},
+		{`contains(["baz", "bar"])`, d(`["foobar","foobaz","blarp"]`), true},
+		{`contains(["bazzzz", "bar"])`, d(`["foobar","foobaz","blarp"]`), false},
+		{`contains([1, 1])`, d(`[1,2]`), true},
+		{`contains([])`, d(`[1]`), true},
+		{`contains({foo: 12, bar: [{barp: 12}]})`, d(`{"foo":12,"bar":[1,2,{"barp":12,"blip":13}]}`), true},
+		{`contains({foo: 12, bar: [{barp: 15}]})`, d(`{"foo":12,"bar":[1,2,{"barp":12,"blip":13}]}`), false},
+		{`contains({a: {b: "x"}})`, d(`{"a":{"b":"xyz","c":1}}`), true},
+		{`contains({missing: null})`, d(`{"a":1}`), false},
+		{`contains(1)`, d(`1`), true},
+		{`contains(null)`, nil, true},
+		{`contains({a: 1})`, newTestMap("a", 1, "b", 2), true},
+		{`inside("foobar")`, "bar", true},
+		{`inside(["a", "b"])`, d(`["b"]`), true},
+		{`inside({a: 1, b: 2})`, d(`{"c":3}`), false},
+		{`.[] | contains("a")`, d(`["abc","xyz"]`), d(`[true,false]`)},
+		// nested values of different types don't contain one another
+		{`contains(["a"])`, d(`[1,"abc"]`), true},
+		{`contains({a: "x"})`, d(`{"a":1}`), false},
+	})
+
+	for _, str := range []string{`contains(1)`, `contains(["a"])`, `inside({})`} {
+		if _, err := New(str, nil).Apply(context.Background(), "str"); err == nil {
+			t.Errorf("expected %s of a string to error", str)
+		}
+	}
+}
+
+func TestType(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`type`, nil, "null"},
+		{`type`, true, "boolean"},
+		{`type`, false, "boolean"},
+		{`type`, 1, "number"},
+		{`type`, 1.5, "number"},
+		{`type`, byte(1), "number"},
+		{`type`, "a", "string"},
+		{`type`, []byte("a"), "string"},
+		{`type`, d(`[]`), "array"},
+		{`type`, value.NewIterator([]value.Value{}), "array"},
+		{`type`, d(`{}`), "object"},
+		{`type`, map[interface{}]interface{}{}, "object"},
+		{`type`, newTestMap("a", 1), "object"},
+		{`[.[] | type]`, d(`[null,1,"a",[],{}]`), d(`["null","number","string","array","object"]`)},
+		{`.a | type`, d(`{"a":1}`), "number"},
+		{`3 | type`, nil, "number"},
+	})
+
+	r := memResolver{"/a": map[string]interface{}{"b": 1}}
+	got, err := New(`type`, r).Apply(context.Background(), value.NewLink("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "object" {
+		t.Errorf("expected a link to report the type of its value, got %v", got)
+	}
+}
+
+func TestEmptyAndError(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`empty`, d(`1`), []interface{}{}},
+		{`[1, empty, 2]`, nil, d(`[1,2]`)},
+		{`.[] | if . > 1 then . else empty end`, d(`[1,2,3]`), d(`[2,3]`)},
+		{`[.[] | empty]`, d(`[1,2]`), d(`[]`)},
+		{`error("x")?`, nil, []interface{}{}},
+		{`[.[] | (if . == 2 then error("two") else . end)?]`, d(`[1,2,3]`), d(`[1,3]`)},
+	})
+
+	cases := []struct {
+		filter string
+		source interface{}
+		err    string
+	}{
+		{`error("boom")`, nil, "boom"},
+		{`error`, "from input", "from input"},
+		{`error(.msg)`, d(`{"msg":"nested"}`), "nested"},
+		{`error`, d(`{"a":1}`), `{"a":1}`},
+		{`error(null)`, nil, "null"},
+	}
+	for _, c := range cases {
+		_, err := New(c.filter, nil).Apply(context.Background(), c.source)
+		if err == nil {
+			t.Errorf("%s: expected error", c.filter)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("%s: error mismatch. want: %q, got: %q", c.filter, c.err, err.Error())
+		}
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`split(",")`, "a,b,c", d(`["a","b","c"]`)},
+		// whitespace in string literals is kept
+		{`split(", ")`, "a, b,c", d(`["a","b,c"]`)},
+		{`" padded "`, nil, " padded "},
+		{`split(",")`, "abc", d(`["abc"]`)},
+		{`split(",")`, "a,", d(`["a",""]`)},
+		{`split(",")`, "", d(`[]`)},
+		{`split("")`, "abc", d(`["a","b","c"]`)},
+		{`join("-")`, d(`["a","b","c"]`), "a-b-c"},
+		{`join(", ")`, d(`["a",1,true,null,2.5]`), "a, 1, true, , 2.5"},
+		{`join("-")`, d(`[]`), ""},
+		{`split(",") | join(";")`, "a,b", "a;b"},
+		{`.[] | join("")`, d(`[["a","b"],["c"]]`), d(`["ab","c"]`)},
+	})
+
+	errCases := []struct {
+		filter string
+		source interface{}
+	}{
+		{`split(",")`, d(`[1]`)},
+		{`split(1)`, "a"},
+		{`join(",")`, d(`[[1]]`)},
+		{`join(",")`, "abc"},
+		{`join(1)`, d(`["a"]`)},
+	}
+	for _, c := range errCases {
+		if _, err := New(c.filter, nil).Apply(context.Background(), c.source); err == nil {
+			t.Errorf("%s: expected error", c.filter)
+		}
+	}
+}
+
+func TestAffixes(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`startswith("foo")`, "foobar", true},
+		{`startswith("bar")`, "foobar", false},
+		{`endswith("bar")`, "foobar", true},
+		{`endswith("foo")`, "foobar", false},
+		{`ltrimstr("foo")`, "foobar", "bar"},
+		{`ltrimstr("bar")`, "foobar", "foobar"},
+		{`rtrimstr("bar")`, "foobar", "foo"},
+		{`rtrimstr("foo")`, "foobar", "foobar"},
+		{`ltrimstr("a")`, "aab", "ab"},
+		{`[.[] | select(startswith("x_"))]`, d(`["x_a","y_b","x_c"]`), d(`["x_a","x_c"]`)},
+		{`.[] | rtrimstr(".csv")`, d(`["a.csv","b.json"]`), d(`["a","b.json"]`)},
+	})
+
+	for _, str := range []string{`startswith("a")`, `endswith("a")`, `ltrimstr("a")`, `rtrimstr("a")`} {
+		if _, err := New(str, nil).Apply(context.Background(), 1); err == nil {
+			t.Errorf("expected %s of a number to error", str)
+		}
+	}
+	if _, err := New(`startswith(1)`, nil).Apply(context.Background(), "a"); err == nil {
+		t.Errorf("expected a non-string argument to error")
+	}
+}
+
+func TestAsciiCase(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`ascii_downcase`, "Hello World_123", "hello world_123"},
+		{`ascii_upcase`, "Hello World_123", "HELLO WORLD_123"},
+		// non-ASCII characters pass through untouched
+		{`ascii_downcase`, "ÀÉÎ Straße ÅB", "ÀÉÎ straße Åb"},
+		{`ascii_upcase`, "àéî straße åb", "àéî STRAßE åB"},
+		{`ascii_upcase`, "", ""},
+		{`.[] | ascii_downcase`, d(`["A","b"]`), d(`["a","b"]`)},
+	})
+
+	for _, str := range []string{`ascii_downcase`, `ascii_upcase`} {
+		if _, err := New(str, nil).Apply(context.Background(), d(`["a"]`)); err == nil {
+			t.Errorf("expected %s of an array to error", str)
+		}
+	}
+}
+
+func TestRegexTest(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`test("^[0-9]+$")`, "12345", true},
+		{`test("^[0-9]+$")`, "123a5", false},
+		{`test("abc")`, "xxABCxx", false},
+		{`test("abc"; "i")`, "xxABCxx", true},
+		{`test("a.b"; "s")`, "a\nb", true},
+		{`test("a.b")`, "a\nb", false},
+		{`test("(?<year>\\d{4})")`, "in 2021", true},
+		{`[.[] | select(test("^x"))]`, d(`["xa","ya","xb"]`), d(`["xa","xb"]`)},
+	})
+
+	errCases := []struct {
+		filter string
+		source interface{}
+	}{
+		{`test("[")`, "a"},
+		{`test("a"; "q")`, "a"},
+		{`test("a")`, 1},
+		{`test(1)`, "a"},
+		{`test("a"; "i"; "x")`, "a"},
+	}
+	for _, c := range errCases {
+		if _, err := New(c.filter, nil).Apply(context.Background(), c.source); err == nil {
+			t.Errorf("%s: expected error", c.filter)
+		}
+	}
+
+	// compiled patterns are cached for the duration of a call
+	e := &env{}
+	a, err := e.regexp("^a", regexFlags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := e.regexp("^a", regexFlags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("expected compiled regexp to be reused")
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`"a\"b"`, nil, `a"b`},
+		{`"tab\tnew\nline"`, nil, "tab\tnew\nline"},
+		{`"\u00e9\/\\"`, nil, `é/\`},
+		{`"\d"`, nil, `\d`},
+		{`split("\n")`, "a\nb", d(`["a","b"]`)},
+	})
+}
+
+func TestMatchCapture(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`capture("(?<y>\\d{4})-(?<m>\\d{2})")`, "date: 2021-03-14", d(`{"y":"2021","m":"03"}`)},
+		{`capture("(?<y>\\d{4})-(?<m>\\d{2})"; "g")`, "2021-03, 2022-04", d(`[{"y":"2021","m":"03"},{"y":"2022","m":"04"}]`)},
+		{`capture("(?<a>x)|(?<b>y)")`, "y", d(`{"a":null,"b":"y"}`)},
+		{`[capture("(?<n>\\d)")]`, "none", d(`[]`)},
+		{`match("(?<y>\\d{4})-(\\d{2})")`, "on 2021-03", map[string]interface{}{
+			"offset": 3, "length": 7, "string": "2021-03",
+			"captures": []interface{}{
+				map[string]interface{}{"offset": 3, "length": 4, "string": "2021", "name": "y"},
+				map[string]interface{}{"offset": 8, "length": 2, "string": "03", "name": nil},
+			},
+		}},
+		// offsets & lengths count characters, not bytes
+		{`match("b+") | [.offset, .length]`, "ébbc", []interface{}{1, 2}},
+		{`[match("a"; "g") | .offset]`, "banana", []interface{}{1, 3, 5}},
+		{`[match("A"; "gi") | .string]`, "aXa", d(`["a","a"]`)},
+		{`[match("x*"; "gn")]`, "ab", d(`[]`)},
+		{`match("(z)?a") | .captures[0]`, "a", map[string]interface{}{"offset": -1, "length": 0, "string": nil, "name": nil}},
+		{`.[] | capture("(?<k>\\w+)=(?<v>\\w+)") | .v`, d(`["a=1","b=2"]`), d(`["1","2"]`)},
+	})
+
+	if _, err := New(`match("(")`, nil).Apply(context.Background(), "a"); err == nil {
+		t.Errorf("expected an invalid pattern to error")
+	}
+}
+
+func TestSub(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`sub("a"; "o")`, "banana", "bonana"},
+		{`gsub("a"; "o")`, "banana", "bonono"},
+		{`sub("x"; "o")`, "banana", "banana"},
+		{`gsub("A"; "o"; "i")`, "bAnana", "bonono"},
+		{`sub("a"; "o"; "g")`, "banana", "bonono"},
+		{`gsub("\\s+"; " ")`, "a  b\t\tc", "a b c"},
+		// replacements are applied to the capture object of each match
+		{`sub("(?<first>\\w+) (?<last>\\w+)"; .last + ", " + .first)`, "ada lovelace", "lovelace, ada"},
+		{`gsub("(?<n>\\d+)"; "<" + .n + ">")`, "a1b22c", "a<1>b<22>c"},
+		{`gsub("(?<c>[a-z])"; .c | ascii_upcase)`, "a-b", "A-B"},
+		{`gsub(""; "-")`, "ab", "-a-b-"},
+		{`.[] | sub("^x"; "")`, d(`["xa","b"]`), d(`["a","b"]`)},
+	})
+
+	errCases := []string{`sub("a")`, `sub("a"; 1)`, `gsub("("; "")`, `sub("a"; "b"; "i"; "x")`}
+	for _, str := range errCases {
+		if _, err := New(str, nil).Apply(context.Background(), "abc"); err == nil {
+			t.Errorf("expected %s to error", str)
+		}
+	}
+}
+
+func TestExplodeImplode(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`explode`, "abc", []interface{}{97, 98, 99}},
+		{`explode`, "a😀", []interface{}{97, 0x1F600}},
+		{`explode`, "", []interface{}{}},
+		{`implode`, d(`[97,98,99]`), "abc"},
+		{`implode`, d(`[128512]`), "😀"},
+		{`explode | implode`, "héllo 😀", "héllo 😀"},
+		{`explode | [.[] | . + 1] | implode`, "HAL", "IBM"},
+	})
+
+	for _, src := range []string{`[-1]`, `[1114112]`, `[55296]`, `[1.5]`, `["a"]`, `"abc"`} {
+		if _, err := New(`implode`, nil).Apply(context.Background(), d(src)); err == nil {
+			t.Errorf("expected implode of %s to error", src)
+		}
+	}
+}
+
+func TestToStringToNumber(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`tostring`, "abc", "abc"},
+		{`tostring`, 1.5, "1.5"},
+		{`tostring`, 3, "3"},
+		{`tostring`, nil, "null"},
+		{`tostring`, true, "true"},
+		{`tostring`, d(`{"b":[1,"x"],"a":null}`), `{"a":null,"b":[1,"x"]}`},
+		{`tostring`, newTestMap("a", 1), `{"a":1}`},
+		{`tonumber`, "12.5", 12.5},
+		{`tonumber`, "-3", float64(-3)},
+		{`tonumber`, "1e3", float64(1000)},
+		{`tonumber`, 7, float64(7)},
+		{`.[] | tonumber`, d(`["1",2]`), d(`[1,2]`)},
+		{`.n | tostring | tonumber`, d(`{"n":42}`), float64(42)},
+		{`"n" + (.n | tostring)`, d(`{"n":1}`), "n1"},
+	})
+
+	for _, in := range []interface{}{"abc", "", " 1", "NaN", nil, d(`[1]`)} {
+		if _, err := New(`tonumber`, nil).Apply(context.Background(), in); err == nil {
+			t.Errorf("expected tonumber of %#v to error", in)
+		}
+	}
+}
+
+func TestBase64Format(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`@base64`, "hello", "aGVsbG8="},
+		{`.data | @base64`, d(`{"data":"hi!"}`), "aGkh"},
+		{`@base64d`, "aGVsbG8=", "hello"},
+		{`@base64d`, "aGVsbG8", "hello"},
+		{`@base64 | @base64d`, "round ✓ trip", "round ✓ trip"},
+		{`@base64`, d(`[1,2]`), "WzEsMl0="},
+		{`.[] | @base64`, d(`["a","b"]`), d(`["YQ==","Yg=="]`)},
+	})
+
+	if _, err := New(`@base64d`, nil).Apply(context.Background(), "not base64!"); err == nil {
+		t.Errorf("expected decoding invalid base64 to error")
+	}
+	if _, err := New(`@nope`, nil).Apply(context.Background(), "a"); err == nil {
+		t.Errorf("expected an unknown format to error")
+	}
+}
+
+func TestRowFormats(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`@csv`, d(`["a",1,true,null,2.5]`), `a,1,true,,2.5`},
+		{`@csv`, d(`["x,y","say \"hi\"","line\nbreak"]`), "\"x,y\",\"say \"\"hi\"\"\",\"line\nbreak\""},
+		{`@tsv`, d(`["a","b c",1]`), "a\tb c\t1"},
+		{`@tsv`, d(`["tab\there","x,y"]`), "\"tab\there\"\tx,y"},
+		{`@csv`, d(`[]`), ``},
+		{`.[] | @csv`, d(`[["a",1],["b",2]]`), d(`["a,1","b,2"]`)},
+		{`[.name, .age] | @csv`, d(`{"name":"Smith, J","age":40}`), `"Smith, J",40`},
+	})
+
+	for _, src := range []string{`[[1]]`, `[{"a":1}]`, `"abc"`} {
+		if _, err := New(`@csv`, nil).Apply(context.Background(), d(src)); err == nil {
+			t.Errorf("expected @csv of %s to error", src)
+		}
+	}
+}
+
+func TestJSONTextFormats(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`@json`, d(`{"b":{"d":1,"c":[true,null]},"a":"x"}`), `{"a":"x","b":{"c":[true,null],"d":1}}`},
+		{`@json`, "a", `"a"`},
+		{`@json`, 1.5, `1.5`},
+		{`@json`, nil, `null`},
+		{`@json`, newTestMap("z", 1, "a", 2), `{"a":2,"z":1}`},
+		{`@json | fromjson`, d(`{"a":[1,2]}`), map[string]interface{}{"a": []interface{}{1, 2}}},
+		{`@text`, "a", "a"},
+		{`@text`, d(`{"b":1,"a":2}`), `{"a":2,"b":1}`},
+		{`@text`, 3, "3"},
+	})
+
+	// key order is stable across runs
+	in := d(`{"k1":1,"k2":2,"k3":3,"k4":4,"k5":5,"k6":6,"k7":7,"k8":8}`)
+	for i := 0; i < 10; i++ {
+		got, err := New(`@json`, nil).Apply(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != `{"k1":1,"k2":2,"k3":3,"k4":4,"k5":5,"k6":6,"k7":7,"k8":8}` {
+			t.Fatalf("unexpected key order: %s", got)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`"hello \(.name)"`, d(`{"name":"ada"}`), "hello ada"},
+		{`"\(.a) + \(.b) = \(.a + .b)"`, d(`{"a":1,"b":2}`), "1 + 2 = 3"},
+		{`"\(.)"`, d(`{"x":[1]}`), `{"x":[1]}`},
+		{`"v: \(.v)"`, d(`{"v":null}`), "v: null"},
+		{`"\(.s | ascii_upcase)!"`, d(`{"s":"hi"}`), "HI!"},
+		{`"nested \("inner \(.x)")"`, d(`{"x":1}`), "nested inner 1"},
+		{`"paren \(")") \((1 + 2) * 2)"`, nil, "paren ) 6"},
+		// an escaped backslash before a paren is literal
+		{`"literal \\(.x)"`, d(`{"x":1}`), `literal \(.x)`},
+		{`.[] | "n=\(.)"`, d(`[1,2]`), d(`["n=1","n=2"]`)},
+		{`"\(.[])"`, d(`["a","b"]`), d(`["a","b"]`)},
+	})
+
+	for _, str := range []string{`"\(.a"`, `"\()"`, `"\(1 2)"`} {
+		if _, err := New(str, nil).Apply(context.Background(), nil); err == nil {
+			t.Errorf("expected %s to error", str)
+		}
+	}
+}
+
+func TestVariableBinding(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.a as $x | $x`, d(`{"a":1}`), float64(1)},
+		{`.a as $x | .b + $x`, d(`{"a":1,"b":2}`), float64(3)},
+		{`.items[] as $item | $item.price`, d(`{"items":[{"price":1},{"price":2}]}`), d(`[1,2]`)},
+		{`.factor as $f | [.vals[] | . * $f]`, d(`{"factor":10,"vals":[1,2]}`), d(`[10,20]`)},
+		{`. as $root | .a | $root.b`, d(`{"a":1,"b":2}`), float64(2)},
+		{`.a as $x | .b as $y | [$x, $y]`, d(`{"a":1,"b":2}`), d(`[1,2]`)},
+		// the body extends to the end of the pipeline
+		{`1 as $x | 2 as $y | $x + $y | . * 10`, nil, float64(30)},
+		{`"hi" as $s | "\($s) there"`, nil, "hi there"},
+		{`[.[] | . as $n | select($n > 1)]`, d(`[1,2,3]`), d(`[2,3]`)},
+		{`.vals[] as $v | $v * 2`, d(`{"vals":[1,2]}`), d(`[2,4]`)},
+		{`{x: (.a as $v | $v + 1)}`, d(`{"a":1}`), d(`{"x":2}`)},
+		// variable names end at characters that can't be part of a name
+		{`. as $x | $x-1`, float64(3), float64(2)},
+		{`. as $x_1 | $x_1*2`, float64(3), float64(6)},
+		{`. as $x | [$x,$x]`, float64(3), d(`[3,3]`)},
+	})
+
+	for _, str := range []string{`. as x | x`, `. as $x`, `. as $x $x`, `as`, `$undefined`} {
+		if _, err := New(str, nil).Apply(context.Background(), nil); err == nil {
+			t.Errorf("expected %s to error", str)
+		}
+	}
+}
+
+func TestReduce(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`reduce .[] as $x (0; . + $x)`, d(`[1,2,3]`), float64(6)},
+		{`reduce .[] as $x (0; . + $x)`, d(`[]`), float64(0)},
+		{`reduce .[] as $x ({"n": 0, "sum": 0}; {"n": (.n + 1), "sum": (.sum + $x)})`, d(`[2,4]`), d(`{"n":2,"sum":6}`)},
+		{`reduce .[] as $x ([]; [$x] + .)`, d(`[1,2,3]`), d(`[3,2,1]`)},
+		{`reduce .items[] as $i (.start; . * $i)`, d(`{"start":2,"items":[3,4]}`), float64(24)},
+		{`reduce range(5) as $i (0; . + $i)`, nil, float64(10)},
+		{`reduce .[] as $w (""; . + $w + " ")`, d(`["a","b"]`), "a b "},
+		{`.[] | reduce .[] as $x (0; . + $x)`, d(`[[1,2],[3]]`), d(`[3,3]`)},
+	})
+
+	for _, str := range []string{`reduce .[] ($x; 0)`, `reduce .[] as x (0; .)`, `reduce .[] as $x (0)`, `reduce .[] as $x (0; .; .)`} {
+		if _, err := New(str, nil).Apply(context.Background(), d(`[1]`)); err == nil {
+			t.Errorf("expected %s to error", str)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`[paths]`, d(`{"a":1,"b":{"c":[2,{"d":3}]}}`), []interface{}{
+			[]interface{}{"a"},
+			[]interface{}{"b"},
+			[]interface{}{"b", "c"},
+			[]interface{}{"b", "c", 0},
+			[]interface{}{"b", "c", 1},
+			[]interface{}{"b", "c", 1, "d"},
+		}},
+		{`[leaf_paths]`, d(`{"a":1,"b":{"c":[2,{"d":3}]}}`), []interface{}{
+			[]interface{}{"a"},
+			[]interface{}{"b", "c", 0},
+			[]interface{}{"b", "c", 1, "d"},
+		}},
+		{`[paths]`, d(`[[],{}]`), []interface{}{[]interface{}{0}, []interface{}{1}}},
+		{`[leaf_paths]`, d(`[[],{},null]`), []interface{}{[]interface{}{2}}},
+		{`[paths]`, d(`5`), d(`[]`)},
+		{`[leaf_paths | join(".")]`, d(`{"x":{"y":"z"},"w":"v"}`), d(`["w","x.y"]`)},
+	})
+}
+
+func TestGetSetPath(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`getpath(["a","b"])`, d(`{"a":{"b":5}}`), float64(5)},
+		{`getpath(["a",1,"c"])`, d(`{"a":[{},{"c":true}]}`), true},
+		{`getpath(["a",-1])`, d(`{"a":[1,2,3]}`), float64(3)},
+		{`getpath(["a","b"])`, d(`{"x":1}`), nil},
+		{`getpath(["a",5])`, d(`{"a":[1]}`), nil},
+		{`getpath([])`, d(`[1]`), d(`[1]`)},
+		{`setpath(["a","b"]; 5)`, d(`{"a":{"b":1,"c":2}}`), d(`{"a":{"b":5,"c":2}}`)},
+		{`setpath(["a","b"]; 5)`, nil, d(`{"a":{"b":5}}`)},
+		{`setpath(["a",2]; "x")`, d(`{"a":["y"]}`), d(`{"a":["y",null,"x"]}`)},
+		{`setpath([1,"k"]; .[0])`, d(`[3]`), d(`[3,{"k":3}]`)},
+		{`setpath([-1]; 0)`, d(`[1,2]`), d(`[1,0]`)},
+		{`setpath([]; 1)`, d(`{"a":2}`), float64(1)},
+	})
+
+	in := d(`{"a":{"b":[1]}}`)
+	if _, err := New(`setpath(["a","b",0]; 2)`, nil).Apply(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`{"a":{"b":[1]}}`), in); diff != "" {
+		t.Errorf("setpath modified its input (-want +got):\n%s", diff)
+	}
+
+	errCases := []string{
+		`getpath("a")`,
+		`getpath([0])`,
+		`setpath([0]; 1)`,
+		`setpath([true]; 1)`,
+		`setpath(["a"])`,
+	}
+	for _, str := range errCases {
+		if _, err := New(str, nil).Apply(context.Background(), d(`{"a":[1]}`)); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+}
+
+func TestDel(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`del(.a.b)`, d(`{"a":{"b":1,"c":2},"d":3}`), d(`{"a":{"c":2},"d":3}`)},
+		{`del(.a)`, d(`{"a":1,"b":2}`), d(`{"b":2}`)},
+		{`del(.x.y)`, d(`{"a":1}`), d(`{"a":1}`)},
+		{`del(.[1])`, d(`[1,2,3]`), d(`[1,3]`)},
+		{`del(.[])`, d(`[1,2,3]`), d(`[]`)},
+		{`del(.[] | select(. > 1))`, d(`[3,1,2,0]`), d(`[1,0]`)},
+		{`del(.items[].secret)`, d(`{"items":[{"id":1,"secret":"a"},{"id":2}]}`), d(`{"items":[{"id":1},{"id":2}]}`)},
+		{`del(.)`, d(`{"a":1}`), nil},
+		{`delpaths([["a"],["c","d"]])`, d(`{"a":1,"b":2,"c":{"d":3,"e":4}}`), d(`{"b":2,"c":{"e":4}}`)},
+		{`delpaths([[0],[2],[3]])`, d(`["a","b","c","d","e"]`), d(`["b","e"]`)},
+		{`delpaths([["a",0],["a",1]])`, d(`{"a":[1,2,3]}`), d(`{"a":[3]}`)},
+		{`delpaths([])`, d(`[1]`), d(`[1]`)},
+		{`[paths] | length`, d(`{"a":[1,2]}`), 3},
+	})
+
+	in := d(`{"a":[1,2]}`)
+	if _, err := New(`del(.a[0])`, nil).Apply(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`{"a":[1,2]}`), in); diff != "" {
+		t.Errorf("del modified its input (-want +got):\n%s", diff)
+	}
+
+	errCases := []string{
+		`del(1)`,
+		`del(.a | length)`,
+		`delpaths(["a"])`,
+		`delpaths("a")`,
+		`delpaths([[true]])`,
+	}
+	for _, str := range errCases {
+		if _, err := New(str, nil).Apply(context.Background(), d(`{"a":[1]}`)); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+}
+
+func TestFirstLastNth(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`first`, d(`[1,2,3]`), float64(1)},
+		{`last`, d(`[1,2,3]`), float64(3)},
+		{`nth(1)`, d(`[1,2,3]`), float64(2)},
+		{`first`, d(`[]`), nil},
+		{`last`, d(`[]`), nil},
+		{`nth(5)`, d(`[1]`), nil},
+		{`.[] | first`, d(`[[1,2],[3]]`), d(`[1,3]`)},
+		{`first(.[] | select(. > 1))`, d(`[1,2,3]`), float64(2)},
+		{`last(.[] | select(. > 1))`, d(`[3,1,2,0]`), float64(2)},
+		{`nth(1; .[] | select(. > 1))`, d(`[1,2,3,4]`), float64(3)},
+		{`[first(empty)]`, nil, d(`[]`)},
+		{`[last(empty)]`, nil, d(`[]`)},
+		{`[nth(3; .[])]`, d(`[1]`), d(`[]`)},
+		{`first(range(1000000000000))`, nil, float64(0)},
+		{`nth(5; range(1000000000000))`, nil, float64(5)},
+		{`first(range(10; 0; -1))`, nil, float64(10)},
+		{`first(.items[])`, d(`{"items":[{"a":1},{"a":2}]}`), d(`{"a":1}`)},
+		{`first(.[] | .a)`, d(`[{"a":1},{"a":2}]`), float64(1)},
+	})
+
+	for _, str := range []string{`nth(-1)`, `nth("a")`, `nth(-1; .[])`, `first`} {
+		in := d(`[1]`)
+		if str == `first` {
+			in = "text"
+		}
+		if _, err := New(str, nil).Apply(context.Background(), in); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+
+	src := &countingIterator{Iterator: value.NewIterator([]value.Value{1, 2, 3, 4, 5})}
+	got, err := New(`nth(1; .[])`, nil).Apply(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 2 {
+		t.Errorf("expected 2, got %v", got)
+	}
+	if src.nexts != 2 {
+		t.Errorf("expected nth to stop advancing after 2 values, advanced %d times", src.nexts)
+	}
+	if !src.closed {
+		t.Errorf("expected nth to close the source iterator")
+	}
+
+	src = &countingIterator{Iterator: value.NewIterator([]value.Value{1, 2, 3})}
+	if got, err = New(`first`, nil).Apply(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 || src.nexts != 1 || !src.closed {
+		t.Errorf("expected first to read one value & close, got %v after %d advances", got, src.nexts)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`[limit(3; .[])]`, d(`[1,2,3,4,5]`), d(`[1,2,3]`)},
+		{`[limit(3; .[])]`, d(`[1,2]`), d(`[1,2]`)},
+		{`[limit(0; .[])]`, d(`[1,2]`), d(`[]`)},
+		{`[limit(-1; .[])]`, d(`[1,2]`), d(`[]`)},
+		{`[limit(2; .[] | select(. > 1))]`, d(`[1,2,3,4]`), d(`[2,3]`)},
+		{`[limit(3; range(1000000000000))]`, nil, d(`[0,1,2]`)},
+		{`[.[] | [limit(1; .[])]]`, d(`[[1,2],[3]]`), d(`[[1],[3]]`)},
+	})
+
+	if _, err := New(`limit("a"; .[])`, nil).Apply(context.Background(), d(`[1]`)); err == nil {
+		t.Errorf("expected a non-numeric limit to error")
+	}
+
+	vals := make([]value.Value, 10000)
+	for i := range vals {
+		vals[i] = i
+	}
+	src := &countingIterator{Iterator: value.NewIterator(vals)}
+	got, err := New(`[limit(3; .[])]`, nil).Apply(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{0, 1, 2}, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	if src.nexts != 3 {
+		t.Errorf("expected limit to scan 3 values, advanced %d times", src.nexts)
+	}
+	if !src.closed {
+		t.Errorf("expected limit to close the source iterator")
+	}
+}
+
+func TestAnyAll(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`any`, d(`[false,true]`), true},
+		{`any`, d(`[false,null]`), false},
+		{`any`, d(`[]`), false},
+		{`all`, d(`[true,1,"a"]`), true},
+		{`all`, d(`[true,false]`), false},
+		{`all`, d(`[]`), true},
+		{`any(.age > 30)`, d(`[{"age":20},{"age":40}]`), true},
+		{`all(.age > 30)`, d(`[{"age":20},{"age":40}]`), false},
+		{`all(has("id"))`, d(`[{"id":1},{"id":2}]`), true},
+		{`any(.[]; . == 2)`, d(`[1,2,3]`), true},
+		{`all(.items[]; . > 0)`, d(`{"items":[1,2]}`), true},
+		{`any(empty; .)`, nil, false},
+		{`all(range(1000000000000); . < 5)`, nil, false},
+		{`any(range(1000000000000); . == 5)`, nil, true},
+		{`.[] | any`, d(`[[true],[false]]`), d(`[true,false]`)},
+	})
+
+	src := &countingIterator{Iterator: value.NewIterator([]value.Value{1, 2, 3, 4, 5})}
+	got, err := New(`any(. == 2)`, nil).Apply(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != true || src.nexts != 2 || !src.closed {
+		t.Errorf("expected any to stop at the first match & close, got %v after %d advances", got, src.nexts)
+	}
+
+	if _, err := New(`any(.; .; .)`, nil).Apply(context.Background(), d(`[]`)); err == nil {
+		t.Errorf("expected any with 3 arguments to error")
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`abs`, d(`-3.5`), float64(3.5)},
+		{`abs`, d(`2`), float64(2)},
+		{`floor`, d(`1.7`), float64(1)},
+		{`floor`, d(`-1.2`), float64(-2)},
+		{`ceil`, d(`1.2`), float64(2)},
+		{`ceil`, d(`-1.7`), float64(-1)},
+		{`round`, d(`2.5`), float64(3)},
+		{`round`, d(`-2.5`), float64(-3)},
+		{`round`, d(`1.4`), float64(1)},
+		{`sqrt`, d(`16`), float64(4)},
+		{`.n | floor`, map[string]interface{}{"n": 7}, float64(7)},
+		{`[.[] | abs]`, d(`[-1,0,1]`), d(`[1,0,1]`)},
+	})
+
+	got, err := New(`sqrt`, nil).Apply(context.Background(), float64(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := got.(float64); !ok || !math.IsNaN(n) {
+		t.Errorf("expected sqrt of a negative to be NaN, got %v", got)
+	}
+
+	for _, str := range []string{`abs`, `floor`, `ceil`, `round`, `sqrt`} {
+		if _, err := New(str, nil).Apply(context.Background(), "a"); err == nil {
+			t.Errorf("expected %s of a string to error", str)
+		}
+	}
+}
+
+func TestMathFuncs(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`pow(2; 10)`, nil, float64(1024)},
+		{`pow(.; 2)`, d(`3`), float64(9)},
+		{`pow(4; 0.5)`, nil, float64(2)},
+		{`log`, d(`1`), float64(0)},
+		{`exp`, d(`0`), float64(1)},
+		{`log10`, d(`1000`), float64(3)},
+		{`log2`, d(`8`), float64(3)},
+		{`exp2`, d(`3`), float64(8)},
+		{`sin`, d(`0`), float64(0)},
+		{`cos`, d(`0`), float64(1)},
+		{`tan`, d(`0`), float64(0)},
+		{`[.[] | exp | log]`, d(`[0,1]`), d(`[0,1]`)},
+	})
+
+	got, err := New(`log`, nil).Apply(context.Background(), float64(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := got.(float64); !ok || !math.IsInf(n, -1) {
+		t.Errorf("expected log of zero to be -Inf, got %v", got)
+	}
+
+	for _, str := range []string{`log`, `pow(.; 2)`, `pow(2)`} {
+		if _, err := New(str, nil).Apply(context.Background(), "a"); err == nil {
+			t.Errorf("expected %q to error", str)
+		}
+	}
+}
+
+func TestLinkSelectors(t *testing.T) {
+	r := memResolver{
+		"/obj":  map[string]interface{}{"b": "bee", "next": value.NewLink("/list")},
+		"/list": []interface{}{"x", "y", "z"},
+	}
+	source := func() interface{} {
+		return map[string]interface{}{
+			"a":    value.NewLink("/obj"),
+			"list": value.NewLink("/list"),
+		}
+	}
+
+	cases := []struct {
+		filter string
+		expect interface{}
+	}{
+		{`.a.b`, "bee"},
+		{`.a.next[2]`, "z"},
+		{`.list[1]`, "y"},
+		{`.list[]`, []interface{}{"x", "y", "z"}},
+		{`.list[1:3]`, []interface{}{"y", "z"}},
+		{`.a | [.b, .next[0]]`, []interface{}{"bee", "x"}},
+	}
+	for _, c := range cases {
+		t.Run(c.filter, func(t *testing.T) {
+			got, err := New(c.filter, r).Apply(context.Background(), source())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expect, got); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := New(`.a.b`, nil).Apply(context.Background(), source()); err == nil {
+		t.Errorf("expected following a link without a resolver to error")
+	}
+	if _, err := New(`.missing.b`, r).Apply(context.Background(), map[string]interface{}{"missing": value.NewLink("/nope")}); err == nil {
+		t.Errorf("expected following a link the resolver can't find to error")
+	}
+}
+
+func TestNewMapValues(t *testing.T) {
+	m := func() value.Map {
+		return value.NewMap([]interface{}{"z", "a"}, []value.Value{1, map[string]interface{}{"b": true}})
+	}
+	cases := []struct {
+		filter string
+		expect interface{}
+	}{
+		{`.a.b`, true},
+		{`keys`, []interface{}{"a", "z"}},
+		{`keys_unsorted`, []interface{}{"z", "a"}},
+		{`[to_entries | .[] | .key]`, []interface{}{"a", "z"}},
+		{`has("z")`, true},
+		{`has("y")`, false},
+	}
+	for _, c := range cases {
+		got, err := New(c.filter, nil).Apply(context.Background(), m())
+		if err != nil {
+			t.Errorf("%s: %s", c.filter, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("%s result mismatch (-want +got):\n%s", c.filter, diff)
+		}
+	}
+}
+
+func TestArrayValues(t *testing.T) {
+	arrays := map[string]func() value.Array{
+		"NewArray": func() value.Array {
+			return value.NewArray([]value.Value{"a", "b", "c", "d"})
+		},
+		"NewArrayFromIterator": func() value.Array {
+			return value.NewArrayFromIterator(value.NewIterator([]value.Value{"a", "b", "c", "d"}))
+		},
+	}
+	cases := []struct {
+		filter string
+		expect interface{}
+	}{
+		{`.[0]`, "a"},
+		{`.[2]`, "c"},
+		{`.[9]`, nil},
+		{`.[0:2]`, []interface{}{"a", "b"}},
+		{`length`, 4},
+		{`.[]`, []interface{}{"a", "b", "c", "d"}},
+	}
+	for name, arr := range arrays {
+		for _, c := range cases {
+			got, err := New(c.filter, nil).Apply(context.Background(), arr())
+			if err != nil {
+				t.Errorf("%s %s: %s", name, c.filter, err)
+				continue
+			}
+			if diff := cmp.Diff(c.expect, got); diff != "" {
+				t.Errorf("%s %s result mismatch (-want +got):\n%s", name, c.filter, diff)
+			}
+		}
+	}
+
+	m := value.NewMap([]interface{}{"a"}, []value.Value{1})
+	if got, err := New(`length`, nil).Apply(context.Background(), m); err != nil || got != 1 {
+		t.Errorf("expected map length 1, got %v (err: %v)", got, err)
+	}
+}
+
+func TestJSONStreamInput(t *testing.T) {
+	stream := func(t *testing.T) value.Iterator {
+		it, err := value.NewJSONStream(strings.NewReader(`[{"a":1},{"a":2},{"a":3}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+
+	got, err := New(`.[] | .a`, nil).Apply(context.Background(), stream(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{1, 2, 3}, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	src := &countingIterator{Iterator: stream(t)}
+	if got, err = New(`first(.[] | select(.a > 1))`, nil).Apply(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"a": 2}, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	if src.nexts != 2 {
+		t.Errorf("expected 2 elements to be decoded, got %d", src.nexts)
+	}
+
+	// collecting outputs applies the filter to each element as it's decoded
+	src = &countingIterator{Iterator: stream(t)}
+	if _, err = New(`[.[] | .a | if . == 1 then error("stop") else . end]`, nil).Apply(context.Background(), src); err == nil {
+		t.Fatal("expected an error")
+	}
+	if src.nexts != 1 {
+		t.Errorf("expected 1 element to be decoded, got %d", src.nexts)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	if _, err := Compile(`.a | `, nil); err == nil {
+		t.Errorf("expected compiling an invalid filter to error")
+	}
+	if _, err := New(`.a | `, nil).Apply(context.Background(), nil); err == nil {
+		t.Errorf("expected applying an invalid filter to error")
+	}
+
+	f, err := Compile(`[.items[] | select(.n > 1) | .n * 2] | add`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in := map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"n": float64(1)},
+				map[string]interface{}{"n": float64(i)},
+			}}
+			got, err := f.Apply(context.Background(), in)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var expect interface{} = float64(i * 2)
+			if i <= 1 {
+				expect = nil
+			}
+			if got != expect {
+				errs <- fmt.Errorf("input %d: expected %v, got %v", i, expect, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+const benchFilter = `.items[] | select(.price > 10) | {name: .name, total: (.price * .qty)}`
+
+var benchInput = d(`{"items":[{"name":"a","price":12,"qty":2},{"name":"b","price":8,"qty":1}]}`)
+
+func BenchmarkApplyParseEachCall(b *testing.B) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := New(benchFilter, nil).Apply(ctx, benchInput); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkApplyCompiled(b *testing.B) {
+	ctx := context.Background()
+	f, err := Compile(benchFilter, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Apply(ctx, benchInput); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestApplyStream(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		filter string
+		in     interface{}
+		expect []interface{}
+	}{
+		{`.[] | .a`, d(`[{"a":1},{"a":"b"}]`), []interface{}{float64(1), "b"}},
+		{`.`, d(`{"a":1}`), []interface{}{d(`{"a":1}`)}},
+		{``, "x", []interface{}{"x"}},
+		{`.[] | select(. > 1)`, d(`[1,2,3]`), []interface{}{float64(2), float64(3)}},
+		{`[.[] | . * 2]`, d(`[1,2]`), []interface{}{[]interface{}{float64(2), float64(4)}}},
+		{`empty`, nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.filter, func(t *testing.T) {
+			it, err := New(c.filter, nil).ApplyStream(ctx, c.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []interface{}
+			for it.Next() {
+				var v interface{}
+				if err := it.Scan(&v); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v)
+			}
+			if err := it.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expect, got); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := New(`.a |`, nil).ApplyStream(ctx, nil); err == nil {
+		t.Errorf("expected a parse error")
+	}
+
+	it, err := New(`.[] | error("boom")`, nil).ApplyStream(ctx, d(`[1]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+	}
+	if err := it.Close(); err == nil {
+		t.Errorf("expected close to return the filter's error")
+	}
+}
+
+func TestApplyStreamBounded(t *testing.T) {
+	vals := make([]value.Value, 100000)
+	for i := range vals {
+		vals[i] = i
+	}
+	src := &countingIterator{Iterator: value.NewIterator(vals)}
+	it, err := New(`.[] | . * 2`, nil).ApplyStream(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if !it.Next() {
+			t.Fatalf("expected output %d", i)
+		}
+		var v interface{}
+		if err := it.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != float64(i*2) {
+			t.Errorf("expected %d, got %v", i*2, v)
+		}
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the producer may run at most one value ahead of the consumer
+	if src.nexts > 4 {
+		t.Errorf("expected source to be read one value at a time, advanced %d times", src.nexts)
+	}
+	if !src.closed {
+		t.Errorf("expected closing the output to close the source iterator")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it, err = New(`range(1000000000000)`, nil).ApplyStream(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if !it.Next() {
+			t.Fatalf("expected output %d", i)
+		}
+	}
+	cancel()
+	if it.Next() {
+		t.Errorf("expected iteration to stop once the context is cancelled")
+	}
+	if err := it.Close(); err != context.Canceled {
+		t.Errorf("expected close to return context.Canceled, got %v", err)
+	}
+}
+
+// slowIterator is an endless iterator that blocks for a delay on every
+// advance
+type slowIterator struct {
+	delay  time.Duration
+	i      int
+	closed bool
+}
+
+func (it *slowIterator) Next() bool {
+	time.Sleep(it.delay)
+	it.i++
+	return true
+}
+
+func (it *slowIterator) Scan(dest value.Value) error {
+	*(dest.(*interface{})) = it.i
+	return nil
+}
+
+func (it *slowIterator) Key() interface{} { return it.i }
+func (it *slowIterator) Close() error     { it.closed = true; return nil }
+func (it *slowIterator) IsOrdered() bool  { return true }
+
+func TestApplyCancellation(t *testing.T) {
+	cases := []string{
+		`.[]`,
+		`.[] | . + 1`,
+		`[.[] | select(. < 0)]`,
+		`length`,
+		`.[1000000000]`,
+		`.[5:1000000000]`,
+		// suppressing errors doesn't suppress cancellation
+		`(.[] | . + 1)?`,
+		`[.[] | select(. < 0)]?`,
+		`.[]?`,
+	}
+	for _, str := range cases {
+		t.Run(str, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			src := &slowIterator{delay: time.Millisecond}
+
+			start := time.Now()
+			_, err := New(str, nil).Apply(ctx, src)
+			if err != context.DeadlineExceeded {
+				t.Errorf("expected a deadline exceeded error, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected cancellation to stop the filter promptly, took %s", elapsed)
+			}
+			if !src.closed {
+				t.Errorf("expected cancellation to close the source iterator")
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := New(`[..]`, nil).Apply(ctx, d(`{"a":[1,{"b":2}]}`)); err != context.Canceled {
+		t.Errorf("expected recursive descent to return context.Canceled, got %v", err)
+	}
+}
+
+func TestScannerPositions(t *testing.T) {
+	s := newScanner(strings.NewReader(".ab | \"x\ny\" |  .c"))
+	expect := []position{
+		{Line: 1, Col: 1, Offset: 0},  // .
+		{Line: 1, Col: 2, Offset: 1},  // ab
+		{Line: 1, Col: 5, Offset: 4},  // |
+		{Line: 1, Col: 7, Offset: 6},  // "x\ny"
+		{Line: 2, Col: 4, Offset: 12}, // |
+		{Line: 2, Col: 7, Offset: 15}, // .
+		{Line: 2, Col: 8, Offset: 16}, // c
+		{Line: 2, Col: 9, Offset: 17}, // EOF
+	}
+	var got []position
+	for {
+		tok := s.Scan()
+		got = append(got, tok.Pos)
+		if tok.Type == tEOF {
+			break
+		}
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("positions mismatch (-want +got):\n%s", diff)
+	}
+
+	cases := []struct {
+		filter string
+		err    string
+	}{
+		{`.a | )`, "line 1, column 6: unexpected token: )"},
+		{"\"first\nline\" | .b | ]", "line 2, column 14: unexpected token: ]"},
+	}
+	for _, c := range cases {
+		_, err := Compile(c.filter, nil)
+		if err == nil {
+			t.Errorf("%q: expected error", c.filter)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("%q: error mismatch. want: %q, got: %q", c.filter, c.err, err.Error())
+		}
+	}
+}
+
+func TestMultiLineFilters(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{".a\n| .b", d(`{"a":{"b":1}}`), float64(1)},
+		{"\t.a\t|\t.b\t", d(`{"a":{"b":1}}`), float64(1)},
+		{`
+			.items[]
+			| select(.n > 1)
+			| {
+				name: .name,
+				double: (.n * 2)
+			}
+		`, d(`{"items":[{"name":"a","n":1},{"name":"b","n":2}]}`), d(`[{"name":"b","double":4}]`)},
+		{"[\n\t1,\n\t2\n]", nil, d(`[1,2]`)},
+		{"if .a\n\tthen \"yes\"\n\telse \"no\"\nend", d(`{"a":true}`), "yes"},
+		{"reduce .[] as $x\n\t(0;\n\t . + $x)", d(`[1,2]`), float64(3)},
+	})
+
+	_, err := Compile("[\n\t.a,\n\t.b\n\t)", nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 4, column 2:") {
+		t.Errorf("expected an error on line 4, column 2. got: %v", err)
+	}
+}
+
+func TestBracketKeySelector(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.["a b"]`, d(`{"a b":1}`), float64(1)},
+		{`.["123"]`, d(`{"123":"digits"}`), "digits"},
+		{`.["with-dash"]`, d(`{"with-dash":true}`), true},
+		{`.["missing"]`, d(`{"a":1}`), nil},
+		{`.["a"].["b c"]`, d(`{"a":{"b c":2}}`), float64(2)},
+		{`.a["b c"]`, d(`{"a":{"b c":2}}`), float64(2)},
+		{`.["a b"]?`, d(`[1]`), d(`[]`)},
+		{`.["x", "y"]`, nil, d(`["x","y"]`)},
+	})
+
+	if _, err := Compile(`.["a" "b"]`, nil); err == nil {
+		t.Errorf("expected two strings without a comma to error")
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`1e3`, nil, float64(1000)},
+		{`1E3`, nil, float64(1000)},
+		{`1.5e-2`, nil, float64(0.015)},
+		{`2.5E+1`, nil, float64(25)},
+		{`.5`, nil, float64(0.5)},
+		{`. * 1e2`, d(`3`), float64(300)},
+		{`[1e1, 2]`, nil, d(`[10,2]`)},
+		{`.[1e0]`, d(`["a","b"]`), "b"},
+		{`.[0:2.0]`, d(`["a","b","c"]`), d(`["a","b"]`)},
+		{`.[2.]`, d(`["a","b","c"]`), "c"},
+		{`if . then 1else 2 end`, true, float64(1)},
+	})
+
+	for _, str := range []string{`.[1.5]`, `.[0:1.5]`, `.[1e-1]`} {
+		_, err := Compile(str, nil)
+		if err == nil || !strings.Contains(err.Error(), "whole number") {
+			t.Errorf("%s: expected a whole number error, got %v", str, err)
+		}
+	}
+}
+
+func TestIndexRangeIterator(t *testing.T) {
+	data := []value.Value{"a", "b", "c", "d", "e"}
+	ranges := []string{`.[1:3]`, `.[0:2]`, `.[2:4]`, `.[0:10]`, `.[3:1]`, `.[4:5]`, `.[7:9]`}
+	for _, str := range ranges {
+		t.Run(str, func(t *testing.T) {
+			slice := make([]interface{}, len(data))
+			copy(slice, data)
+			expect, err := New(str, nil).Apply(context.Background(), slice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := New(str, nil).Apply(context.Background(), value.NewIterator(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expect, got); diff != "" {
+				t.Errorf("iterator & slice results differ (-slice +iterator):\n%s", diff)
+			}
+		})
+	}
+
+	src := &countingIterator{Iterator: value.NewIterator(data)}
+	if _, err := New(`.[1:3]`, nil).Apply(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if src.nexts != 3 || !src.closed {
+		t.Errorf("expected range to read only through its end & close the source, advanced %d times", src.nexts)
+	}
+
+	// negative positions count from the end
+	sel := &fIndexRangeSelector{start: -3, stop: -1}
+	got, err := sel.apply(context.Background(), &env{}, value.NewIterator(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{"c", "d"}, got); diff != "" {
+		t.Errorf("negative range mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOpenEndedRange(t *testing.T) {
+	data := []value.Value{"a", "b", "c", "d", "e"}
+	cases := []struct {
+		filter string
+		expect []interface{}
+	}{
+		{`.[2:]`, []interface{}{"c", "d", "e"}},
+		{`.[0:]`, []interface{}{"a", "b", "c", "d", "e"}},
+		{`.[5:]`, []interface{}{}},
+		{`.[9:]`, []interface{}{}},
+		{`.[0:0]`, []interface{}{}},
+	}
+	for _, c := range cases {
+		t.Run(c.filter, func(t *testing.T) {
+			slice := make([]interface{}, len(data))
+			copy(slice, data)
+			fromSlice, err := New(c.filter, nil).Apply(context.Background(), slice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromIter, err := New(c.filter, nil).Apply(context.Background(), value.NewIterator(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expect, fromSlice); diff != "" {
+				t.Errorf("slice result mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(c.expect, fromIter); diff != "" {
+				t.Errorf("iterator result mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCommaGenerator(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`.foo, .bar | length`, d(`{"foo":"abc","bar":"de"}`), []interface{}{3, 2}},
+		{`.[] | (.a, .b)`, d(`[{"a":1,"b":2},{"a":3,"b":4}]`), d(`[1,2,3,4]`)},
+		{`.a, .b, .a`, d(`{"a":1,"b":2}`), d(`[1,2,1]`)},
+		{`[.a, (.b, .a)]`, d(`{"a":1,"b":2}`), d(`[1,2,1]`)},
+		{`.a[], .b`, d(`{"a":[1,2],"b":3}`), d(`[1,2,3]`)},
+		{`first(.b, .a)`, d(`{"a":1,"b":2}`), float64(2)},
+		{`del(.a, .c)`, d(`{"a":1,"b":2,"c":3}`), d(`{"b":2}`)},
+	})
+}
+
+func TestNumericLiteralStream(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`[.[] | 5]`, d(`[1,2]`), d(`[5,5]`)},
+		{`.[] | 5`, d(`[1,2]`), d(`[5,5]`)},
+		{`.[] | 5, "a"`, d(`[1,2]`), d(`[5,"a",5,"a"]`)},
+	})
+}
+
+func TestApplySharedInput(t *testing.T) {
+	filt, err := Compile(`.`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := d(`{"a":[1,{"b":2}],"c":"d"}`)
+	expect := d(`{"a":[1,{"b":2}],"c":"d"}`)
+
+	// applying a filter must only read the input, concurrent applications
+	// over the same value race if it's written to
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := filt.Apply(context.Background(), in); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if diff := cmp.Diff(expect, in); diff != "" {
+		t.Errorf("input was modified (-want +got):\n%s", diff)
+	}
+}
+
+func TestNegativeIndexes(t *testing.T) {
+	cases := []goodCase{
+		{`.[-1]`, d(`[1,2,3]`), float64(3)},
+		{`.[-3]`, d(`[1,2,3]`), float64(1)},
+		{`.[-4]`, d(`[1,2,3]`), nil},
+		{`.[-2:]`, d(`[1,2,3]`), d(`[2,3]`)},
+		{`.[:-1]`, d(`[1,2,3]`), d(`[1,2]`)},
+		{`.[-2:-1]`, d(`[1,2,3]`), d(`[2]`)},
+		{`.[-2:]`, "abc", "bc"},
+		{`.[-1]`, value.NewIterator([]value.Value{1, 2, 3}), 3},
+		{`.[:-1]`, value.NewIterator([]value.Value{1, 2, 3}), []interface{}{1, 2}},
+	}
+	runGoodCases(t, cases)
+
+	// negative positions parse as index & range selectors
+	for str, expect := range map[string]selector{
+		`.[-1]`:  fIndexSelector(-1),
+		`.[-2:]`: &fIndexRangeSelector{start: -2, open: true},
+		`.[:-1]`: &fIndexRangeSelector{stop: -1},
+	} {
+		p := parser{s: newScanner(strings.NewReader(str))}
+		fs, err := p.filters()
+		if err != nil {
+			t.Fatalf("%s: %s", str, err)
+		}
+		sel, ok := fs[0].(fSelector)
+		if !ok || len(sel) != 2 {
+			t.Fatalf("%s: expected a selector, got %#v", str, fs[0])
+		}
+		if !reflect.DeepEqual(expect, sel[1]) {
+			t.Errorf("%s: parsed selector mismatch. want: %#v, got: %#v", str, expect, sel[1])
+		}
+	}
+
+	if _, err := Compile(`.[-"a"]`, nil); err == nil {
+		t.Errorf("expected a minus without a number to error")
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	stats := &Stats{}
+	filt, err := Compile(".[] | length", nil, OptStats(stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := filt.Apply(context.Background(), d(`["a","bb","ccc"]`)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := stats.Node("length").Count; got != 24 {
+		t.Errorf("expected length to run once per input value of each application. want: 24, got: %d", got)
+	}
+	if got := stats.Node("selector").Count; got != 8 {
+		t.Errorf("expected selector to run once per application. want: 8, got: %d", got)
+	}
+}
+
+func TestBoundValueTypes(t *testing.T) {
+	// foreach, reduce & as bind the same values, so each sees the same types
+	in := []interface{}{1, 2.5, uint8(3)}
+	expect := []interface{}{1, 2.5, uint8(3)}
+	for _, str := range []string{
+		`[foreach .[] as $x (null; $x)]`,
+		`reduce .[] as $x ([]; . + [$x])`,
+		`[.[] as $x | $x]`,
+	} {
+		got, err := New(str, nil).Apply(context.Background(), in)
+		if err != nil {
+			t.Fatalf("%s: %s", str, err)
+		}
+		if diff := cmp.Diff(expect, got); diff != "" {
+			t.Errorf("%s: bound values mismatch (-want +got):\n%s", str, diff)
+		}
+	}
+}
+
+func TestBinaryOpGenerators(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`2 * .*`, d(`{"x":1}`), float64(2)},
+		{`2 * .*`, d(`{"x":1,"y":3}`), d(`[2,6]`)},
+		{`(1, 2) + (10, 20)`, nil, d(`[11,12,21,22]`)},
+		{`[.[] * 10]`, d(`[1,2]`), d(`[10,20]`)},
+		{`[.[] > 1]`, d(`[1,2]`), d(`[false,true]`)},
+		{`[.[] + empty]`, d(`[1,2]`), d(`[]`)},
+	})
+
+	_, err := New(`.[] * "a"`, nil).Apply(context.Background(), d(`[1,2]`))
+	if err == nil || err.Error() != "cannot multiply number and string" {
+		t.Errorf("expected a per-value error, got %v", err)
+	}
+}
+
+func TestApplyStreamAbandoned(t *testing.T) {
+	// waitExit reports if the goroutine feeding outputs finishes
+	waitExit := func(fd *feed) bool {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case _, ok := <-fd.vals:
+				if !ok {
+					return true
+				}
+			case <-timeout:
+				return false
+			}
+		}
+	}
+
+	// closing early signals the goroutine to stop
+	it, err := New(`range(1000000000000)`, nil).ApplyStream(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatal("expected an output")
+	}
+	fd := it.(*outputIterator).feed
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !waitExit(fd) {
+		t.Errorf("expected closing the iterator to stop producing outputs")
+	}
+
+	// an iterator that's dropped without being closed stops once it's
+	// collected
+	it, err = New(`range(1000000000000)`, nil).ApplyStream(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatal("expected an output")
+	}
+	fd = it.(*outputIterator).feed
+	it = nil
+	stopped := false
+	for i := 0; i < 100 && !stopped; i++ {
+		runtime.GC()
+		select {
+		case <-fd.done:
+			stopped = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !stopped {
+		t.Fatal("expected an abandoned iterator to signal its goroutine to stop")
+	}
+	if !waitExit(fd) {
+		t.Errorf("expected an abandoned iterator's goroutine to exit")
+	}
+}
+
+// chunkedReader returns at most one byte from each read
+type chunkedReader struct {
+	data   []byte
+	closed bool
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
+
+func (r *chunkedReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestIndexRangeReader(t *testing.T) {
+	cases := []struct {
+		filter string
+		expect string
+	}{
+		{`.[2:4]`, "cd"},
+		{`.[2:]`, "cdef"},
+		{`.[:3]`, "abc"},
+		{`.[4:10]`, "ef"},
+		{`.[9:]`, ""},
+		{`.[3:1]`, ""},
+		{`.[-2:]`, "ef"},
+		{`.[1:-1]`, "bcde"},
+	}
+	for _, c := range cases {
+		t.Run(c.filter, func(t *testing.T) {
+			rdr := &chunkedReader{data: []byte("abcdef")}
+			p := parser{s: newScanner(strings.NewReader(c.filter))}
+			fs, err := p.filters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := fs[0].(fSelector)[1].apply(context.Background(), &env{}, rdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]byte(c.expect), got); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+			if !rdr.closed {
+				t.Errorf("expected the reader to be closed")
+			}
+		})
+	}
+}
+
+func TestObjectMappingGenerators(t *testing.T) {
+	runGoodCases(t, []goodCase{
+		{`{a: (1, 2)}`, nil, d(`[{"a":1},{"a":2}]`)},
+		{`{a: .[]}`, d(`["x","y"]`), d(`[{"a":"x"},{"a":"y"}]`)},
+		{`[.[] | {a: range(2)}]`, d(`[1,2]`), d(`[{"a":0},{"a":1},{"a":0},{"a":1}]`)},
+		{`{a: (1, 2), b: (3, 4)}`, nil, d(`[{"a":1,"b":3},{"a":1,"b":4},{"a":2,"b":3},{"a":2,"b":4}]`)},
+		{`[{a: empty}]`, nil, d(`[]`)},
+		{`{a: .x}`, d(`{"x":1}`), d(`{"a":1}`)},
+	})
+
+	// constructed objects hold plain values, never internal streams
+	got, err := New(`[{a: .[]}]`, nil).Apply(context.Background(), d(`[1,2]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d(`[{"a":1},{"a":2}]`), got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
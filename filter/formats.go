@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"strings"
+
+	"github.com/qri-io/value"
+)
+
+// fFormat is a format string like @base64, converting its input to a
+// string in the named format
+type fFormat string
+
+// formats are the supported format names
+var formats = map[string]bool{
+	"base64":  true,
+	"base64d": true,
+	"csv":     true,
+	"tsv":     true,
+	"json":    true,
+	"text":    true,
+}
+
+// parseFormat creates a format filter, checking the name is supported
+func parseFormat(name string) (filter, error) {
+	if !formats[name] {
+		return nil, fmt.Errorf("%s is not a valid format", "@"+name)
+	}
+	return fFormat(name), nil
+}
+
+func (f fFormat) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	switch f {
+	case "base64":
+		str, err := formatText(ctx, e, in)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(str)), nil
+	case "base64d":
+		str, err := formatText(ctx, e, in)
+		if err != nil {
+			return nil, err
+		}
+		// padding is optional
+		data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(str, "="))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not valid base64 data", str)
+		}
+		return string(data), nil
+	case "json":
+		return jsonText(ctx, e, in)
+	case "text":
+		return formatText(ctx, e, in)
+	case "csv":
+		return formatRow(ctx, e, in, ',')
+	case "tsv":
+		return formatRow(ctx, e, in, '\t')
+	}
+	return nil, fmt.Errorf("%s is not a valid format", "@"+string(f))
+}
+
+// formatRow writes an array of scalars as a line of delimiter-separated
+// values. fields containing the delimiter, quotes or newlines are quoted.
+// numbers & booleans are written as text and nulls as empty fields
+func formatRow(ctx context.Context, e *env, in interface{}, delim rune) (string, error) {
+	if typeName(in) != "array" {
+		return "", fmt.Errorf("cannot format %s as a row, expected an array", typeName(in))
+	}
+
+	var row []string
+	err := each(ctx, e, in, func(v interface{}) error {
+		switch typeName(v) {
+		case "null":
+			row = append(row, "")
+		case "string", "number", "boolean":
+			s, _ := value.AsString(v)
+			row = append(row, s)
+		default:
+			return fmt.Errorf("%s is not valid in a row", typeName(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Comma = delim
+	if err = w.Write(row); err != nil {
+		return "", err
+	}
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n"), w.Error()
+}
+
+// formatText converts a format input to a string the way tostring does
+func formatText(ctx context.Context, e *env, in interface{}) (string, error) {
+	if str, err := stringInput("format", in); err == nil {
+		return str, nil
+	}
+	return jsonText(ctx, e, in)
+}
@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package filter
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		".", `"swoosh"`, ".apples", ".a.bar", ".[1]", ".[0:2]", ".bar[0:2]", ".bar * 5",
+		"(.bar | length) * 5", ".a | length", ".[:]", `.[] | "swoosh"`, ".[][]", "[.]",
+		"[ .foo, .bar ]", "{ foo: . }", "{ foo: .[0], bar: .[1:] }", `.[] | {"value": .}`,
+		"length", "map_values(. + 1)", "walk(length)", "sum", "median",
+		".a[5]", ".b[9]", ".b[1:]", ".a[5:1]", ".[", "{", "(", "[", "{a:", "map_values(",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	source := d(`{"a":[1,"two",{"three":3}],"b":"bee","c":null}`)
+	f.Fuzz(func(t *testing.T, str string) {
+		p := parser{s: newScanner(strings.NewReader(str))}
+		fs, err := p.filters()
+		if err != nil {
+			return
+		}
+		for _, filt := range fs {
+			if filt == nil {
+				t.Fatalf("parsing %q produced a nil filter", str)
+			}
+		}
+		New(str, nil).Apply(context.Background(), source)
+	})
+}
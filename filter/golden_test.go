@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// RunGolden runs filter test cases stored as files in dir. each case is a
+// triple of files sharing a name: a .filter file containing the filter string,
+// a .input file with JSON source data, and a .expected file with the JSON
+// value the filter should produce
+func RunGolden(t *testing.T, dir string) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.filter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no golden cases found in %s", dir)
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".filter")
+		t.Run(name, func(t *testing.T) {
+			base := strings.TrimSuffix(path, ".filter")
+			filterStr, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := readGoldenJSON(t, base+".input")
+			expect := readGoldenJSON(t, base+".expected")
+
+			filt := New(strings.TrimSpace(string(filterStr)), nil)
+			got, err := filt.Apply(context.Background(), source)
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			// round trip results through JSON so they compare with decoded
+			// expectations
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("encoding result: %s", err)
+			}
+			if err = json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("decoding result: %s", err)
+			}
+
+			if diff := cmp.Diff(expect, got); diff != "" {
+				t.Errorf("\n%s\nvalue mismatch (-want +got):\n%s", filterStr, diff)
+			}
+		})
+	}
+}
+
+func readGoldenJSON(t *testing.T, path string) (v interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("decoding %s: %s", path, err)
+	}
+	return v
+}
+
+func TestGolden(t *testing.T) {
+	RunGolden(t, "testdata/golden")
+}
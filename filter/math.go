@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// applyMath applies a numeric function to a number input. name is used in
+// errors for non-numeric inputs
+func applyMath(ctx context.Context, e *env, in interface{}, f filter, name string, fn func(float64) float64) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	n, ok := toFloat(in)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a number, got %s", name, typeName(in))
+	}
+	return fn(n), nil
+}
+
+// fAbs is the absolute value of a number
+type fAbs byte
+
+func (f fAbs) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyMath(ctx, e, in, f, "abs", math.Abs)
+}
+
+// fFloor rounds a number down to the nearest integer
+type fFloor byte
+
+func (f fFloor) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyMath(ctx, e, in, f, "floor", math.Floor)
+}
+
+// fCeil rounds a number up to the nearest integer
+type fCeil byte
+
+func (f fCeil) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyMath(ctx, e, in, f, "ceil", math.Ceil)
+}
+
+// fRound rounds a number to the nearest integer, rounding halves away from
+// zero
+type fRound byte
+
+func (f fRound) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyMath(ctx, e, in, f, "round", math.Round)
+}
+
+// fSqrt is the square root of a number. negative numbers produce NaN
+type fSqrt byte
+
+func (f fSqrt) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyMath(ctx, e, in, f, "sqrt", math.Sqrt)
+}
+
+// mathFuncs are single-argument math builtins, applied to their number input.
+// results follow Go's math package: log of zero is -Inf, log of a negative is
+// NaN & exp overflows to +Inf
+var mathFuncs = map[string]func(float64) float64{
+	"log":   math.Log,
+	"log10": math.Log10,
+	"log2":  math.Log2,
+	"exp":   math.Exp,
+	"exp10": func(x float64) float64 { return math.Pow(10, x) },
+	"exp2":  math.Exp2,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"asin":  math.Asin,
+	"acos":  math.Acos,
+	"atan":  math.Atan,
+}
+
+// fMath applies the math function named in mathFuncs to its input
+type fMath string
+
+func (f fMath) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	return applyMath(ctx, e, in, f, string(f), mathFuncs[string(f)])
+}
+
+// fPow raises one number to the power of another, both evaluated against
+// the input
+type fPow struct {
+	x, y filter
+}
+
+func (f fPow) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	var args [2]float64
+	for i, arg := range []filter{f.x, f.y} {
+		v, err := argValue(ctx, e, arg, in)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("pow expects numbers, got %s", typeName(v))
+		}
+		args[i] = n
+	}
+	return math.Pow(args[0], args[1]), nil
+}
@@ -0,0 +1,238 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/qri-io/value"
+)
+
+// fKeys lists the keys of an object or the indices of an array. keys are
+// sorted unless the filter is keys_unsorted, which keeps the iteration order
+// of value.Map inputs
+type fKeys struct {
+	unsorted bool
+}
+
+func (f fKeys) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []string
+	switch v := in.(type) {
+	case map[string]interface{}:
+		keys = sortedKeys(v)
+	case map[interface{}]interface{}:
+		for key := range v {
+			keys = append(keys, fmt.Sprintf("%v", key))
+		}
+		sort.Strings(keys)
+	case value.Map:
+		it := v.Iterate()
+		for it.Next() {
+			keys = append(keys, fmt.Sprintf("%v", it.Key()))
+		}
+		if err = it.Close(); err != nil {
+			return nil, err
+		}
+		if !f.unsorted {
+			sort.Strings(keys)
+		}
+	case []interface{}, value.Array, value.Iterator:
+		n := 0
+		if err = each(ctx, e, v, func(interface{}) error {
+			n++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		res := make([]interface{}, n)
+		for i := range res {
+			res[i] = i
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("%s has no keys", typeName(in))
+	}
+
+	res := make([]interface{}, len(keys))
+	for i, key := range keys {
+		res[i] = key
+	}
+	return res, nil
+}
+
+// fHas checks whether its input contains a key, or an array index
+type fHas struct {
+	key filter
+}
+
+func (f fHas) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	key, err := argValue(ctx, e, f.key, in)
+	if err != nil {
+		return nil, err
+	}
+	return hasKey(ctx, e, in, key)
+}
+
+// fIn is the reverse of has, checking whether its input is a key of an
+// object or an index of an array
+type fIn struct {
+	container filter
+}
+
+func (f fIn) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	container, err := argValue(ctx, e, f.container, in)
+	if err != nil {
+		return nil, err
+	}
+	return hasKey(ctx, e, container, in)
+}
+
+// argValue evaluates an argument filter, returning its first output
+func argValue(ctx context.Context, e *env, f filter, in interface{}) (interface{}, error) {
+	v, err := e.eval(ctx, f, in)
+	if err != nil {
+		return nil, err
+	}
+	v, _ = firstValue(v)
+	return v, nil
+}
+
+// hasKey reports whether an object has a string key, or an array has a
+// numeric index
+func hasKey(ctx context.Context, e *env, container, key interface{}) (bool, error) {
+	if link, ok := container.(value.Link); ok {
+		var err error
+		if container, err = resolveLink(ctx, e, link); err != nil {
+			return false, err
+		}
+	}
+
+	switch v := container.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		k, ok := key.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot check whether object has a key of type %s", typeName(key))
+		}
+		switch m := v.(type) {
+		case map[string]interface{}:
+			_, ok = m[k]
+		case map[interface{}]interface{}:
+			_, ok = m[k]
+		case value.Map:
+			_, err := m.ValueForKey(k)
+			ok = err == nil
+		}
+		return ok, nil
+	case []interface{}, value.Array, value.Iterator:
+		var i float64
+		switch k := key.(type) {
+		case int:
+			i = float64(k)
+		case float64:
+			i = k
+		case byte:
+			i = float64(k)
+		default:
+			return false, fmt.Errorf("cannot check whether array has a key of type %s", typeName(key))
+		}
+		n := 0
+		if err := each(ctx, e, v, func(interface{}) error {
+			n++
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		return i >= 0 && i < float64(n), nil
+	}
+	return false, fmt.Errorf("cannot check whether %s has a key", typeName(container))
+}
+
+// fToEntries converts an object to an array of {"key": k, "value": v}
+// objects, sorted by key
+type fToEntries byte
+
+func (f fToEntries) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := toObject(in)
+	if err != nil {
+		return nil, fmt.Errorf("to_entries: %s", err)
+	}
+	res := make([]interface{}, 0, len(obj))
+	for _, key := range sortedKeys(obj) {
+		res = append(res, map[string]interface{}{"key": key, "value": obj[key]})
+	}
+	return res, nil
+}
+
+// entry key & value field spellings accepted by from_entries, in order of
+// preference
+var (
+	entryKeyFields   = []string{"key", "k", "name", "Key", "K", "Name"}
+	entryValueFields = []string{"value", "v", "Value", "V"}
+)
+
+// fFromEntries builds an object from an array of {"key": k, "value": v}
+// objects. non-string keys are formatted as strings
+type fFromEntries byte
+
+func (f fFromEntries) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	res := map[string]interface{}{}
+	err = each(ctx, e, in, func(v interface{}) error {
+		entry, err := toObject(v)
+		if err != nil {
+			return fmt.Errorf("from_entries: %s", err)
+		}
+		k, ok := entryField(entry, entryKeyFields)
+		if !ok {
+			return fmt.Errorf("from_entries: entry has no key")
+		}
+		key, err := keyString(k)
+		if err != nil {
+			return err
+		}
+		res[key], _ = entryField(entry, entryValueFields)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// entryField returns the first field of an entry object that's present,
+// even if it's null or false. ok is false if none of the fields are present
+func entryField(entry map[string]interface{}, fields []string) (v interface{}, ok bool) {
+	for _, field := range fields {
+		if v, ok = entry[field]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/qri-io/value"
+)
+
+// ApplyStream executes a filter against a source, producing each output of
+// the filter as the returned iterator advances instead of collecting outputs
+// into a slice like Apply does. outputs are computed one at a time in a
+// separate goroutine as the iterator is read, so filters that select from
+// large iterators or generators like range hold only the current output in
+// memory. cancelling ctx stops iteration, callers must Close the iterator
+// when finished. an iterator that's dropped without being closed stops its
+// goroutine once it's garbage collected
+func (filt *Filter) ApplyStream(ctx context.Context, source interface{}) (value.Iterator, error) {
+	if filt.parseErr != nil {
+		return nil, filt.parseErr
+	}
+
+	var f filter = fIdentity(0)
+	if len(filt.filters) > 0 {
+		f = fPipe(filt.filters)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	fd := &feed{
+		vals: make(chan interface{}),
+		done: make(chan struct{}),
+	}
+	it := &outputIterator{
+		ctx:  ctx,
+		feed: fd,
+		i:    -1,
+	}
+	it.stop = func() {
+		fd.once.Do(func() {
+			close(fd.done)
+			cancel()
+		})
+	}
+	// the goroutine only holds the feed, so an abandoned iterator can be
+	// collected & stop it
+	runtime.SetFinalizer(it, func(it *outputIterator) { it.stop() })
+	go fd.run(runCtx, filt, filt.newEnv(), f, source)
+	return it, nil
+}
+
+// feed carries the outputs of a filter from the goroutine producing them to
+// an outputIterator
+type feed struct {
+	vals chan interface{}
+	// done is closed when the iterator stops reading
+	done chan struct{}
+	once sync.Once
+	// err is set before vals is closed
+	err error
+}
+
+// run produces outputs until the filter is finished, the iterator stops
+// reading, or the context is cancelled, closing vals when done
+func (fd *feed) run(ctx context.Context, filt *Filter, e *env, f filter, source interface{}) {
+	defer close(fd.vals)
+	fd.err = generate(ctx, e, f, source, func(v interface{}) (err error) {
+		if v, err = unpackValueStreams(ctx, v); err != nil {
+			return err
+		}
+		if filt.opts.AutoResolveLinks || filt.opts.CacheResolvedLinks {
+			if v, err = resolveAll(ctx, e, v, map[string]bool{}); err != nil {
+				return err
+			}
+		}
+		select {
+		case fd.vals <- v:
+			return nil
+		case <-fd.done:
+			return errStopGenerating
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// outputIterator iterates the outputs of a filter as they're produced
+type outputIterator struct {
+	ctx  context.Context
+	feed *feed
+	// stop signals the goroutine producing outputs to finish
+	stop func()
+	i    int
+	val  interface{}
+}
+
+// Next advances to the next output, returning false when the filter is
+// finished, fails, or the context is cancelled
+func (it *outputIterator) Next() bool {
+	if it.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case v, ok := <-it.feed.vals:
+		if !ok {
+			return false
+		}
+		it.val = v
+		it.i++
+		return true
+	case <-it.ctx.Done():
+		return false
+	}
+}
+
+// Scan reads the current output into dest
+func (it *outputIterator) Scan(dest value.Value) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("expected pointer value for scan")
+	}
+	if it.val == nil {
+		dv.Elem().Set(reflect.Zero(dv.Elem().Type()))
+	} else {
+		dv.Elem().Set(reflect.ValueOf(it.val))
+	}
+	return nil
+}
+
+// Key returns the index of the current output
+func (it *outputIterator) Key() interface{} { return it.i }
+
+// Close stops producing outputs, returning any error the filter encountered
+// or the context's error if it was cancelled
+func (it *outputIterator) Close() error {
+	it.stop()
+	for range it.feed.vals {
+	}
+	if err := it.ctx.Err(); err != nil {
+		return err
+	}
+	if it.feed.err == context.Canceled {
+		// cancelled by closing early
+		return nil
+	}
+	return it.feed.err
+}
+
+// IsOrdered returns true, outputs are produced in a deterministic order
+func (it *outputIterator) IsOrdered() bool { return true }
@@ -2,8 +2,9 @@ package filter
 
 import (
 	"fmt"
-	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 // parser is a state machine for serializing a documentation struct from a byte stream
@@ -40,73 +41,138 @@ func (p *parser) unscan() {
 	p.buf.n = 1
 }
 
+// filters reads a top-level pipeline, returning each stage of the pipeline
 func (p *parser) filters() (fs []filter, err error) {
+	if t := p.scan(); t.Type == tEOF {
+		return nil, nil
+	}
+	p.unscan()
+
 	for {
 		f, err := p.readFilter()
-		// fmt.Println("read filter:", f, err)
-		if f != nil {
-			fs = append(fs, f)
-		}
 		if err != nil {
-			if err.Error() == "EOF" {
-				return fs, nil
-			}
 			return nil, err
 		}
+		fs = append(fs, f)
+
+		t := p.scan()
+		switch t.Type {
+		case tPipe:
+			continue
+		case tEOF:
+			return fs, nil
+		default:
+			return nil, p.errorf("unexpected token: %s", t.Type)
+		}
+	}
+}
+
+// readPipe reads a pipeline of filters, stopping at the first token that
+// can't continue the pipeline
+func (p *parser) readPipe() (f filter, err error) {
+	var fs fPipe
+	for {
+		if f, err = p.readFilter(); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+
+		if t := p.scan(); t.Type != tPipe {
+			p.unscan()
+			break
+		}
+	}
+
+	if len(fs) == 1 {
+		return fs[0], nil
 	}
+	return fs, nil
 }
 
+// readFilter reads a comma-separated list of expressions, which produces the
+// outputs of each expression in turn
 func (p *parser) readFilter() (f filter, err error) {
-	var fs fSlice
+	var fs fComma
+	for {
+		if f, err = p.readBinaryExpr(0); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+
+		if t := p.scan(); t.Type != tComma {
+			p.unscan()
+			break
+		}
+	}
+
+	if len(fs) == 1 {
+		return fs[0], nil
+	}
+	return fs, nil
+}
+
+// precedence ranks binary operators, higher values bind more tightly
+var precedence = map[tokenType]int{
+	tEqual:        3,
+	tNotEqual:     3,
+	tLess:         3,
+	tLessEqual:    3,
+	tGreater:      3,
+	tGreaterEqual: 3,
+	tPlus:         4,
+	tMinus:        4,
+	tStar:         5,
+	tForwardSlash: 5,
+}
+
+// keywordPrecedence ranks binary operators that are written as words
+var keywordPrecedence = map[string]int{
+	"or":  1,
+	"and": 2,
+}
+
+// readBinaryExpr reads a chain of binary operations, binding operators at or
+// above the given precedence level
+func (p *parser) readBinaryExpr(minPrec int) (f filter, err error) {
+	if f, err = p.readOneFilter(); err != nil {
+		return nil, err
+	}
+	for t := p.scan(); t.Type == tQuestion; t = p.scan() {
+		f = fTry{f: f}
+	}
+	p.unscan()
+	if t := p.scan(); t.Type == tText && t.Text == "as" {
+		return p.parseBinding(f)
+	}
+	p.unscan()
 
 	for {
 		t := p.scan()
-
-		switch t.Type {
-		case tDot:
+		prec, ok := precedence[t.Type]
+		if t.Type == tText {
+			prec, ok = keywordPrecedence[t.Text]
+		}
+		if !ok || prec < minPrec {
 			p.unscan()
-			if f, err = p.readSelector(); err != nil {
-				return
-			}
-		case tNumber:
-			num, err := strconv.ParseFloat(t.Text, 64)
-			if err != nil {
-				return nil, err
-			}
-			f = fNumericLiteral(num)
-		case tStar, tPlus, tMinus:
-			if f, err = p.parseBinaryOp(f, t); err != nil {
-				return f, err
-			}
-		case tLeftBracket:
-			if f, err = p.parseSliceFilter(); err != nil {
-				return nil, err
-			}
-		case tLeftBrace:
-			return p.parseObjectMap()
-		case tText:
-			if f, err = p.parseTextFilter(t); err != nil {
-				return nil, err
-			}
-		case tComma:
-			fs = append(fs, f)
-			return fs, nil
-		case tPipe:
-			if len(fs) > 0 {
-				return append(fs, f), nil
-			}
-			// nil returns won't be added
-			// TODO (b5) - I don't think it's legal to pipe without a preceding filter
 			return f, nil
-		case tEOF:
-			if len(fs) > 0 {
-				return append(fs, f), io.EOF
-			}
-			return f, io.EOF
+		}
+
+		right, err := p.readBinaryExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t.Type == tText && t.Text == "and":
+			f = fAnd{left: f, right: right}
+		case t.Type == tText && t.Text == "or":
+			f = fOr{left: f, right: right}
+		default:
+			f = fBinaryOp{left: f, op: t.Type, right: right}
 		}
 	}
 }
 
+// readOneFilter reads a single term of an expression
 func (p *parser) readOneFilter() (f filter, err error) {
 	t := p.scan()
 
@@ -114,85 +180,725 @@ func (p *parser) readOneFilter() (f filter, err error) {
 	case tDot:
 		p.unscan()
 		return p.readSelector()
+	case tRecurse:
+		return fRecurseDescent(0), nil
 	case tNumber:
-		num, err := strconv.ParseFloat(t.Text, 64)
-		if err != nil {
+		return parseNumericLiteral(t.Text)
+	case tMinus:
+		if t = p.scan(); t.Type != tNumber {
+			return nil, p.errorf("unexpected token: %s", t.Type)
+		}
+		return parseNumericLiteral("-" + t.Text)
+	case tLeftParen:
+		if f, err = p.parseParens(); err != nil {
 			return nil, err
 		}
-		return fNumericLiteral(num), nil
-	case tStar, tPlus, tMinus:
-		return p.parseBinaryOp(f, t)
+		return p.readPostfix(f)
 	case tLeftBracket:
-		return p.parseSliceFilter()
+		return p.completeArrayMap(fSlice{})
 	case tLeftBrace:
 		return p.parseObjectMap()
+	case tVariable:
+		if t.Text == "" {
+			return nil, p.errorf("expected a variable name after $")
+		}
+		return p.readPostfix(fVariable(t.Text))
+	case tFormat:
+		return parseFormat(t.Text)
+	case tString:
+		return fStringLiteral(t.Text), nil
+	case tInterpolation:
+		return parseInterpolation(t.Parts)
 	case tText:
 		return p.parseTextFilter(t)
 	default:
 		p.unscan()
-		return nil, fmt.Errorf("unexpected token: %s", t.Type.String())
+		return nil, p.errorf("unexpected token: %s", t.Type.String())
 	}
 }
 
-func (p *parser) parseBinaryOp(left filter, t token) (f fBinaryOp, err error) {
-	f = fBinaryOp{left: left, op: t.Type}
-	f.right, err = p.readFilter()
-	return f, err
+// parseInterpolation creates a filter from the parts of an interpolated
+// string, parsing the source of each embedded expression
+func parseInterpolation(parts []string) (f filter, err error) {
+	interp := make(fStringInterp, len(parts))
+	for i, part := range parts {
+		if i%2 == 0 {
+			interp[i] = fStringLiteral(part)
+			continue
+		}
+		sub := &parser{s: newScanner(strings.NewReader(part))}
+		if interp[i], err = sub.readPipe(); err != nil {
+			return nil, err
+		}
+		if t := sub.scan(); t.Type != tEOF {
+			return nil, fmt.Errorf("unexpected token in string interpolation: %s", t.Type)
+		}
+	}
+	return interp, nil
+}
+
+func parseNumericLiteral(text string) (f filter, err error) {
+	num, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, err
+	}
+	return fNumericLiteral(num), nil
+}
+
+// parseParens reads a parenthesized pipeline, the opening paren must already
+// be consumed
+func (p *parser) parseParens() (f filter, err error) {
+	if f, err = p.readPipe(); err != nil {
+		return nil, err
+	}
+	if t := p.scan(); t.Type != tRightParen {
+		return nil, p.errorf("expected ')', got: %s", t.Type)
+	}
+	return f, nil
+}
+
+// readPostfix reads any selectors directly following a term, like $x.a or
+// (.a)[0], piping the term into them
+func (p *parser) readPostfix(term filter) (f filter, err error) {
+	t := p.scan()
+	p.unscan()
+	if t.Spaced || (t.Type != tDot && t.Type != tLeftBracket) {
+		return term, nil
+	}
+	sel, err := p.readSelector()
+	if err != nil {
+		return nil, err
+	}
+	return fPipe{term, sel}, nil
 }
 
 func (p *parser) readSelector() (f filter, err error) {
 	var sel fSelector
+	afterDot := false
 	for {
 		t := p.scan()
 		switch t.Type {
 		case tDot:
 			sel = append(sel, fIdentity('.'))
-		case tText:
+			afterDot = true
+			continue
+		case tText, tString:
+			if !afterDot || t.Spaced {
+				p.unscan()
+				return sel, nil
+			}
 			sel = append(sel, fKeySelector(t.Text))
+		case tStar:
+			if !afterDot || t.Spaced {
+				p.unscan()
+				return sel, nil
+			}
+			sel = append(sel, fGlobSelector(0))
 		case tLeftBracket:
 			sf, err := p.parseSliceFilter()
 			if err != nil {
 				return nil, err
 			}
 			sel = append(sel, sf)
+		case tQuestion:
+			if len(sel) == 0 {
+				p.unscan()
+				return sel, nil
+			}
+			// ? suppresses errors from the selector it follows. optional keys
+			// also skip inputs that aren't objects, and .[]? skips inputs that
+			// aren't arrays or objects
+			switch last := sel[len(sel)-1].(type) {
+			case fKeySelector:
+				sel[len(sel)-1] = fOptionalKeySelector(last)
+			case fIterateAllSeletor:
+				sel[len(sel)-1] = fTry{f: fIterateAllSeletor(true)}
+			default:
+				sel[len(sel)-1] = fTry{f: sel[len(sel)-1]}
+			}
 		// case tComma:
 		// return p.completeArrayMap(fSlice{sel})
 		default:
 			p.unscan()
 			return sel, nil
 		}
+		afterDot = false
 	}
 }
 
 func (p *parser) parseTextFilter(t token) (f filter, err error) {
 	switch t.Text {
+	case "true", "false":
+		return fBoolLiteral(t.Text == "true"), nil
+	case "null":
+		return fNullLiteral(0), nil
+	case "and", "or", "then", "elif", "else", "end", "as":
+		return nil, p.errorf("unexpected keyword: %s", t.Text)
+	case "if":
+		return p.parseConditional()
+	case "not":
+		return fNot(0), nil
+	case "split":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fSplit{sep: args[0]}, nil
+	case "join":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fJoin{sep: args[0]}, nil
+	case "startswith", "endswith", "ltrimstr", "rtrimstr":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		switch t.Text {
+		case "startswith":
+			return fStartsWith{prefix: args[0]}, nil
+		case "endswith":
+			return fEndsWith{suffix: args[0]}, nil
+		case "ltrimstr":
+			return fLtrimstr{prefix: args[0]}, nil
+		}
+		return fRtrimstr{suffix: args[0]}, nil
+	case "ascii_downcase":
+		return fAsciiDowncase(0), nil
+	case "ascii_upcase":
+		return fAsciiUpcase(0), nil
+	case "test", "match", "capture":
+		args, err := p.parseArgList(t.Text)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 2 {
+			return nil, p.errorf("%s expects 1 or 2 arguments, got %d", t.Text, len(args))
+		}
+		switch t.Text {
+		case "match":
+			return fMatch{args: args}, nil
+		case "capture":
+			return fCapture{args: args}, nil
+		}
+		return fRegexTest{args: args}, nil
+	case "sub", "gsub":
+		args, err := p.parseArgList(t.Text)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 2 || len(args) > 3 {
+			return nil, p.errorf("%s expects 2 or 3 arguments, got %d", t.Text, len(args))
+		}
+		return fSub{args: args, global: t.Text == "gsub"}, nil
+	case "explode":
+		return fExplode(0), nil
+	case "implode":
+		return fImplode(0), nil
+	case "tostring":
+		return fToString(0), nil
+	case "tonumber":
+		return fToNumber(0), nil
+	case "empty":
+		return fEmpty(0), nil
+	case "error":
+		args, err := p.parseOptionalArgs()
+		if err != nil {
+			return nil, err
+		}
+		return fError{args: args}, nil
+	case "type":
+		return fType(0), nil
+	case "values":
+		return fValues(0), nil
 	case "length":
 		return fLength(0), nil
+	case "sum":
+		return fSum(0), nil
+	case "add":
+		return fAdd(0), nil
+	case "avg", "mean":
+		return fAvg(0), nil
+	case "count":
+		return fCount(0), nil
+	case "abs":
+		return fAbs(0), nil
+	case "floor":
+		return fFloor(0), nil
+	case "ceil":
+		return fCeil(0), nil
+	case "round":
+		return fRound(0), nil
+	case "sqrt":
+		return fSqrt(0), nil
+	case "fromjson":
+		return fFromJSON(0), nil
+	case "median":
+		return fMedian(0), nil
+	case "stddev":
+		return fStddev(0), nil
+	case "map_values":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fMapValues{f: args[0]}, nil
+	case "range":
+		args, err := p.parseArgList(t.Text)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 3 {
+			return nil, p.errorf("range expects 1 to 3 arguments, got %d", len(args))
+		}
+		return fRange{args: args}, nil
+	case "flatten":
+		args, err := p.parseOptionalArgs()
+		if err != nil {
+			return nil, err
+		}
+		return fFlatten{args: args}, nil
+	case "paths", "leaf_paths":
+		return fPaths{leaves: t.Text == "leaf_paths"}, nil
+	case "getpath":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fGetPath{path: args[0]}, nil
+	case "setpath":
+		args, err := p.parseArgs(t.Text, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fSetPath{path: args[0], val: args[1]}, nil
+	case "del":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fDel{path: args[0]}, nil
+	case "delpaths":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fDelPaths{paths: args[0]}, nil
+	case "flatten_object":
+		args, err := p.parseOptionalArgs()
+		if err != nil {
+			return nil, err
+		}
+		return fFlattenObject{args: args}, nil
+	case "unflatten":
+		args, err := p.parseOptionalArgs()
+		if err != nil {
+			return nil, err
+		}
+		return fUnflatten{args: args}, nil
+	case "zip":
+		args, err := p.parseOptionalArgs()
+		if err != nil {
+			return nil, err
+		}
+		return fZip{args: args}, nil
+	case "first", "last":
+		args, err := p.parseOptionalArgs()
+		if err != nil {
+			return nil, err
+		}
+		if t.Text == "first" {
+			return fFirst{args: args}, nil
+		}
+		return fLast{args: args}, nil
+	case "nth":
+		args, err := p.parseArgList(t.Text)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 1 || len(args) > 2 {
+			return nil, p.errorf("nth expects 1 or 2 arguments, got %d", len(args))
+		}
+		return fNth{args: args}, nil
+	case "limit":
+		args, err := p.parseArgs(t.Text, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fLimit{n: args[0], f: args[1]}, nil
+	case "any", "all":
+		args, err := p.parseOptionalArgList(t.Text)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 2 {
+			return nil, p.errorf("%s expects at most 2 arguments, got %d", t.Text, len(args))
+		}
+		if t.Text == "any" {
+			return fAny{args: args}, nil
+		}
+		return fAll{args: args}, nil
+	case "enumerate":
+		return fEnumerate(0), nil
+	case "reverse":
+		return fReverse(0), nil
+	case "sort":
+		return fSort(0), nil
+	case "sort_by":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fSortBy{key: args[0]}, nil
+	case "unique":
+		return fUnique(0), nil
+	case "unique_by":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fUniqueBy{key: args[0]}, nil
+	case "min":
+		return fMin(0), nil
+	case "max":
+		return fMax(0), nil
+	case "min_by":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fMinBy{key: args[0]}, nil
+	case "max_by":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fMaxBy{key: args[0]}, nil
+	case "keys":
+		return fKeys{}, nil
+	case "keys_unsorted":
+		return fKeys{unsorted: true}, nil
+	case "to_entries":
+		return fToEntries(0), nil
+	case "from_entries":
+		return fFromEntries(0), nil
+	case "select":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fSelect{f: args[0]}, nil
+	case "contains", "inside":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fContains{arg: args[0], inside: t.Text == "inside"}, nil
+	case "has":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fHas{key: args[0]}, nil
+	case "in":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fIn{container: args[0]}, nil
+	case "count_by":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fCountBy{key: args[0]}, nil
+	case "index_by":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fIndexBy{key: args[0]}, nil
+	case "partition":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fPartition{pred: args[0]}, nil
+	case "take_while":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fTakeWhile{pred: args[0]}, nil
+	case "drop_while":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fDropWhile{pred: args[0]}, nil
+	case "reduce":
+		return p.parseReduce()
+	case "foreach":
+		return p.parseForeach()
+	case "walk":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fWalk{f: args[0]}, nil
+	case "matches_schema", "validate":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fMatchesSchema{schema: args[0], errors: t.Text == "validate"}, nil
+	case "find_all":
+		args, err := p.parseArgs(t.Text, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fFindAll{key: args[0]}, nil
+	case "pow":
+		args, err := p.parseArgs(t.Text, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fPow{x: args[0], y: args[1]}, nil
 	default:
+		if _, ok := mathFuncs[t.Text]; ok {
+			return fMath(t.Text), nil
+		}
 		return fStringLiteral(t.Text), nil
 	}
 }
 
+// parseOptionalArgs reads a parenthesized argument list if one follows a
+// builtin name
+func (p *parser) parseOptionalArgs() (args []filter, err error) {
+	if t := p.scan(); t.Type != tLeftParen {
+		p.unscan()
+		return nil, nil
+	}
+
+	f, err := p.readPipe()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.scan(); t.Type != tRightParen {
+		return nil, p.errorf("unexpected token in arguments: %s", t.Type)
+	}
+	return []filter{f}, nil
+}
+
+// parseOptionalArgList reads a parenthesized, semicolon-separated list of
+// arguments if one follows
+func (p *parser) parseOptionalArgList(name string) (args []filter, err error) {
+	t := p.scan()
+	p.unscan()
+	if t.Type != tLeftParen {
+		return nil, nil
+	}
+	return p.parseArgList(name)
+}
+
+// parseArgs reads a parenthesized list of n arguments to a builtin
+func (p *parser) parseArgs(name string, n int) (args []filter, err error) {
+	if args, err = p.parseArgList(name); err != nil {
+		return nil, err
+	}
+	if len(args) != n {
+		return nil, p.errorf("%s expects %d argument(s), got %d", name, n, len(args))
+	}
+	return args, nil
+}
+
+// parseArgList reads a parenthesized, semicolon-separated list of arguments
+func (p *parser) parseArgList(name string) (args []filter, err error) {
+	if t := p.scan(); t.Type != tLeftParen {
+		return nil, p.errorf("%s expects arguments", name)
+	}
+
+	for {
+		f, err := p.readPipe()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, f)
+
+		switch t := p.scan(); t.Type {
+		case tRightParen:
+			return args, nil
+		case tSemicolon:
+			continue
+		default:
+			return nil, p.errorf("unexpected token in arguments to %s: %s", name, t.Type)
+		}
+	}
+}
+
+// parseConditional reads the rest of a conditional after the "if" or "elif"
+// keyword: COND then EXPR (elif COND then EXPR)* (else EXPR)? end
+func (p *parser) parseConditional() (f filter, err error) {
+	c := fConditional{}
+	if c.cond, err = p.readPipe(); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("then"); err != nil {
+		return nil, err
+	}
+	if c.then, err = p.readPipe(); err != nil {
+		return nil, err
+	}
+
+	t := p.scan()
+	if t.Type != tText {
+		return nil, p.errorf("expected elif, else, or end in conditional, got %s", t.Type)
+	}
+	switch t.Text {
+	case "elif":
+		// elif chains nest as the else branch, sharing a single end
+		if c.els, err = p.parseConditional(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case "else":
+		if c.els, err = p.readPipe(); err != nil {
+			return nil, err
+		}
+		if err = p.expectKeyword("end"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case "end":
+		return c, nil
+	}
+	return nil, p.errorf("expected elif, else, or end in conditional, got %q", t.Text)
+}
+
+// expectKeyword reads a keyword token, erroring if the next token is anything
+// else
+func (p *parser) expectKeyword(kw string) error {
+	if t := p.scan(); t.Type != tText || t.Text != kw {
+		return p.errorf("expected %s, got %s", kw, t.Type)
+	}
+	return nil
+}
+
+// parseBinding reads the rest of a variable binding after the "as" keyword:
+// SOURCE as $name | BODY. the body extends to the end of the pipeline
+func (p *parser) parseBinding(source filter) (f filter, err error) {
+	t := p.scan()
+	if t.Type != tVariable || t.Text == "" {
+		return nil, p.errorf("expected a variable name after as, got %s", t.Type)
+	}
+	name := t.Text
+	if t := p.scan(); t.Type != tPipe {
+		return nil, p.errorf("expected | after variable binding, got %s", t.Type)
+	}
+	body, err := p.readPipe()
+	if err != nil {
+		return nil, err
+	}
+	return fBind{source: source, name: name, body: body}, nil
+}
+
+// parseForeach reads the rest of a foreach expression after the keyword:
+// foreach SOURCE as $name (INIT; UPDATE; EXTRACT), where EXTRACT is optional
+func (p *parser) parseForeach() (f filter, err error) {
+	source, name, args, err := p.parseFold("foreach")
+	if err != nil {
+		return nil, err
+	}
+	fe := fForeach{source: source, name: name}
+	switch len(args) {
+	case 3:
+		fe.extract = args[2]
+		fallthrough
+	case 2:
+		fe.init, fe.update = args[0], args[1]
+	default:
+		return nil, p.errorf("foreach expects 2 or 3 arguments, got %d", len(args))
+	}
+	return fe, nil
+}
+
+// parseReduce reads the rest of a reduce expression after the keyword:
+// reduce SOURCE as $name (INIT; UPDATE)
+func (p *parser) parseReduce() (f filter, err error) {
+	source, name, args, err := p.parseFold("reduce")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 2 {
+		return nil, p.errorf("reduce expects 2 arguments, got %d", len(args))
+	}
+	return fReduce{source: source, name: name, init: args[0], update: args[1]}, nil
+}
+
+// parseFold reads the shared SOURCE as $name (ARGS) form of reduce & foreach
+func (p *parser) parseFold(kw string) (source filter, name string, args []filter, err error) {
+	if source, err = p.readOneFilter(); err != nil {
+		return nil, "", nil, err
+	}
+	if err = p.expectKeyword("as"); err != nil {
+		return nil, "", nil, err
+	}
+	t := p.scan()
+	if t.Type != tVariable || t.Text == "" {
+		return nil, "", nil, p.errorf("%s expects a variable name, got %s", kw, t.Type)
+	}
+	if args, err = p.parseArgList(kw); err != nil {
+		return nil, "", nil, err
+	}
+	return source, t.Text, args, nil
+}
+
 func (p *parser) parseSliceFilter() (f selector, err error) {
 	r := &fIndexRangeSelector{}
-	hasColon := false
+	hasColon, hasStop := false, false
 	empty := true
 
 	for {
 		t := p.scan()
 		switch t.Type {
-		case tNumber:
-			// TODO (b5) - this needs to handle floating point numbers
-			num, err := strconv.ParseInt(t.Text, 10, 64)
+		case tNumber, tMinus:
+			text := t.Text
+			if t.Type == tMinus {
+				// negative positions count from the end
+				if t = p.scan(); t.Type != tNumber {
+					return nil, p.errorf("unexpected token: %s", t.Type)
+				}
+				text = "-" + t.Text
+			}
+			num, err := strconv.ParseFloat(text, 64)
 			if err != nil {
-				return nil, err
+				return nil, p.errorf("invalid index: %s", text)
+			}
+			if num != math.Trunc(num) {
+				return nil, p.errorf("index must be a whole number, got %s", text)
 			}
 			if !hasColon {
 				r.start = int(num)
 			} else {
 				r.stop = int(num)
+				hasStop = true
 			}
 			empty = false
+		case tString:
+			if !empty {
+				return nil, p.errorf("unexpected string: %q", t.Text)
+			}
+			// a lone string selects a key, keys that can't be written
+			// bare like .["a b"] need brackets
+			switch next := p.scan(); next.Type {
+			case tRightBracket:
+				return fKeySelector(t.Text), nil
+			case tComma:
+				return p.completeArrayMap(fSlice{fStringLiteral(t.Text)})
+			default:
+				return nil, p.errorf("unexpected token: %s", next.Type)
+			}
 		case tColon:
 			empty = false
 			hasColon = true
@@ -205,6 +911,7 @@ func (p *parser) parseSliceFilter() (f selector, err error) {
 			if empty {
 				return fIterateAllSeletor(false), nil
 			}
+			r.open = !hasStop
 			return r, nil
 		default:
 			if hasColon {
@@ -222,27 +929,31 @@ func (p *parser) parseSliceFilter() (f selector, err error) {
 }
 
 func (p *parser) completeArrayMap(am fSlice) (f selector, err error) {
-	var cursor filter
+	if t := p.scan(); t.Type == tRightBracket {
+		return am, nil
+	}
+	p.unscan()
+
 	for {
-		if cursor != nil {
-			am = append(am, cursor)
-			cursor = nil
+		el, err := p.readPipe()
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := el.(fComma); ok {
+			am = append(am, c...)
+		} else {
+			am = append(am, el)
 		}
 
 		t := p.scan()
 		switch t.Type {
 		case tRightBracket:
 			return am, nil
-		case tComma:
-			continue
 		case tEOF:
 			p.unscan()
 			return am, nil
 		default:
-			p.unscan()
-			if cursor, err = p.readOneFilter(); err != nil {
-				return nil, err
-			}
+			return nil, p.errorf("unexpected token: %s", t.Type)
 		}
 	}
 }
@@ -254,27 +965,33 @@ func (p *parser) parseObjectMap() (f filter, err error) {
 	for {
 		t := p.scan()
 		switch t.Type {
-		case tText:
+		case tText, tString:
 			if key != "" {
-				return nil, fmt.Errorf("unexpected string: %s", t.Text)
+				return nil, p.errorf("unexpected string: %s", t.Text)
 			}
 			key = t.Text
 		case tColon:
-			f, err = p.readOneFilter()
+			f, err = p.readBinaryExpr(0)
 			if err != nil {
 				return nil, err
 			}
 			objf[key] = f
 		case tComma:
+			if _, ok := objf[key]; !ok && key != "" {
+				objf[key] = fSelector{fIdentity('.'), fKeySelector(key)}
+			}
 			key = ""
 		case tRightBrace:
+			if _, ok := objf[key]; !ok && key != "" {
+				objf[key] = fSelector{fIdentity('.'), fKeySelector(key)}
+			}
 			return objf, nil
 		default:
-			return nil, fmt.Errorf("unexpected token: %s %#v", t.Type, t)
+			return nil, p.errorf("unexpected token: %s %#v", t.Type, t)
 		}
 	}
 }
 
 func (p *parser) errorf(format string, args ...interface{}) error {
-	return fmt.Errorf(format, args...)
+	return fmt.Errorf("%s: %s", p.buf.tok.Pos, fmt.Sprintf(format, args...))
 }
@@ -0,0 +1,794 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qri-io/value"
+)
+
+// eachLeaf calls fn with the path to & value of every leaf in the input,
+// depth-first in sorted key order. leaves are scalars & empty arrays or
+// objects. path elements are string keys & int indexes. links are resolved
+// before descending
+func eachLeaf(ctx context.Context, e *env, path []interface{}, in interface{}, fn func(path []interface{}, v interface{}) error) (err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return err
+		}
+	}
+
+	switch v := in.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return fn(path, v)
+		}
+		for _, key := range sortedKeys(v) {
+			if err = eachLeaf(ctx, e, appendPath(path, key), v[key], fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if len(v) == 0 {
+			return fn(path, v)
+		}
+		for i, x := range v {
+			if err = eachLeaf(ctx, e, appendPath(path, i), x, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[interface{}]interface{}, value.Map:
+		m, err := toObject(in)
+		if err != nil {
+			return err
+		}
+		return eachLeaf(ctx, e, path, m, fn)
+	case value.Array, value.Iterator:
+		vals := []interface{}{}
+		if err = each(ctx, e, in, func(x interface{}) error {
+			vals = append(vals, x)
+			return nil
+		}); err != nil {
+			return err
+		}
+		return eachLeaf(ctx, e, path, vals, fn)
+	}
+
+	return fn(path, in)
+}
+
+// eachPath calls fn with the path to & value of every value nested within the
+// input, depth-first in sorted key order. the input itself is not visited.
+// links are resolved before they're handed to fn
+func eachPath(ctx context.Context, e *env, path []interface{}, in interface{}, fn func(path []interface{}, v interface{}) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return err
+		}
+	}
+	if len(path) > 0 {
+		if err = fn(path, in); err != nil {
+			return err
+		}
+	}
+
+	switch v := in.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		obj, err := toObject(v)
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(obj) {
+			if err = eachPath(ctx, e, appendPath(path, key), obj[key], fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}, value.Array, value.Iterator:
+		vals, err := collect(ctx, e, v)
+		if err != nil {
+			return err
+		}
+		for i, x := range vals {
+			if err = eachPath(ctx, e, appendPath(path, i), x, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendPath copies path with el added to the end, so paths handed to
+// callbacks are never shared
+func appendPath(path []interface{}, el interface{}) []interface{} {
+	p := make([]interface{}, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, el)
+}
+
+// toObject converts map-like values to a map[string]interface{}, formatting
+// non-string keys as strings
+func toObject(in interface{}) (map[string]interface{}, error) {
+	switch v := in.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[fmt.Sprintf("%v", key)] = x
+		}
+		return m, nil
+	case value.Map:
+		m := map[string]interface{}{}
+		it := v.Iterate()
+		for it.Next() {
+			var x interface{}
+			if err := it.Scan(&x); err != nil {
+				it.Close()
+				return nil, err
+			}
+			m[fmt.Sprintf("%v", it.Key())] = x
+		}
+		return m, it.Close()
+	}
+	return nil, fmt.Errorf("expected object, got %s", typeName(in))
+}
+
+// separator evaluates an optional separator argument, defaulting to "."
+func separator(ctx context.Context, e *env, args []filter, in interface{}) (string, error) {
+	if len(args) == 0 {
+		return ".", nil
+	}
+	v, err := e.eval(ctx, args[0], in)
+	if err != nil {
+		return "", err
+	}
+	sep, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("separator must be a string, got %s", typeName(v))
+	}
+	return sep, nil
+}
+
+// fFlattenObject converts a nested object into a single-level object, joining
+// the path to each leaf value with a separator into a key. array indexes
+// become keys like "a.0"
+type fFlattenObject struct {
+	args []filter
+}
+
+func (f fFlattenObject) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	sep, err := separator(ctx, e, f.args, in)
+	if err != nil {
+		return nil, err
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := toObject(in)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]interface{}{}
+	err = eachLeaf(ctx, e, nil, obj, func(path []interface{}, v interface{}) error {
+		if len(path) == 0 {
+			return nil
+		}
+		strs := make([]string, len(path))
+		for i, el := range path {
+			strs[i] = fmt.Sprintf("%v", el)
+		}
+		res[strings.Join(strs, sep)] = v
+		return nil
+	})
+	return res, err
+}
+
+// fUnflatten is the inverse of flatten_object, splitting keys on a separator
+// to build a nested object. objects where every key is an index from zero
+// through the number of keys become arrays
+type fUnflatten struct {
+	args []filter
+}
+
+func (f fUnflatten) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	sep, err := separator(ctx, e, f.args, in)
+	if err != nil {
+		return nil, err
+	}
+	flat, err := toObject(in)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]interface{}{}
+	for _, key := range sortedKeys(flat) {
+		parts := strings.Split(key, sep)
+		m := res
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := m[part].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				m[part] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = flat[key]
+	}
+	// the top level stays an object, even if all keys are indexes
+	for key, v := range res {
+		res[key] = arraysFromIndexes(v)
+	}
+	return res, nil
+}
+
+// arraysFromIndexes converts objects built by unflatten with keys "0"
+// through "n-1" into arrays
+func arraysFromIndexes(in interface{}) interface{} {
+	m, ok := in.(map[string]interface{})
+	if !ok {
+		return in
+	}
+	for key, v := range m {
+		m[key] = arraysFromIndexes(v)
+	}
+	if len(m) == 0 {
+		return m
+	}
+
+	indexes := make([]int, 0, len(m))
+	for key := range m {
+		i, err := strconv.Atoi(key)
+		if err != nil || strconv.Itoa(i) != key {
+			return m
+		}
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for i, idx := range indexes {
+		if i != idx {
+			return m
+		}
+	}
+
+	arr := make([]interface{}, len(m))
+	for i := range arr {
+		arr[i] = m[strconv.Itoa(i)]
+	}
+	return arr
+}
+
+// descend calls fn with the input and every value nested within it,
+// depth-first in sorted key order. links are resolved before descending, and
+// links that are already being descended into are skipped to protect against
+// cycles. visited holds the paths of links being descended into
+func descend(ctx context.Context, e *env, in interface{}, visited map[string]bool, fn func(v interface{}) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if link, ok := in.(value.Link); ok {
+		if visited[link.Path()] {
+			return nil
+		}
+		visited[link.Path()] = true
+		defer delete(visited, link.Path())
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return err
+		}
+	}
+
+	switch v := in.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, value.Map:
+		obj, err := toObject(v)
+		if err != nil {
+			return err
+		}
+		if err = fn(obj); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(obj) {
+			if err = descend(ctx, e, obj[key], visited, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}, value.Array, value.Iterator:
+		vals, err := collect(ctx, e, v)
+		if err != nil {
+			return err
+		}
+		if err = fn(vals); err != nil {
+			return err
+		}
+		for _, x := range vals {
+			if err = descend(ctx, e, x, visited, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fn(in)
+}
+
+// fRecurseDescent is "..", emitting the input and every value nested within
+// it, depth-first
+type fRecurseDescent byte
+
+func (f fRecurseDescent) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	vals := []interface{}{}
+	if err = descend(ctx, e, in, map[string]bool{}, func(v interface{}) error {
+		vals = append(vals, v)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return newStream(vals)
+}
+
+// fFindAll selects every value stored under a key anywhere in the input
+type fFindAll struct {
+	key filter
+}
+
+func (f fFindAll) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	k, err := e.eval(ctx, f.key, in)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(string)
+	if !ok {
+		return nil, fmt.Errorf("find_all key must be a string, got %s", typeName(k))
+	}
+
+	found := []interface{}{}
+	err = descend(ctx, e, in, map[string]bool{}, func(v interface{}) error {
+		if obj, ok := v.(map[string]interface{}); ok {
+			if x, ok := obj[key]; ok {
+				found = append(found, x)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStream(found)
+}
+
+// fPaths emits the path to every value nested within the input as an array
+// of string keys & int indexes. when leaves is set only paths to scalars are
+// emitted
+type fPaths struct {
+	leaves bool
+}
+
+func (f fPaths) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	paths := []interface{}{}
+	err = eachPath(ctx, e, nil, in, func(path []interface{}, v interface{}) error {
+		if f.leaves && !isScalar(v) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStream(paths)
+}
+
+// isScalar reports if a resolved value is neither an object nor an array
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, value.Map,
+		[]interface{}, value.Array, value.Iterator:
+		return false
+	}
+	return true
+}
+
+// pathIndex interprets a path element as an array index
+func pathIndex(el interface{}) (int, bool) {
+	switch i := el.(type) {
+	case int:
+		return i, true
+	case float64:
+		if i == float64(int(i)) {
+			return int(i), true
+		}
+	}
+	return 0, false
+}
+
+// pathArg evaluates a filter that must produce a path array
+func pathArg(ctx context.Context, e *env, name string, f filter, in interface{}) ([]interface{}, error) {
+	v, err := argValue(ctx, e, f, in)
+	if err != nil {
+		return nil, err
+	}
+	path, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s path must be an array, got %s", name, typeName(v))
+	}
+	return path, nil
+}
+
+// getPath navigates a path of string keys & int indexes into the input.
+// missing keys, out of range indexes & null values all yield null
+func getPath(ctx context.Context, e *env, in interface{}, path []interface{}) (v interface{}, err error) {
+	for _, el := range path {
+		if link, ok := in.(value.Link); ok {
+			if in, err = resolveLink(ctx, e, link); err != nil {
+				return nil, err
+			}
+		}
+		if in == nil {
+			return nil, nil
+		}
+
+		if key, ok := el.(string); ok {
+			obj, err := toObject(in)
+			if err != nil {
+				return nil, fmt.Errorf("cannot index %s with %q", typeName(in), key)
+			}
+			in = obj[key]
+			continue
+		}
+		i, ok := pathIndex(el)
+		if !ok {
+			return nil, fmt.Errorf("invalid path element: %v", el)
+		}
+		vals, err := collect(ctx, e, in)
+		if err != nil {
+			return nil, fmt.Errorf("cannot index %s with number", typeName(in))
+		}
+		if i < 0 {
+			i += len(vals)
+		}
+		if i < 0 || i >= len(vals) {
+			return nil, nil
+		}
+		in = vals[i]
+	}
+	return in, nil
+}
+
+// setPath returns a copy of the input with the value at path replaced by v.
+// missing objects & arrays along the path are created, arrays are grown with
+// nulls to fit an index. the input is never modified
+func setPath(ctx context.Context, e *env, in interface{}, path []interface{}, v interface{}) (out interface{}, err error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := path[0].(string); ok {
+		obj := map[string]interface{}{}
+		if in != nil {
+			if obj, err = toObject(in); err != nil {
+				return nil, fmt.Errorf("cannot index %s with %q", typeName(in), key)
+			}
+		}
+		cp := make(map[string]interface{}, len(obj)+1)
+		for k, x := range obj {
+			cp[k] = x
+		}
+		if cp[key], err = setPath(ctx, e, obj[key], path[1:], v); err != nil {
+			return nil, err
+		}
+		return cp, nil
+	}
+
+	i, ok := pathIndex(path[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid path element: %v", path[0])
+	}
+	vals := []interface{}{}
+	if in != nil {
+		if vals, err = collect(ctx, e, in); err != nil {
+			return nil, fmt.Errorf("cannot index %s with number", typeName(in))
+		}
+	}
+	if i < 0 {
+		if i += len(vals); i < 0 {
+			return nil, fmt.Errorf("out of bounds negative array index")
+		}
+	}
+	for len(vals) <= i {
+		vals = append(vals, nil)
+	}
+	if vals[i], err = setPath(ctx, e, vals[i], path[1:], v); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// fGetPath selects the value at a path array of keys & indexes
+type fGetPath struct {
+	path filter
+}
+
+func (f fGetPath) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	path, err := pathArg(ctx, e, "getpath", f.path, in)
+	if err != nil {
+		return nil, err
+	}
+	return getPath(ctx, e, in, path)
+}
+
+// fSetPath returns a copy of the input with the value at a path array set
+type fSetPath struct {
+	path filter
+	val  filter
+}
+
+func (f fSetPath) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	path, err := pathArg(ctx, e, "setpath", f.path, in)
+	if err != nil {
+		return nil, err
+	}
+	v, err := argValue(ctx, e, f.val, in)
+	if err != nil {
+		return nil, err
+	}
+	return setPath(ctx, e, in, path, v)
+}
+
+// pathValue is a value located at a path within an input
+type pathValue struct {
+	path []interface{}
+	v    interface{}
+}
+
+// pathsOf finds the paths a path expression like ".a.b", ".[0]" or
+// ".[] | select(.x)" selects within the input. filters that build new values
+// instead of selecting existing ones aren't path expressions & error
+func pathsOf(ctx context.Context, e *env, f filter, at pathValue) (found []pathValue, err error) {
+	switch x := f.(type) {
+	case fIdentity:
+		return []pathValue{at}, nil
+	case fKeySelector, fOptionalKeySelector, fIndexSelector:
+		var el interface{}
+		switch k := x.(type) {
+		case fKeySelector:
+			el = string(k)
+		case fOptionalKeySelector:
+			el = string(k)
+		case fIndexSelector:
+			el = int(k)
+		}
+		v, err := getPath(ctx, e, at.v, []interface{}{el})
+		if err != nil {
+			if _, ok := x.(fOptionalKeySelector); ok {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []pathValue{{appendPath(at.path, el), v}}, nil
+	case fIterateAllSeletor:
+		in := at.v
+		if link, ok := in.(value.Link); ok {
+			if in, err = resolveLink(ctx, e, link); err != nil {
+				return nil, err
+			}
+		}
+		if bool(x) && isScalar(in) {
+			return nil, nil
+		}
+		switch in.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, value.Map:
+			obj, err := toObject(in)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range sortedKeys(obj) {
+				found = append(found, pathValue{appendPath(at.path, key), obj[key]})
+			}
+			return found, nil
+		}
+		vals, err := collect(ctx, e, in)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range vals {
+			found = append(found, pathValue{appendPath(at.path, i), v})
+		}
+		return found, nil
+	case fSelect:
+		ok, err := condition(ctx, e, x.f, at.v)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []pathValue{at}, nil
+	case fSelector:
+		steps := make([]filter, len(x))
+		for i, sel := range x {
+			steps[i] = sel
+		}
+		return pathsThrough(ctx, e, steps, at)
+	case fPipe:
+		return pathsThrough(ctx, e, x, at)
+	case fComma:
+		for _, fi := range x {
+			res, err := pathsOf(ctx, e, fi, at)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, res...)
+		}
+		return found, nil
+	}
+	return nil, fmt.Errorf("invalid path expression: %s", describe(f))
+}
+
+// pathsThrough finds the paths selected by a sequence of path expressions
+func pathsThrough(ctx context.Context, e *env, steps []filter, at pathValue) ([]pathValue, error) {
+	found := []pathValue{at}
+	for _, step := range steps {
+		var next []pathValue
+		for _, pv := range found {
+			res, err := pathsOf(ctx, e, step, pv)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, res...)
+		}
+		found = next
+	}
+	return found, nil
+}
+
+// deletePaths returns a copy of the input with every path removed. paths are
+// deleted last-first so removing an array element doesn't shift the indexes
+// of paths yet to be deleted
+func deletePaths(ctx context.Context, e *env, in interface{}, paths []interface{}) (out interface{}, err error) {
+	sorted := make([]interface{}, len(paths))
+	copy(sorted, paths)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareValues(sorted[i], sorted[j]) > 0
+	})
+
+	out = in
+	for _, p := range sorted {
+		path, ok := p.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("path must be an array, got %s", typeName(p))
+		}
+		if out, err = deletePath(ctx, e, out, path); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// deletePath returns a copy of the input without the value at path. missing
+// keys & out of range indexes are ignored
+func deletePath(ctx context.Context, e *env, in interface{}, path []interface{}) (out interface{}, err error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return nil, err
+		}
+	}
+	if in == nil {
+		return nil, nil
+	}
+
+	if key, ok := path[0].(string); ok {
+		obj, err := toObject(in)
+		if err != nil {
+			return nil, fmt.Errorf("cannot delete field %q of %s", key, typeName(in))
+		}
+		if _, ok := obj[key]; !ok {
+			return obj, nil
+		}
+		cp := make(map[string]interface{}, len(obj))
+		for k, x := range obj {
+			cp[k] = x
+		}
+		if len(path) == 1 {
+			delete(cp, key)
+		} else if cp[key], err = deletePath(ctx, e, obj[key], path[1:]); err != nil {
+			return nil, err
+		}
+		return cp, nil
+	}
+
+	i, ok := pathIndex(path[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid path element: %v", path[0])
+	}
+	vals, err := collect(ctx, e, in)
+	if err != nil {
+		return nil, fmt.Errorf("cannot delete index %d of %s", i, typeName(in))
+	}
+	if i < 0 {
+		i += len(vals)
+	}
+	if i < 0 || i >= len(vals) {
+		return vals, nil
+	}
+	if len(path) == 1 {
+		return append(vals[:i], vals[i+1:]...), nil
+	}
+	if vals[i], err = deletePath(ctx, e, vals[i], path[1:]); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// fDel removes the values selected by a path expression
+type fDel struct {
+	path filter
+}
+
+func (f fDel) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	found, err := pathsOf(ctx, e, f.path, pathValue{v: in})
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]interface{}, len(found))
+	for i, pv := range found {
+		paths[i] = pv.path
+	}
+	return deletePaths(ctx, e, in, paths)
+}
+
+// fDelPaths removes every path in an array of path arrays
+type fDelPaths struct {
+	paths filter
+}
+
+func (f fDelPaths) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	paths, err := pathArg(ctx, e, "delpaths", f.paths, in)
+	if err != nil {
+		return nil, err
+	}
+	return deletePaths(ctx, e, in, paths)
+}
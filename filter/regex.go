@@ -0,0 +1,251 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// namedGroup matches the jq (Oniguruma) named group syntax "(?<name>", which
+// is rewritten to the "(?P<name>" syntax of the go regexp package
+var namedGroup = regexp.MustCompile(`\(\?<([A-Za-z_])`)
+
+// regexFlags are the parsed flags of a regular expression builtin
+type regexFlags struct {
+	// global matches every occurrence instead of only the first
+	global bool
+	// skipEmpty ignores empty matches
+	skipEmpty bool
+	// prefix holds the go regexp flags the flags map to
+	prefix string
+}
+
+// parseRegexFlags reads a jq flag string. "g" matches globally, "n" ignores
+// empty matches, "i" is case-insensitive and "s" lets "." match newlines
+func parseRegexFlags(flags string) (f regexFlags, err error) {
+	for _, c := range flags {
+		switch c {
+		case 'g':
+			f.global = true
+		case 'n':
+			f.skipEmpty = true
+		case 'i', 's':
+			f.prefix += string(c)
+		default:
+			return f, fmt.Errorf("%s is not a valid modifier string", flags)
+		}
+	}
+	if f.prefix != "" {
+		f.prefix = "(?" + f.prefix + ")"
+	}
+	return f, nil
+}
+
+// regexp compiles a regular expression, caching the result for the duration
+// of an Apply call so patterns aren't recompiled for each input
+func (e *env) regexp(pattern string, flags regexFlags) (*regexp.Regexp, error) {
+	src := flags.prefix + namedGroup.ReplaceAllString(pattern, "(?P<$1")
+	if re, ok := e.regexps[src]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %s", pattern, strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+	}
+	if e.regexps == nil {
+		e.regexps = map[string]*regexp.Regexp{}
+	}
+	e.regexps[src] = re
+	return re, nil
+}
+
+// regexArgs reads the string input, pattern & optional flags of a regular
+// expression builtin, compiling the pattern
+func regexArgs(ctx context.Context, e *env, name string, args []filter, in interface{}) (str string, re *regexp.Regexp, flags regexFlags, err error) {
+	if str, err = stringInput(name, in); err != nil {
+		return
+	}
+	pattern, err := stringArg(ctx, e, name, args[0], in)
+	if err != nil {
+		return
+	}
+	if len(args) > 1 {
+		var fs string
+		if fs, err = stringArg(ctx, e, name, args[1], in); err != nil {
+			return
+		}
+		if flags, err = parseRegexFlags(fs); err != nil {
+			return
+		}
+	}
+	re, err = e.regexp(pattern, flags)
+	return
+}
+
+// fRegexTest checks whether a string matches a regular expression
+type fRegexTest struct {
+	args []filter
+}
+
+func (f fRegexTest) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, re, _, err := regexArgs(ctx, e, "test", f.args, in)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(str), nil
+}
+
+// matches finds the matches of a regular expression in a string as byte
+// index pairs, following the global & skip-empty flags
+func matches(re *regexp.Regexp, str string, flags regexFlags) [][]int {
+	n := 1
+	if flags.global {
+		n = -1
+	}
+	var res [][]int
+	for _, loc := range re.FindAllStringSubmatchIndex(str, n) {
+		if flags.skipEmpty && loc[0] == loc[1] {
+			continue
+		}
+		res = append(res, loc)
+	}
+	return res
+}
+
+// matchObject describes a match as jq does: the offset & length of the match
+// in characters, the matched string, and an array of captures. captures that
+// didn't participate in the match have an offset of -1 and a null string
+func matchObject(re *regexp.Regexp, str string, loc []int) map[string]interface{} {
+	names := re.SubexpNames()
+	captures := make([]interface{}, 0, len(names)-1)
+	for i := 1; i < len(names); i++ {
+		c := map[string]interface{}{"offset": -1, "length": 0, "string": nil, "name": nil}
+		if names[i] != "" {
+			c["name"] = names[i]
+		}
+		if start, end := loc[2*i], loc[2*i+1]; start >= 0 {
+			c["offset"] = utf8.RuneCountInString(str[:start])
+			c["length"] = utf8.RuneCountInString(str[start:end])
+			c["string"] = str[start:end]
+		}
+		captures = append(captures, c)
+	}
+	return map[string]interface{}{
+		"offset":   utf8.RuneCountInString(str[:loc[0]]),
+		"length":   utf8.RuneCountInString(str[loc[0]:loc[1]]),
+		"string":   str[loc[0]:loc[1]],
+		"captures": captures,
+	}
+}
+
+// captureObject maps the names of named groups to their captured strings.
+// groups that didn't participate in the match are null
+func captureObject(re *regexp.Regexp, str string, loc []int) map[string]interface{} {
+	res := map[string]interface{}{}
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		res[name] = nil
+		if start, end := loc[2*i], loc[2*i+1]; start >= 0 {
+			res[name] = str[start:end]
+		}
+	}
+	return res
+}
+
+// fMatch produces a match object for each match of a regular expression.
+// without the "g" flag only the first match is produced, and no match
+// produces no output
+type fMatch struct {
+	args []filter
+}
+
+func (f fMatch) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, re, flags, err := regexArgs(ctx, e, "match", f.args, in)
+	if err != nil {
+		return nil, err
+	}
+	locs := matches(re, str, flags)
+	if len(locs) == 1 && !flags.global {
+		return matchObject(re, str, locs[0]), nil
+	}
+	vals := []interface{}{}
+	for _, loc := range locs {
+		vals = append(vals, matchObject(re, str, loc))
+	}
+	return newStream(vals)
+}
+
+// fCapture produces an object of named groups for each match of a regular
+// expression. without the "g" flag only the first match is produced
+type fCapture struct {
+	args []filter
+}
+
+func (f fCapture) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, re, flags, err := regexArgs(ctx, e, "capture", f.args, in)
+	if err != nil {
+		return nil, err
+	}
+	locs := matches(re, str, flags)
+	if len(locs) == 1 && !flags.global {
+		return captureObject(re, str, locs[0]), nil
+	}
+	vals := []interface{}{}
+	for _, loc := range locs {
+		vals = append(vals, captureObject(re, str, loc))
+	}
+	return newStream(vals)
+}
+
+// fSub replaces the first match of a regular expression in a string, or
+// every match when global. the replacement filter is applied to the capture
+// object of each match, so replacements can reference named groups like
+// sub("(?<x>\\d+)"; .x + "!"). only the first output of the replacement is
+// used
+type fSub struct {
+	args   []filter
+	global bool
+}
+
+func (f fSub) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	name := "sub"
+	if f.global {
+		name = "gsub"
+	}
+	str, re, flags, err := regexArgs(ctx, e, name, append([]filter{f.args[0]}, f.args[2:]...), in)
+	if err != nil {
+		return nil, err
+	}
+	flags.global = flags.global || f.global
+
+	var b strings.Builder
+	prev := 0
+	for _, loc := range matches(re, str, flags) {
+		repl, err := stringArg(ctx, e, name, f.args[1], captureObject(re, str, loc))
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(str[prev:loc[0]])
+		b.WriteString(repl)
+		prev = loc[1]
+	}
+	b.WriteString(str[prev:])
+	return b.String(), nil
+}
@@ -4,38 +4,46 @@ import (
 	"bufio"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 // newScanner allocates a scanner from an io.Reader
 func newScanner(r io.Reader) *scanner {
-	return &scanner{r: bufio.NewReader(r)}
+	return &scanner{r: bufio.NewReader(r), line: 1, col: 1}
 }
 
 // scanner tokenizes an input stream
-// TODO(b5): set position properly for errors
 type scanner struct {
 	r *bufio.Reader
 
 	// scanning state
-	tok               token
-	text              strings.Builder
+	tok  token
+	text strings.Builder
+	// line & col are the 1-based position of the next rune, offset is
+	// its byte offset. prev is the position before the last read, restored
+	// by unread. start is the position of the token being scanned
 	line, col, offset int
+	prev, start       position
+	spaced            bool
 	err               error
 }
 
 // Scan reads one token from the input stream
 func (s *scanner) Scan() token {
 	s.text.Reset()
+	s.spaced = false
 
 	for {
+		s.start = s.pos()
 		ch := s.read()
 
 		switch ch {
 		case eof:
 			return s.newTok(tEOF)
 		// ignore whitespace
-		case '\r', ' ':
+		case '\r', '\n', '\t', ' ':
+			s.spaced = true
 			continue
 
 		case '|':
@@ -54,9 +62,25 @@ func (s *scanner) Scan() token {
 			return s.newTok(tRightBrace)
 		case ':':
 			return s.newTok(tColon)
+		case ';':
+			return s.newTok(tSemicolon)
+		case '?':
+			return s.newTok(tQuestion)
+		case '$':
+			tok := s.scanVariable()
+			tok.Type = tVariable
+			return tok
+		case '@':
+			tok := s.scanLiteral()
+			tok.Type = tFormat
+			return tok
 		case '.':
+			if s.follows('.') {
+				return s.newTok(tRecurse)
+			}
 			if p, err := s.r.Peek(1); err == nil {
 				if isNumericByte(p[0]) {
+					s.text.WriteRune(ch)
 					return s.scanNumber()
 				}
 			}
@@ -67,16 +91,32 @@ func (s *scanner) Scan() token {
 		case '+':
 			return s.newTok(tPlus)
 		case '-':
-			if p, err := s.r.Peek(1); err == nil {
-				if isNumericByte(p[0]) {
-					return s.scanNumber()
-				}
-			}
+			// negative numbers are handled by the parser
 			return s.newTok(tMinus)
 		case '*':
 			return s.newTok(tStar)
 		case '/':
 			return s.newTok(tForwardSlash)
+		case '=':
+			if s.follows('=') {
+				return s.newTok(tEqual)
+			}
+			return s.newTok(IllegalTok)
+		case '!':
+			if s.follows('=') {
+				return s.newTok(tNotEqual)
+			}
+			return s.newTok(IllegalTok)
+		case '<':
+			if s.follows('=') {
+				return s.newTok(tLessEqual)
+			}
+			return s.newTok(tLess)
+		case '>':
+			if s.follows('=') {
+				return s.newTok(tGreaterEqual)
+			}
+			return s.newTok(tGreater)
 
 		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 			s.unread()
@@ -93,35 +133,67 @@ func (s *scanner) Scan() token {
 // read reads the next rune from the buffered reader.
 // Returns the rune(0) if an error occurs (or io.EOF is returned).
 func (s *scanner) read() rune {
-	ch, _, err := s.r.ReadRune()
+	ch, size, err := s.r.ReadRune()
 	if err != nil {
 		return eof
 	}
+	s.prev = s.pos()
+	s.offset += size
+	if ch == '\n' {
+		s.line++
+		s.col = 1
+	} else {
+		s.col++
+	}
 	return ch
 }
 
+// unread steps back one rune. only the last rune read can be unread
 func (s *scanner) unread() error {
-	return s.r.UnreadRune()
+	if err := s.r.UnreadRune(); err != nil {
+		return err
+	}
+	s.line, s.col, s.offset = s.prev.Line, s.prev.Col, s.prev.Offset
+	return nil
+}
+
+// pos is the position of the next rune
+func (s *scanner) pos() position {
+	return position{Line: s.line, Col: s.col, Offset: s.offset}
+}
+
+// follows consumes the next rune if it's ch, reporting if it was
+func (s *scanner) follows(ch rune) bool {
+	switch s.read() {
+	case ch:
+		return true
+	case eof:
+		return false
+	}
+	s.unread()
+	return false
 }
 
 // newTok creates a new token from current scanner state
 func (s *scanner) newTok(t tokenType) token {
 	return token{
-		Type: t,
-		Text: strings.TrimSpace(s.text.String()),
-		Pos:  position{Line: s.line, Col: s.col, Offset: s.offset},
+		Type:   t,
+		Text:   strings.TrimSpace(s.text.String()),
+		Pos:    s.start,
+		Spaced: s.spaced,
 	}
 }
 
 func (s *scanner) newTextTok() token {
 	return token{
-		Type: tText,
-		Text: strings.TrimSpace(s.text.String()),
-		Pos:  position{Line: s.line, Col: s.col, Offset: s.offset},
+		Type:   tText,
+		Text:   strings.TrimSpace(s.text.String()),
+		Pos:    s.start,
+		Spaced: s.spaced,
 	}
 }
 
-var literalMatch = regexp.MustCompile(`[\w\n_\-]`)
+var literalMatch = regexp.MustCompile(`[\w_\-]`)
 
 func (s *scanner) scanLiteral() token {
 	for {
@@ -135,34 +207,168 @@ func (s *scanner) scanLiteral() token {
 	}
 }
 
+// scanVariable reads a variable name, which unlike other literals can't
+// contain '-' so $x-1 subtracts
+func (s *scanner) scanVariable() token {
+	for {
+		ch := s.read()
+		if isVariableRune(ch) {
+			s.text.WriteRune(ch)
+		} else {
+			s.unread()
+			return s.newTextTok()
+		}
+	}
+}
+
+func isVariableRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
 func (s *scanner) scanQuotedText() token {
+	var parts []string
 	for {
 		ch := s.read()
 		switch ch {
 		default:
 			s.text.WriteRune(ch)
+		case '\\':
+			if !s.follows('(') {
+				s.scanEscape()
+				continue
+			}
+			src, ok := s.scanInterpolation()
+			if !ok {
+				return s.newTok(IllegalTok)
+			}
+			parts = append(parts, s.text.String(), src)
+			s.text.Reset()
 		case '"', eof:
-			return s.newTextTok()
+			// whitespace within quotes is part of the string
+			tok := s.newTok(tString)
+			tok.Text = s.text.String()
+			if parts != nil {
+				tok.Type = tInterpolation
+				tok.Parts = append(parts, tok.Text)
+			}
+			return tok
+		}
+	}
+}
+
+// scanInterpolation reads the source of an expression embedded in a string
+// up to its closing paren, the opening "\(" must already be consumed.
+// parens within nested string literals are ignored
+func (s *scanner) scanInterpolation() (src string, ok bool) {
+	var b strings.Builder
+	depth, quoted := 0, false
+	for {
+		ch := s.read()
+		switch {
+		case ch == eof:
+			return "", false
+		case quoted && ch == '\\':
+			b.WriteRune(ch)
+			ch = s.read()
+		case ch == '"':
+			quoted = !quoted
+		case !quoted && ch == '(':
+			depth++
+		case !quoted && ch == ')':
+			if depth == 0 {
+				return b.String(), true
+			}
+			depth--
+		}
+		b.WriteRune(ch)
+	}
+}
+
+// scanEscape reads a JSON escape sequence within a string literal, the
+// backslash must already be consumed. unrecognized escapes are kept as-is
+func (s *scanner) scanEscape() {
+	ch := s.read()
+	switch ch {
+	case '"', '\\', '/':
+		s.text.WriteRune(ch)
+	case 'b':
+		s.text.WriteRune('\b')
+	case 'f':
+		s.text.WriteRune('\f')
+	case 'n':
+		s.text.WriteRune('\n')
+	case 'r':
+		s.text.WriteRune('\r')
+	case 't':
+		s.text.WriteRune('\t')
+	case 'u':
+		var hex strings.Builder
+		for i := 0; i < 4; i++ {
+			c := s.read()
+			if !isHexRune(c) {
+				s.unread()
+				break
+			}
+			hex.WriteRune(c)
+		}
+		if n, err := strconv.ParseUint(hex.String(), 16, 32); err == nil && hex.Len() == 4 {
+			s.text.WriteRune(rune(n))
+		} else {
+			s.text.WriteString(`\u` + hex.String())
 		}
+	case eof:
+		s.text.WriteRune('\\')
+	default:
+		s.text.WriteRune('\\')
+		s.text.WriteRune(ch)
 	}
 }
 
+func isHexRune(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
 func (s *scanner) scanNumber() token {
 	for {
+		if n := s.exponentLen(); n > 0 {
+			for i := 0; i < n; i++ {
+				s.text.WriteRune(s.read())
+			}
+			continue
+		}
 		ch := s.read()
 		if isNumericByte(byte(ch)) {
 			s.text.WriteRune(ch)
 		} else {
 			s.unread()
 			return token{
-				Type: tNumber,
-				Text: strings.TrimSpace(s.text.String()),
-				Pos:  position{Line: s.line, Col: s.col, Offset: s.offset},
+				Type:   tNumber,
+				Text:   strings.TrimSpace(s.text.String()),
+				Pos:    s.start,
+				Spaced: s.spaced,
 			}
 		}
 	}
 }
 
+// exponentLen peeks for the start of an exponent: an 'e' or 'E', an
+// optional sign & a digit. it returns the number of runes before the digit,
+// or zero if no exponent follows
+func (s *scanner) exponentLen() int {
+	p, _ := s.r.Peek(3)
+	if len(p) < 2 || (p[0] != 'e' && p[0] != 'E') {
+		return 0
+	}
+	n := 1
+	if p[1] == '+' || p[1] == '-' {
+		n = 2
+	}
+	if len(p) > n && p[n] >= '0' && p[n] <= '9' {
+		return n
+	}
+	return 0
+}
+
 func isNumericByte(b byte) bool {
 	switch b {
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.':
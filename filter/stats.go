@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// toFloat converts numeric values to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case byte:
+		return float64(n), true
+	case fNumericLiteral:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// sumValues folds all non-null numbers in the input, returning the total and
+// the count of numbers summed. non-numeric values are an error
+func sumValues(ctx context.Context, e *env, in interface{}) (sum float64, count int, err error) {
+	err = each(ctx, e, in, func(v interface{}) error {
+		if v == nil {
+			return nil
+		}
+		n, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("cannot sum %s", typeName(v))
+		}
+		sum += n
+		count++
+		return nil
+	})
+	return sum, count, err
+}
+
+// numbers collects all non-null numbers in the input into a slice.
+// non-numeric values are an error
+func numbers(ctx context.Context, e *env, in interface{}) (nums []float64, err error) {
+	err = each(ctx, e, in, func(v interface{}) error {
+		if v == nil {
+			return nil
+		}
+		n, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("expected number, got %s", typeName(v))
+		}
+		nums = append(nums, n)
+		return nil
+	})
+	return nums, err
+}
+
+// fSum adds together the numbers of an array, ignoring nulls
+type fSum byte
+
+func (f fSum) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	sum, _, err := sumValues(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// fAdd folds the elements of an array together with the + operator. adding
+// an empty array is null
+type fAdd byte
+
+func (f fAdd) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	err = each(ctx, e, in, func(v interface{}) (err error) {
+		out, err = add(ctx, e, out, v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// fAvg is the arithmetic mean of the numbers of an array, ignoring nulls.
+// the average of an array without numbers is null
+type fAvg byte
+
+func (f fAvg) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	sum, count, err := sumValues(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	return sum / float64(count), nil
+}
+
+// fCount is the number of non-null elements of an array
+type fCount byte
+
+func (f fCount) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	count := 0
+	err = each(ctx, e, in, func(v interface{}) error {
+		if v != nil {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// fMedian is the middle number of an array, ignoring nulls. arrays with an
+// even count of numbers average the middle two. the median of an array
+// without numbers is null
+type fMedian byte
+
+func (f fMedian) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	nums, err := numbers(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return nil, nil
+	}
+
+	sort.Float64s(nums)
+	mid := len(nums) / 2
+	if len(nums)%2 == 0 {
+		return (nums[mid-1] + nums[mid]) / 2, nil
+	}
+	return nums[mid], nil
+}
+
+// fStddev is the population standard deviation of the numbers of an array,
+// ignoring nulls. the standard deviation of an array without numbers is null
+type fStddev byte
+
+func (f fStddev) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	nums, err := numbers(ctx, e, in)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return nil, nil
+	}
+
+	sum := 0.0
+	for _, n := range nums {
+		sum += n
+	}
+	mean := sum / float64(len(nums))
+
+	variance := 0.0
+	for _, n := range nums {
+		variance += (n - mean) * (n - mean)
+	}
+	return math.Sqrt(variance / float64(len(nums))), nil
+}
@@ -2,6 +2,7 @@ package filter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/qri-io/value"
@@ -76,16 +77,124 @@ func (it *valueStream) ValueForIndex(i int) (v interface{}, err error) {
 	return it.vals[i], nil
 }
 
-func applyToStream(ctx context.Context, r value.Resolver, vs *valueStream, f filter) (res interface{}, err error) {
-	var vals []interface{}
+// applyToStream applies a filter to each value in a stream, producing a
+// stream of the results. filters that produce streams of their own are
+// flattened into the result, so filters that produce no output drop values
+// from the stream
+func applyToStream(ctx context.Context, e *env, vs *valueStream, f filter) (res interface{}, err error) {
+	vals := []interface{}{}
 	var v interface{}
 	for vs.Next(&v) {
-		if v, err = f.apply(ctx, r, v); err != nil {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if v, err = e.eval(ctx, f, v); err != nil {
 			return res, err
 		}
-		vals = append(vals, v)
+		err = eachOutput(v, func(x interface{}) error {
+			vals = append(vals, x)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newStream(vals)
+}
+
+// generator is implemented by filters that can produce their outputs one at
+// a time, letting consumers like first stop early without materializing every
+// output
+type generator interface {
+	generate(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) error
+}
+
+// errStopGenerating is returned by generate callbacks that don't need any
+// more outputs
+var errStopGenerating = errors.New("stop generating")
+
+// generate calls fn with each output of a filter applied to the input,
+// lazily if the filter is a generator. fn returns errStopGenerating to stop
+// early, which generate reports as a nil error
+func generate(ctx context.Context, e *env, f filter, in interface{}, fn func(v interface{}) error) error {
+	if err := generateOutputs(ctx, e, f, in, fn); err != nil && err != errStopGenerating {
+		return err
+	}
+	return nil
+}
+
+func generateOutputs(ctx context.Context, e *env, f filter, in interface{}, fn func(v interface{}) error) error {
+	if vs, ok := in.(*valueStream); ok {
+		defer vs.Close()
+		var v interface{}
+		for vs.Next(&v) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := generateOutputs(ctx, e, f, v, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if g, ok := f.(generator); ok {
+		return g.generate(ctx, e, in, fn)
+	}
+	out, err := e.eval(ctx, f, in)
+	if err != nil {
+		return err
+	}
+	return eachOutput(out, fn)
+}
+
+func (f fPipe) generate(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) error {
+	if len(f) == 1 {
+		return generateOutputs(ctx, e, f[0], in, fn)
+	}
+	return generateOutputs(ctx, e, f[0], in, func(v interface{}) error {
+		return generateOutputs(ctx, e, f[1:], v, fn)
+	})
+}
+
+func (f fComma) generate(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) error {
+	if vs, ok := in.(*valueStream); ok {
+		// each value of a stream passes through every filter before the next
+		return generateOutputs(ctx, e, f, vs, fn)
+	}
+	for _, fi := range f {
+		if err := generateOutputs(ctx, e, fi, in, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f fSelector) generate(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) (err error) {
+	last := len(f) - 1
+	for _, sel := range f[:last] {
+		if in, err = e.eval(ctx, sel, in); err != nil {
+			return err
+		}
+	}
+	return generateOutputs(ctx, e, f[last], in, fn)
+}
+
+func (f fIterateAllSeletor) generate(ctx context.Context, e *env, in interface{}, fn func(v interface{}) error) (err error) {
+	if link, ok := in.(value.Link); ok {
+		if in, err = resolveLink(ctx, e, link); err != nil {
+			return err
+		}
+	}
+	switch in.(type) {
+	case value.Iterator, value.Array:
+		return each(ctx, e, in, fn)
+	}
+	out, err := f.apply(ctx, e, in)
+	if err != nil {
+		return err
 	}
-	return vals, nil
+	return eachOutput(out, fn)
 }
 
 // type keyValueStream struct {
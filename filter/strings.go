@@ -0,0 +1,359 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/qri-io/value"
+)
+
+// stringArg evaluates a builtin argument that must be a string
+func stringArg(ctx context.Context, e *env, name string, f filter, in interface{}) (string, error) {
+	v, err := argValue(ctx, e, f, in)
+	if err != nil {
+		return "", err
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case []byte:
+		return string(s), nil
+	}
+	return "", fmt.Errorf("%s expects a string argument, got %s", name, typeName(v))
+}
+
+// stringInput gets the text of a string input to a builtin
+func stringInput(name string, in interface{}) (string, error) {
+	switch s := in.(type) {
+	case string:
+		return s, nil
+	case []byte:
+		return string(s), nil
+	}
+	return "", fmt.Errorf("%s expects a string input, got %s", name, typeName(in))
+}
+
+// fSplit splits a string into an array of substrings around a separator
+type fSplit struct {
+	sep filter
+}
+
+func (f fSplit) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, err := stringInput("split", in)
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg(ctx, e, "split", f.sep, in)
+	if err != nil {
+		return nil, err
+	}
+	if str == "" {
+		return []interface{}{}, nil
+	}
+	return stringSlice(strings.Split(str, sep)), nil
+}
+
+// fJoin joins the elements of an array into a string with a separator.
+// numbers & booleans are written as text and nulls as empty strings
+type fJoin struct {
+	sep filter
+}
+
+func (f fJoin) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	sep, err := stringArg(ctx, e, "join", f.sep, in)
+	if err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	err = each(ctx, e, in, func(v interface{}) error {
+		if v == nil {
+			strs = append(strs, "")
+			return nil
+		}
+		switch typeName(v) {
+		case "string", "number", "boolean":
+			s, _ := value.AsString(v)
+			strs = append(strs, s)
+			return nil
+		}
+		return fmt.Errorf("cannot join with %s", typeName(v))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return strings.Join(strs, sep), nil
+}
+
+// affixArgs reads the string input & string argument of the prefix & suffix
+// builtins
+func affixArgs(ctx context.Context, e *env, name string, f filter, in interface{}) (str, affix string, err error) {
+	if str, err = stringInput(name, in); err != nil {
+		return "", "", err
+	}
+	affix, err = stringArg(ctx, e, name, f, in)
+	return str, affix, err
+}
+
+// fStartsWith checks whether a string begins with a prefix
+type fStartsWith struct {
+	prefix filter
+}
+
+func (f fStartsWith) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, prefix, err := affixArgs(ctx, e, "startswith", f.prefix, in)
+	if err != nil {
+		return nil, err
+	}
+	return strings.HasPrefix(str, prefix), nil
+}
+
+// fEndsWith checks whether a string ends with a suffix
+type fEndsWith struct {
+	suffix filter
+}
+
+func (f fEndsWith) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, suffix, err := affixArgs(ctx, e, "endswith", f.suffix, in)
+	if err != nil {
+		return nil, err
+	}
+	return strings.HasSuffix(str, suffix), nil
+}
+
+// fLtrimstr removes a prefix from a string if it's present
+type fLtrimstr struct {
+	prefix filter
+}
+
+func (f fLtrimstr) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, prefix, err := affixArgs(ctx, e, "ltrimstr", f.prefix, in)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimPrefix(str, prefix), nil
+}
+
+// fRtrimstr removes a suffix from a string if it's present
+type fRtrimstr struct {
+	suffix filter
+}
+
+func (f fRtrimstr) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, suffix, err := affixArgs(ctx, e, "rtrimstr", f.suffix, in)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSuffix(str, suffix), nil
+}
+
+// fAsciiDowncase converts the ASCII letters of a string to lower case,
+// leaving all other characters untouched
+type fAsciiDowncase byte
+
+func (f fAsciiDowncase) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, err := stringInput("ascii_downcase", in)
+	if err != nil {
+		return nil, err
+	}
+	return shiftASCII(str, 'A', 'Z', 'a'-'A'), nil
+}
+
+// fAsciiUpcase converts the ASCII letters of a string to upper case, leaving
+// all other characters untouched
+type fAsciiUpcase byte
+
+func (f fAsciiUpcase) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, err := stringInput("ascii_upcase", in)
+	if err != nil {
+		return nil, err
+	}
+	return shiftASCII(str, 'a', 'z', 'A'-'a'), nil
+}
+
+// shiftASCII adds delta to every byte of a string between lo & hi. bytes of
+// multi-byte UTF-8 characters are always above the ASCII range, so they're
+// never changed
+func shiftASCII(str string, lo, hi byte, delta int) string {
+	b := []byte(str)
+	for i, c := range b {
+		if c >= lo && c <= hi {
+			b[i] = byte(int(c) + delta)
+		}
+	}
+	return string(b)
+}
+
+// fExplode converts a string to an array of its unicode codepoints
+type fExplode byte
+
+func (f fExplode) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	str, err := stringInput("explode", in)
+	if err != nil {
+		return nil, err
+	}
+	res := []interface{}{}
+	for _, r := range str {
+		res = append(res, int(r))
+	}
+	return res, nil
+}
+
+// fImplode builds a string from an array of unicode codepoints
+type fImplode byte
+
+func (f fImplode) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if typeName(in) != "array" {
+		return nil, fmt.Errorf("implode expects an array input, got %s", typeName(in))
+	}
+
+	var b strings.Builder
+	err = each(ctx, e, in, func(v interface{}) error {
+		n, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("cannot implode %s, codepoints must be numbers", typeName(v))
+		}
+		r := rune(n)
+		if float64(r) != n || !utf8.ValidRune(r) {
+			return fmt.Errorf("invalid codepoint: %v", v)
+		}
+		b.WriteRune(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.String(), nil
+}
+
+// jsonText encodes a value as compact JSON, with object keys in sorted
+// order
+func jsonText(ctx context.Context, e *env, in interface{}) (string, error) {
+	v, err := materialize(ctx, e, in)
+	if err != nil {
+		return "", err
+	}
+	data, err := value.MarshalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// fToString converts its input to a string. strings are unchanged, all other
+// values are encoded as JSON
+type fToString byte
+
+func (f fToString) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if str, err := stringInput("tostring", in); err == nil {
+		return str, nil
+	}
+	return jsonText(ctx, e, in)
+}
+
+// fToNumber converts its input to a number. numbers are unchanged & strings
+// are parsed
+type fToNumber byte
+
+func (f fToNumber) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+	if n, ok := toFloat(in); ok {
+		return n, nil
+	}
+	str, err := stringInput("tonumber", in)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil, fmt.Errorf("cannot parse %q as a number", str)
+	}
+	return n, nil
+}
+
+// fStringInterp is a string with embedded expressions. parts alternate
+// between literal text & filters whose outputs are converted to text the way
+// tostring does. expressions producing more than one value produce a string
+// for each combination of values
+type fStringInterp []filter
+
+func (f fStringInterp) apply(ctx context.Context, e *env, in interface{}) (out interface{}, err error) {
+	if vs, ok := in.(*valueStream); ok {
+		return applyToStream(ctx, e, vs, f)
+	}
+
+	strs := []string{""}
+	for i, part := range f {
+		if lit, ok := part.(fStringLiteral); ok && i%2 == 0 {
+			for j := range strs {
+				strs[j] += string(lit)
+			}
+			continue
+		}
+
+		res, err := e.eval(ctx, part, in)
+		if err != nil {
+			return nil, err
+		}
+		var texts []string
+		if err = eachOutput(res, func(v interface{}) error {
+			text, err := formatText(ctx, e, v)
+			texts = append(texts, text)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+
+		next := make([]string, 0, len(strs)*len(texts))
+		for _, s := range strs {
+			for _, text := range texts {
+				next = append(next, s+text)
+			}
+		}
+		strs = next
+	}
+
+	if len(strs) == 1 {
+		return strs[0], nil
+	}
+	return newStream(stringSlice(strs))
+}
@@ -1,15 +1,28 @@
 package filter
 
-// position of a token within the scan stream
+import "fmt"
+
+// position of a token within the scan stream. Line & Col count from 1,
+// Offset is the 0-based byte offset
 type position struct {
 	Line, Col, Offset int
 }
 
+// String formats a position for error messages
+func (p position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Col)
+}
+
 // token is a recognized token from the outline lexicon
 type token struct {
 	Type tokenType
 	Pos  position
 	Text string
+	// Spaced is true when whitespace separates the token from the one before
+	Spaced bool
+	// Parts holds the segments of an interpolated string, alternating
+	// between literal text & the source of embedded expressions
+	Parts []string
 }
 
 // String implements the stringer interface for token
@@ -31,6 +44,8 @@ const (
 	literalBegin
 	// tText is a token for arbitrary text
 	tText
+	// tString is a token for quoted text
+	tString
 	// tNumber is a number
 	tNumber
 	// tDot is the "." character
@@ -39,6 +54,18 @@ const (
 	tComma
 	// tColon is the ":" character
 	tColon
+	// tSemicolon is the ";" character, separating builtin arguments
+	tSemicolon
+	// tQuestion is the "?" character, suppressing errors
+	tQuestion
+	// tRecurse is "..", recursive descent
+	tRecurse
+	// tFormat is a format string name, like "@base64"
+	tFormat
+	// tInterpolation is a string literal with embedded \(expressions)
+	tInterpolation
+	// tVariable is a variable reference like $name. token text is the name
+	tVariable
 	// tPipe is the "|" character
 	tPipe
 	// tLeftBracket is the "[" character
@@ -61,6 +88,18 @@ const (
 	tStar
 	// tForwardSlash is the "/" character
 	tForwardSlash
+	// tEqual is the "==" operator
+	tEqual
+	// tNotEqual is the "!=" operator
+	tNotEqual
+	// tLess is the "<" operator
+	tLess
+	// tLessEqual is the "<=" operator
+	tLessEqual
+	// tGreater is the ">" operator
+	tGreater
+	// tGreaterEqual is the ">=" operator
+	tGreaterEqual
 	// literalEnd marks the end of literal tokens in the token enumeration
 	literalEnd
 
@@ -79,6 +118,8 @@ func (tt tokenType) String() string {
 
 	case tText:
 		return "Text"
+	case tString:
+		return "String"
 	case tNumber:
 		return "Number"
 	case tDot:
@@ -87,6 +128,18 @@ func (tt tokenType) String() string {
 		return ","
 	case tColon:
 		return ":"
+	case tSemicolon:
+		return ";"
+	case tQuestion:
+		return "?"
+	case tRecurse:
+		return ".."
+	case tFormat:
+		return "Format"
+	case tInterpolation:
+		return "Interpolation"
+	case tVariable:
+		return "Variable"
 	case tPipe:
 		return "|"
 
@@ -111,6 +164,18 @@ func (tt tokenType) String() string {
 		return "*"
 	case tForwardSlash:
 		return "/"
+	case tEqual:
+		return "=="
+	case tNotEqual:
+		return "!="
+	case tLess:
+		return "<"
+	case tLessEqual:
+		return "<="
+	case tGreater:
+		return ">"
+	case tGreaterEqual:
+		return ">="
 
 	case tLength:
 		return "length"
@@ -0,0 +1,248 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer observes filter evaluation, receiving a call before and after each
+// node of a parsed filter is applied to an input. Nodes are identified by a
+// short human-readable description. Tracers are called synchronously &
+// should return quickly
+type Tracer interface {
+	// Enter is called before a filter node is applied to an input
+	Enter(node string, in interface{})
+	// Exit is called after a filter node is applied, with the output & error
+	// the node produced
+	Exit(node string, in, out interface{}, err error)
+}
+
+// OptTracer sets a tracer on a filter
+func OptTracer(t Tracer) func(o *Options) {
+	return func(o *Options) {
+		o.Tracer = t
+	}
+}
+
+// describe gives a short description of a filter node for tracing
+func describe(f filter) string {
+	switch x := f.(type) {
+	case fIdentity:
+		return "."
+	case fKeySelector:
+		return "." + string(x)
+	case fOptionalKeySelector:
+		return "." + string(x) + "?"
+	case fIndexSelector:
+		return fmt.Sprintf("[%d]", int(x))
+	case *fIndexRangeSelector:
+		return fmt.Sprintf("[%d:%d]", x.start, x.stop)
+	case fIterateAllSeletor:
+		if x {
+			return "[]?"
+		}
+		return "[]"
+	case fGlobSelector:
+		return ".*"
+	case fRecurseDescent:
+		return ".."
+	case fStringLiteral:
+		return fmt.Sprintf("%q", string(x))
+	case fBoolLiteral:
+		return fmt.Sprintf("%t", bool(x))
+	case fNullLiteral:
+		return "null"
+	case fFormat:
+		return "@" + string(x)
+	case fMath:
+		return string(x)
+	case fVariable:
+		return "$" + string(x)
+	case fNumericLiteral:
+		return fmt.Sprintf("%v", float64(x))
+	case fBinaryOp:
+		return x.op.String()
+	case fSelector:
+		return "selector"
+	case fPipe:
+		return "|"
+	case fComma:
+		return ","
+	case fSlice:
+		return "[...]"
+	case fObjectMapping:
+		return "{...}"
+	}
+
+	// builtins are described by their name, derived from the type name
+	return strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", f), "filter.f"))
+}
+
+// NodeStats are evaluation metrics for a filter node
+type NodeStats struct {
+	// Node is the description of the filter node
+	Node string
+	// Count is the number of times the node was applied
+	Count int
+	// Total is the time spent applying the node, including time spent
+	// applying any nodes it contains
+	Total time.Duration
+}
+
+// Stats is a Tracer that collects evaluation metrics for each filter node.
+// Nodes with the same description are counted together. A filter applied
+// concurrently can share one Stats collector, each application of the filter
+// times its nodes separately
+type Stats struct {
+	lk     sync.Mutex
+	nodes  map[string]*NodeStats
+	starts []time.Time
+}
+
+var _ Tracer = (*Stats)(nil)
+
+// OptStats collects evaluation metrics into s when a filter is applied.
+// OptStats can be combined with OptTracer
+func OptStats(s *Stats) func(o *Options) {
+	return func(o *Options) {
+		if o.Tracer != nil {
+			o.Tracer = tracers{o.Tracer, s}
+			return
+		}
+		o.Tracer = s
+	}
+}
+
+// Enter starts timing a node
+func (s *Stats) Enter(node string, in interface{}) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.starts = append(s.starts, time.Now())
+}
+
+// Exit records the time spent in a node
+func (s *Stats) Exit(node string, in, out interface{}, err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	last := len(s.starts) - 1
+	if last < 0 {
+		return
+	}
+	dur := time.Since(s.starts[last])
+	s.starts = s.starts[:last]
+	s.record(node, dur)
+}
+
+// record adds one application of a node to the collected metrics. callers
+// must hold the lock
+func (s *Stats) record(node string, dur time.Duration) {
+	if s.nodes == nil {
+		s.nodes = map[string]*NodeStats{}
+	}
+	ns, ok := s.nodes[node]
+	if !ok {
+		ns = &NodeStats{Node: node}
+		s.nodes[node] = ns
+	}
+	ns.Count++
+	ns.Total += dur
+}
+
+// scope gives a single application of a filter its own timings, so
+// applications running at the same time don't end each other's nodes
+func (s *Stats) scope() Tracer {
+	return &statsScope{s: s}
+}
+
+// statsScope times the nodes of one application of a filter, recording
+// them into a shared Stats collector
+type statsScope struct {
+	s      *Stats
+	starts []time.Time
+}
+
+// Enter starts timing a node
+func (sc *statsScope) Enter(node string, in interface{}) {
+	sc.starts = append(sc.starts, time.Now())
+}
+
+// Exit records the time spent in a node
+func (sc *statsScope) Exit(node string, in, out interface{}, err error) {
+	last := len(sc.starts) - 1
+	if last < 0 {
+		return
+	}
+	dur := time.Since(sc.starts[last])
+	sc.starts = sc.starts[:last]
+
+	sc.s.lk.Lock()
+	defer sc.s.lk.Unlock()
+	sc.s.record(node, dur)
+}
+
+// Node returns the metrics for a node description
+func (s *Stats) Node(node string) NodeStats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if ns, ok := s.nodes[node]; ok {
+		return *ns
+	}
+	return NodeStats{Node: node}
+}
+
+// Nodes lists metrics for all nodes, most expensive first
+func (s *Stats) Nodes() []NodeStats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	nodes := make([]NodeStats, 0, len(s.nodes))
+	for _, ns := range s.nodes {
+		nodes = append(nodes, *ns)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Total == nodes[j].Total {
+			return nodes[i].Node < nodes[j].Node
+		}
+		return nodes[i].Total > nodes[j].Total
+	})
+	return nodes
+}
+
+// scoper is implemented by tracers that keep state for each application of
+// a filter
+type scoper interface {
+	scope() Tracer
+}
+
+// scopeTracer returns the tracer to use for one application of a filter
+func scopeTracer(t Tracer) Tracer {
+	if sc, ok := t.(scoper); ok {
+		return sc.scope()
+	}
+	return t
+}
+
+// tracers combines multiple tracers into one
+type tracers []Tracer
+
+func (ts tracers) scope() Tracer {
+	scoped := make(tracers, len(ts))
+	for i, t := range ts {
+		scoped[i] = scopeTracer(t)
+	}
+	return scoped
+}
+
+func (ts tracers) Enter(node string, in interface{}) {
+	for _, t := range ts {
+		t.Enter(node, in)
+	}
+}
+
+func (ts tracers) Exit(node string, in, out interface{}, err error) {
+	for _, t := range ts {
+		t.Exit(node, in, out, err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build go1.18
+// +build go1.18
+
+package value
+
+import (
+	"testing"
+)
+
+func FuzzValueRoundTrip(f *testing.F) {
+	seeds := []string{
+		`null`, `true`, `0`, `-0`, `1.5`, `1.0`, `1e3`, `-2.5E-3`, `99999999999999999999`,
+		`9007199254740993`, `"a"`, `"é\n"`, `[]`, `{}`, `[1,"a",null,[2.5]]`,
+		`{"a":{"b":[1,2,{"c":null}]},"d":"e"}`, `{"b":1,"a":2}`,
+	}
+	for _, s := range seeds {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := UnmarshalJSON(data)
+		if err != nil {
+			return
+		}
+		enc, err := MarshalJSON(v)
+		if err != nil {
+			t.Fatalf("marshaling %#v: %s", v, err)
+		}
+		got, err := UnmarshalJSON(enc)
+		if err != nil {
+			t.Fatalf("unmarshaling %s: %s", enc, err)
+		}
+		if !Equal(v, got) {
+			t.Errorf("round trip mismatch. input: %s\nfirst:  %#v\nsecond: %#v", data, v, got)
+		}
+	})
+}
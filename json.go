@@ -0,0 +1,234 @@
+package value
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+// UnmarshalJSON decodes JSON data into a value. JSON numbers written without
+// a fraction or exponent that fit in an int decode to int, all other numbers
+// decode to float64
+func UnmarshalJSON(data []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: unexpected data after top-level value")
+	}
+	return fromJSON(v)
+}
+
+// fromJSON converts values decoded by encoding/json with UseNumber set into
+// qri values
+func fromJSON(v interface{}) (Value, error) {
+	var err error
+	switch x := v.(type) {
+	case json.Number:
+		return jsonNumber(x)
+	case []interface{}:
+		for i, el := range x {
+			if x[i], err = fromJSON(el); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for key, el := range x {
+			if x[key], err = fromJSON(el); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func jsonNumber(n json.Number) (Value, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 0); err == nil {
+		return int(i), nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s: %w", n, err)
+	}
+	return f, nil
+}
+
+// MarshalJSON encodes a value as JSON. Map keys are written in sorted order.
+// []byte values encode as base64 strings. complex values are encoded as the
+// values they contain: Maps as objects, Arrays & Iterators as arrays, and
+// ByteReaders as strings. Resolved links
+// encode as the value they point to, unresolved links as their path string.
+// Iterators & ByteReaders are consumed & closed by encoding
+func MarshalJSON(v Value) ([]byte, error) {
+	v, err := toJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// toJSON converts a value into a form encoding/json can marshal
+func toJSON(v Value) (interface{}, error) {
+	switch x := v.(type) {
+	case nil, bool, uint8, int, string, []byte:
+		return v, nil
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, fmt.Errorf("cannot encode %v as JSON", x)
+		}
+		return v, nil
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, el := range x {
+			var err error
+			if arr[i], err = toJSON(el); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for key, el := range x {
+			var err error
+			if m[key], err = toJSON(el); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for key, el := range x {
+			var err error
+			if m[fmt.Sprintf("%v", key)], err = toJSON(el); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	if l, ok := v.(Link); ok {
+		if val, resolved := l.Value(); resolved {
+			return toJSON(val)
+		}
+		return l.Path(), nil
+	}
+	if m, ok := v.(Map); ok {
+		obj := map[string]interface{}{}
+		err := eachIteration(m.Iterate(), func(key, el Value) (err error) {
+			obj[fmt.Sprintf("%v", key)], err = toJSON(el)
+			return err
+		})
+		return obj, err
+	}
+	if a, ok := v.(Array); ok {
+		return toJSON(a.Iterate())
+	}
+	if it, ok := v.(Iterator); ok {
+		arr := []interface{}{}
+		err := eachIteration(it, func(_, el Value) error {
+			el, err := toJSON(el)
+			arr = append(arr, el)
+			return err
+		})
+		return arr, err
+	}
+	if r, ok := v.(ByteReader); ok {
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+
+	return nil, fmt.Errorf("cannot encode %T as JSON", v)
+}
+
+// eachIteration calls fn with the key & value of each iteration, closing the
+// iterator when finished
+func eachIteration(it Iterator, fn func(key, v Value) error) error {
+	for it.Next() {
+		var v Value
+		if err := it.Scan(&v); err != nil {
+			it.Close()
+			return err
+		}
+		if err := fn(it.Key(), v); err != nil {
+			it.Close()
+			return err
+		}
+	}
+	return it.Close()
+}
+
+// jsonStream iterates the elements of a JSON array as they're decoded
+type jsonStream struct {
+	r   io.Reader
+	dec *json.Decoder
+	i   int
+	val Value
+	err error
+}
+
+// NewJSONStream creates an iterator over the elements of a top-level JSON
+// array, decoding one element at a time as the iterator advances so large
+// arrays are never held in memory whole. numbers decode as they do with
+// UnmarshalJSON. NewJSONStream reads the opening bracket of the array,
+// erroring if the stream doesn't start with one. decoding errors stop
+// iteration & are returned by Close, which also closes r if it's an
+// io.Closer
+func NewJSONStream(r io.Reader) (Iterator, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if t != json.Delim('[') {
+		return nil, fmt.Errorf("invalid JSON stream: expected array, got %v", t)
+	}
+	return &jsonStream{r: r, dec: dec, i: -1}, nil
+}
+
+// Next decodes the next element, returning false at the end of the array or
+// if decoding fails
+func (s *jsonStream) Next() bool {
+	if s.err != nil || !s.dec.More() {
+		return false
+	}
+
+	var v interface{}
+	if s.err = s.dec.Decode(&v); s.err != nil {
+		return false
+	}
+	if s.val, s.err = fromJSON(v); s.err != nil {
+		return false
+	}
+	s.i++
+	return true
+}
+
+// Scan reads the current element into dest
+func (s *jsonStream) Scan(dest Value) error { return scanValue(dest, s.val) }
+
+// Key returns the index of the current element
+func (s *jsonStream) Key() interface{} { return s.i }
+
+// Close releases the stream, returning any error encountered decoding
+func (s *jsonStream) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		if err := c.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// IsOrdered returns true, elements are iterated in the order they're written
+func (s *jsonStream) IsOrdered() bool { return true }
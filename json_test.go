@@ -0,0 +1,153 @@
+package value
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect Value
+	}{
+		{`null`, nil},
+		{`1`, 1},
+		{`-1`, -1},
+		{`1.5`, 1.5},
+		{`1.0`, float64(1)},
+		{`1e3`, float64(1000)},
+		{`99999999999999999999`, float64(99999999999999999999)},
+		{`"a"`, "a"},
+		{`[1,"a",[2.5]]`, []interface{}{1, "a", []interface{}{2.5}}},
+		{`{"a":{"b":1}}`, map[string]interface{}{"a": map[string]interface{}{"b": 1}}},
+	}
+
+	for _, c := range cases {
+		got, err := UnmarshalJSON([]byte(c.in))
+		if err != nil {
+			t.Errorf("%s unexpected error: %s", c.in, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("%s result mismatch (-want +got):\n%s", c.in, diff)
+		}
+	}
+
+	for _, bad := range []string{``, `{`, `[1] [2]`, `1e999`} {
+		if _, err := UnmarshalJSON([]byte(bad)); err == nil {
+			t.Errorf("%q expected error, got nil", bad)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	cases := []struct {
+		in     Value
+		expect string
+	}{
+		{nil, `null`},
+		{1, `1`},
+		{1.5, `1.5`},
+		{map[string]interface{}{"b": 1, "a": 2}, `{"a":2,"b":1}`},
+		{map[interface{}]interface{}{"b": 1, 2: "a"}, `{"2":"a","b":1}`},
+		{NewIterator([]Value{1, "a"}), `[1,"a"]`},
+		{NewLink("/path"), `"/path"`},
+		{NewResolvedLink("/path", []interface{}{true}), `[true]`},
+		{ioutil.NopCloser(bytes.NewBufferString("hello")), `"hello"`},
+	}
+
+	for _, c := range cases {
+		got, err := MarshalJSON(c.in)
+		if err != nil {
+			t.Errorf("%#v unexpected error: %s", c.in, err)
+			continue
+		}
+		if c.expect != string(got) {
+			t.Errorf("%#v result mismatch. want: %s, got: %s", c.in, c.expect, got)
+		}
+	}
+
+	for _, bad := range []Value{math.NaN(), math.Inf(1), struct{}{}} {
+		if _, err := MarshalJSON(bad); err == nil {
+			t.Errorf("%#v expected error, got nil", bad)
+		}
+	}
+}
+
+// failingReader errors on every read
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("read past the end") }
+
+func TestJSONStream(t *testing.T) {
+	it, err := NewJSONStream(strings.NewReader(`[1, 2.5, "three", {"four": [4]}, null]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Value
+	for it.Next() {
+		if it.Key() != len(got) {
+			t.Errorf("expected key %d, got %v", len(got), it.Key())
+		}
+		var v Value
+		if err := it.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expect := []Value{1, 2.5, "three", map[string]interface{}{"four": []interface{}{4}}, nil}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	// elements are decoded one at a time, the stream errors only when
+	// iteration reaches the failing reader
+	it, err = NewJSONStream(io.MultiReader(strings.NewReader(`[{"a": 1}, {"a": 2}, `), failingReader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if !it.Next() {
+			t.Fatalf("expected element %d to decode, got error: %v", i, it.Close())
+		}
+		var v Value
+		if err := it.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]interface{}{"a": i}, v); diff != "" {
+			t.Errorf("element %d mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+	if it.Next() {
+		t.Errorf("expected iteration to stop at the read error")
+	}
+	if err := it.Close(); err == nil {
+		t.Errorf("expected close to report the read error")
+	}
+
+	for _, s := range []string{`{"a": 1}`, `1`, ``} {
+		if _, err := NewJSONStream(strings.NewReader(s)); err == nil {
+			t.Errorf("expected stream of %q to error", s)
+		}
+	}
+
+	it, err = NewJSONStream(strings.NewReader(`[1, }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+	}
+	if err := it.Close(); err == nil {
+		t.Errorf("expected invalid JSON to error on close")
+	}
+}
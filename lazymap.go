@@ -0,0 +1,89 @@
+package value
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lister is an optional interface for resolvers that can list the keys
+// stored beneath a path
+type Lister interface {
+	List(ctx context.Context, path string) (keys []string, err error)
+}
+
+// lazyMap is a Map that resolves values through a resolver as they're
+// requested
+type lazyMap struct {
+	resolver Resolver
+	root     string
+}
+
+// NewLazyMap creates a Map backed by a resolver. ValueForKey resolves a link
+// to rootPath + "/" + key, so a large object in a content store can be
+// traversed key-by-key without loading it whole. values are whatever the
+// resolver returns, resolvers serving nested objects can return lazy maps for
+// sub-paths. Iterate requires the resolver to implement Lister, iterating a
+// lazy map with any other resolver returns the error from Close
+func NewLazyMap(resolver Resolver, rootPath string) Map {
+	return &lazyMap{
+		resolver: resolver,
+		root:     strings.TrimSuffix(rootPath, "/"),
+	}
+}
+
+// ValueForKey resolves the value stored at a key
+func (m *lazyMap) ValueForKey(key interface{}) (Value, error) {
+	return m.resolver.Resolve(context.Background(), NewLink(m.path(key)))
+}
+
+func (m *lazyMap) path(key interface{}) string {
+	return fmt.Sprintf("%s/%v", m.root, key)
+}
+
+// Iterate lists the keys of the map, resolving each value as the iterator
+// advances. keys are iterated in sorted order
+func (m *lazyMap) Iterate() Iterator {
+	lister, ok := m.resolver.(Lister)
+	if !ok {
+		return &lazyMapIterator{i: -1, err: fmt.Errorf("cannot iterate lazy map: resolver doesn't list keys")}
+	}
+
+	keys, err := lister.List(context.Background(), m.root)
+	sort.Strings(keys)
+	return &lazyMapIterator{m: m, keys: keys, i: -1, err: err}
+}
+
+// lazyMapIterator iterates the keys of a lazyMap
+type lazyMapIterator struct {
+	m    *lazyMap
+	keys []string
+	i    int
+	val  Value
+	err  error
+}
+
+// Next advances the iterator, resolving the next value. Next returns false
+// when no keys remain or a value fails to resolve
+func (it *lazyMapIterator) Next() bool {
+	if it.err != nil || it.i >= len(it.keys)-1 {
+		return false
+	}
+	it.i++
+	it.val, it.err = it.m.ValueForKey(it.keys[it.i])
+	return it.err == nil
+}
+
+// Scan reads the current iteration value into dest
+func (it *lazyMapIterator) Scan(dest Value) error { return scanValue(dest, it.val) }
+
+// Key returns the current key
+func (it *lazyMapIterator) Key() interface{} { return it.keys[it.i] }
+
+// Close returns any error encountered while listing keys or resolving
+// values
+func (it *lazyMapIterator) Close() error { return it.err }
+
+// IsOrdered returns true, keys are iterated in sorted order
+func (it *lazyMapIterator) IsOrdered() bool { return true }
@@ -0,0 +1,129 @@
+package value
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeStore serves values from a map of paths. paths without a value of
+// their own that prefix other paths resolve to lazy maps
+type fakeStore struct {
+	vals     map[string]Value
+	resolved []string
+}
+
+func (s *fakeStore) Resolve(ctx context.Context, l Link) (Value, error) {
+	s.resolved = append(s.resolved, l.Path())
+	if v, ok := s.vals[l.Path()]; ok {
+		return v, nil
+	}
+	for p := range s.vals {
+		if strings.HasPrefix(p, l.Path()+"/") {
+			return NewLazyMap(s, l.Path()), nil
+		}
+	}
+	return nil, fmt.Errorf("not found: %s", l.Path())
+}
+
+// listingStore is a fakeStore that can list keys
+type listingStore struct {
+	*fakeStore
+}
+
+func (s listingStore) List(ctx context.Context, path string) (keys []string, err error) {
+	seen := map[string]bool{}
+	for p := range s.vals {
+		if rest := strings.TrimPrefix(p, path+"/"); rest != p {
+			key := strings.Split(rest, "/")[0]
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{vals: map[string]Value{
+		"/root/name":          "big object",
+		"/root/count":         3,
+		"/root/nested/a":      "apple",
+		"/root/nested/deep/b": "banana",
+	}}
+}
+
+func TestLazyMap(t *testing.T) {
+	store := newFakeStore()
+	m := NewLazyMap(store, "/root/")
+
+	v, err := m.ValueForKey("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "big object" {
+		t.Errorf("expected 'big object', got %v", v)
+	}
+
+	nested, err := m.ValueForKey("nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deep, err := nested.(Map).ValueForKey("deep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err = deep.(Map).ValueForKey("b"); err != nil {
+		t.Fatal(err)
+	}
+	if v != "banana" {
+		t.Errorf("expected 'banana', got %v", v)
+	}
+
+	// only requested paths are resolved
+	expect := []string{"/root/name", "/root/nested", "/root/nested/deep", "/root/nested/deep/b"}
+	if diff := cmp.Diff(expect, store.resolved); diff != "" {
+		t.Errorf("resolved paths mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err = m.ValueForKey("missing"); err == nil {
+		t.Errorf("expected resolving a missing key to error")
+	}
+
+	it := m.Iterate()
+	if it.Next() {
+		t.Errorf("expected iterating without a Lister to produce no values")
+	}
+	if err := it.Close(); err == nil {
+		t.Errorf("expected iterating without a Lister to error")
+	}
+}
+
+func TestLazyMapIterate(t *testing.T) {
+	m := NewLazyMap(listingStore{newFakeStore()}, "/root")
+
+	var keys []interface{}
+	it := m.Iterate()
+	for it.Next() {
+		keys = append(keys, it.Key())
+		if it.Key() == "count" {
+			var v Value
+			if err := it.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			if v != 3 {
+				t.Errorf("expected count to be 3, got %v", v)
+			}
+		}
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{"count", "name", "nested"}, keys); diff != "" {
+		t.Errorf("keys mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,73 @@
+package value
+
+import "fmt"
+
+// orderedMap is a Map that keeps keys in insertion order
+type orderedMap struct {
+	keys  []interface{}
+	vals  []Value
+	index map[interface{}]int
+}
+
+// NewMap creates a Map from parallel slices of keys & values. keys iterate in
+// the order they're given, & must be comparable. when a key is repeated the
+// last value wins, keeping the position of the first. NewMap panics if keys
+// and vals differ in length
+func NewMap(keys []interface{}, vals []Value) Map {
+	if len(keys) != len(vals) {
+		panic(fmt.Sprintf("value.NewMap: %d keys for %d values", len(keys), len(vals)))
+	}
+
+	m := &orderedMap{index: make(map[interface{}]int, len(keys))}
+	for i, key := range keys {
+		if j, ok := m.index[key]; ok {
+			m.vals[j] = vals[i]
+			continue
+		}
+		m.index[key] = len(m.keys)
+		m.keys = append(m.keys, key)
+		m.vals = append(m.vals, vals[i])
+	}
+	return m
+}
+
+// ValueForKey looks up the value stored at a key, returning an error if the
+// map doesn't contain the key
+func (m *orderedMap) ValueForKey(key interface{}) (Value, error) {
+	if i, ok := m.index[key]; ok {
+		return m.vals[i], nil
+	}
+	return nil, fmt.Errorf("key not found: %v", key)
+}
+
+// Iterate returns an iterator over the map's values in insertion order
+func (m *orderedMap) Iterate() Iterator {
+	return &orderedMapIterator{m: m, i: -1}
+}
+
+// orderedMapIterator iterates the values of an orderedMap
+type orderedMapIterator struct {
+	m *orderedMap
+	i int
+}
+
+// Next advances the iterator, returning false if no iterations remain
+func (it *orderedMapIterator) Next() bool {
+	if it.i >= len(it.m.keys)-1 {
+		return false
+	}
+	it.i++
+	return true
+}
+
+// Scan reads the current iteration value into dest
+func (it *orderedMapIterator) Scan(dest Value) error { return scanValue(dest, it.m.vals[it.i]) }
+
+// Key returns the current key
+func (it *orderedMapIterator) Key() interface{} { return it.m.keys[it.i] }
+
+// Close terminates the iterator
+func (it *orderedMapIterator) Close() error { return nil }
+
+// IsOrdered returns true, keys are iterated in insertion order
+func (it *orderedMapIterator) IsOrdered() bool { return true }
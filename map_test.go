@@ -0,0 +1,73 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewMap(t *testing.T) {
+	m := NewMap([]interface{}{"b", "a", 3, "b"}, []Value{1, "apple", true, 2})
+
+	cases := []struct {
+		key    interface{}
+		expect Value
+	}{
+		{"a", "apple"},
+		{"b", 2},
+		{3, true},
+	}
+	for _, c := range cases {
+		v, err := m.ValueForKey(c.key)
+		if err != nil {
+			t.Errorf("key %v: %s", c.key, err)
+			continue
+		}
+		if v != c.expect {
+			t.Errorf("key %v: expected %v, got %v", c.key, c.expect, v)
+		}
+	}
+
+	if _, err := m.ValueForKey("missing"); err == nil {
+		t.Errorf("expected looking up a missing key to error")
+	}
+	if _, err := m.ValueForKey("3"); err == nil {
+		t.Errorf("expected keys to be matched by type")
+	}
+
+	var keys, vals []interface{}
+	it := m.Iterate()
+	if !it.IsOrdered() {
+		t.Errorf("expected map iteration to be ordered")
+	}
+	for it.Next() {
+		var v Value
+		if err := it.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, it.Key())
+		vals = append(vals, v)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{"b", "a", 3}, keys); diff != "" {
+		t.Errorf("keys mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]interface{}{2, "apple", true}, vals); diff != "" {
+		t.Errorf("values mismatch (-want +got):\n%s", diff)
+	}
+
+	if it := NewMap(nil, nil).Iterate(); it.Next() {
+		t.Errorf("expected an empty map to produce no values")
+	}
+}
+
+func TestNewMapLengthMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected mismatched keys & values to panic")
+		}
+	}()
+	NewMap([]interface{}{"a"}, nil)
+}
@@ -0,0 +1,187 @@
+package value
+
+// Pipeline composes lazy transformations of an iterator. Each stage of a
+// pipeline wraps the iterator of the previous stage, and no values are read
+// from the source until the pipeline is driven by Collect, ForEach, or by
+// advancing the iterator it produces
+type Pipeline struct {
+	it Iterator
+}
+
+// Pipe starts a pipeline from a source iterator
+func Pipe(src Iterator) *Pipeline {
+	return &Pipeline{it: src}
+}
+
+// Filter drops values for which pred returns false
+func (p *Pipeline) Filter(pred func(v Value) (bool, error)) *Pipeline {
+	return &Pipeline{it: NewFilterIterator(p.it, pred)}
+}
+
+// Map replaces each value with the result of fn
+func (p *Pipeline) Map(fn func(v Value) (Value, error)) *Pipeline {
+	return &Pipeline{it: NewMapIterator(p.it, fn)}
+}
+
+// Limit stops the pipeline after n values
+func (p *Pipeline) Limit(n int) *Pipeline {
+	return &Pipeline{it: NewLimitIterator(p.it, n)}
+}
+
+// Iterator returns the pipeline as an iterator. Callers must close the
+// returned iterator
+func (p *Pipeline) Iterator() Iterator {
+	return p.it
+}
+
+// ForEach drives the pipeline, calling fn with each value. Returning an
+// error from fn stops iteration. ForEach closes the pipeline when finished
+func (p *Pipeline) ForEach(fn func(v Value) error) error {
+	return eachIteration(p.it, func(_, v Value) error {
+		return fn(v)
+	})
+}
+
+// Collect drives the pipeline, returning all values it produces as a slice
+func (p *Pipeline) Collect() ([]Value, error) {
+	vals := []Value{}
+	err := p.ForEach(func(v Value) error {
+		vals = append(vals, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// filterIterator lazily skips values of a source iterator
+type filterIterator struct {
+	src  Iterator
+	pred func(v Value) (bool, error)
+	val  Value
+	err  error
+}
+
+// NewFilterIterator creates an iterator that yields only values of src for
+// which pred returns true. An error from pred ends iteration, and is returned
+// by Close
+func NewFilterIterator(src Iterator, pred func(v Value) (bool, error)) Iterator {
+	return &filterIterator{src: src, pred: pred}
+}
+
+// Next advances the iterator to the next value pred accepts
+func (it *filterIterator) Next() bool {
+	for it.err == nil && it.src.Next() {
+		var v Value
+		if it.err = it.src.Scan(&v); it.err != nil {
+			return false
+		}
+		var ok bool
+		if ok, it.err = it.pred(v); it.err != nil {
+			return false
+		}
+		if ok {
+			it.val = v
+			return true
+		}
+	}
+	return false
+}
+
+// Scan reads the current iteration value into dest
+func (it *filterIterator) Scan(dest Value) error { return scanValue(dest, it.val) }
+
+// Key returns the key of the current value in the source iterator
+func (it *filterIterator) Key() interface{} { return it.src.Key() }
+
+// Close closes the source iterator
+func (it *filterIterator) Close() error { return closeWithErr(it.src, it.err) }
+
+// IsOrdered returns true if the source iterator is ordered
+func (it *filterIterator) IsOrdered() bool { return it.src.IsOrdered() }
+
+// mapIterator lazily transforms values of a source iterator
+type mapIterator struct {
+	src Iterator
+	fn  func(v Value) (Value, error)
+	val Value
+	err error
+}
+
+// NewMapIterator creates an iterator that yields the result of calling fn
+// on each value of src. An error from fn ends iteration, and is returned by
+// Close
+func NewMapIterator(src Iterator, fn func(v Value) (Value, error)) Iterator {
+	return &mapIterator{src: src, fn: fn}
+}
+
+// Next advances the iterator, returning false if no iterations remain
+func (it *mapIterator) Next() bool {
+	if it.err != nil || !it.src.Next() {
+		return false
+	}
+	var v Value
+	if it.err = it.src.Scan(&v); it.err != nil {
+		return false
+	}
+	if it.val, it.err = it.fn(v); it.err != nil {
+		return false
+	}
+	return true
+}
+
+// Scan reads the current iteration value into dest
+func (it *mapIterator) Scan(dest Value) error { return scanValue(dest, it.val) }
+
+// Key returns the key of the current value in the source iterator
+func (it *mapIterator) Key() interface{} { return it.src.Key() }
+
+// Close closes the source iterator
+func (it *mapIterator) Close() error { return closeWithErr(it.src, it.err) }
+
+// IsOrdered returns true if the source iterator is ordered
+func (it *mapIterator) IsOrdered() bool { return it.src.IsOrdered() }
+
+// limitIterator stops a source iterator after a number of values
+type limitIterator struct {
+	src       Iterator
+	remaining int
+}
+
+// NewLimitIterator creates an iterator that yields at most n values of src.
+// src is never advanced past the nth value
+func NewLimitIterator(src Iterator, n int) Iterator {
+	return &limitIterator{src: src, remaining: n}
+}
+
+// Next advances the iterator, returning false if no iterations remain
+func (it *limitIterator) Next() bool {
+	if it.remaining <= 0 {
+		return false
+	}
+	it.remaining--
+	return it.src.Next()
+}
+
+// Scan reads the current iteration value into dest
+func (it *limitIterator) Scan(dest Value) error { return it.src.Scan(dest) }
+
+// Key returns the current iteration key
+func (it *limitIterator) Key() interface{} { return it.src.Key() }
+
+// Close closes the source iterator
+func (it *limitIterator) Close() error { return it.src.Close() }
+
+// IsOrdered returns true if the source iterator is ordered
+func (it *limitIterator) IsOrdered() bool { return it.src.IsOrdered() }
+
+// closeWithErr closes an iterator, preferring err over any error returned
+// by closing
+func closeWithErr(it Iterator, err error) error {
+	closeErr := it.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
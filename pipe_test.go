@@ -0,0 +1,99 @@
+package value
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingIterator records how many values are read from it, and if it's
+// been closed
+type countingIterator struct {
+	Iterator
+	scanned int
+	closed  bool
+}
+
+func (it *countingIterator) Scan(dest Value) error {
+	it.scanned++
+	return it.Iterator.Scan(dest)
+}
+
+func (it *countingIterator) Close() error {
+	it.closed = true
+	return it.Iterator.Close()
+}
+
+func TestPipe(t *testing.T) {
+	src := &countingIterator{Iterator: NewIterator([]Value{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})}
+	isEven := func(v Value) (bool, error) { return v.(int)%2 == 0, nil }
+	square := func(v Value) (Value, error) { return v.(int) * v.(int), nil }
+
+	p := Pipe(src).Filter(isEven).Map(square).Limit(2)
+	if src.scanned != 0 {
+		t.Errorf("expected building a pipeline not to read from source. scanned %d", src.scanned)
+	}
+
+	got, err := p.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]Value{4, 16}, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	if src.scanned != 4 {
+		t.Errorf("expected pipeline to lazily read 4 values, read %d", src.scanned)
+	}
+	if !src.closed {
+		t.Errorf("expected source to be closed")
+	}
+}
+
+func TestPipeErrors(t *testing.T) {
+	boom := fmt.Errorf("boom")
+
+	src := &countingIterator{Iterator: NewIterator([]Value{1, 2, 3})}
+	_, err := Pipe(src).Map(func(v Value) (Value, error) {
+		if v.(int) == 2 {
+			return nil, boom
+		}
+		return v, nil
+	}).Collect()
+	if err != boom {
+		t.Errorf("expected map error to be returned. got: %v", err)
+	}
+	if !src.closed {
+		t.Errorf("expected source to be closed after error")
+	}
+
+	src = &countingIterator{Iterator: NewIterator([]Value{1, 2, 3})}
+	_, err = Pipe(src).Filter(func(v Value) (bool, error) { return false, boom }).Collect()
+	if err != boom {
+		t.Errorf("expected filter error to be returned. got: %v", err)
+	}
+
+	src = &countingIterator{Iterator: NewIterator([]Value{1, 2, 3})}
+	err = Pipe(src).ForEach(func(v Value) error { return boom })
+	if err != boom {
+		t.Errorf("expected ForEach error to be returned. got: %v", err)
+	}
+	if src.scanned != 1 || !src.closed {
+		t.Errorf("expected ForEach error to stop & close iteration")
+	}
+}
+
+func TestPipeKeys(t *testing.T) {
+	it := Pipe(NewIterator([]Value{"a", "b", "c"})).
+		Filter(func(v Value) (bool, error) { return v != "a", nil }).
+		Iterator()
+	defer it.Close()
+
+	keys := []interface{}{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if diff := cmp.Diff([]interface{}{1, 2}, keys); diff != "" {
+		t.Errorf("expected keys of source iterator (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,244 @@
+package value
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaError describes a place a value doesn't match a schema. Path is the
+// location of the mismatch in jq path syntax, like .a.b[0]
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+// Error implements the error interface
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidateSchema checks a value against a JSON Schema, returning false and a
+// list of SchemaErrors if the value doesn't match. Only a subset of JSON
+// Schema is supported:
+//   - type: a type name or list of type names. "integer" matches whole numbers
+//   - enum: a list of allowed values
+//   - required: a list of keys an object must have
+//   - properties: a map of key to schema for object values
+//   - items: a schema every array element must match
+//   - minimum, maximum: inclusive bounds for numbers
+//   - minLength, maxLength: bounds on the length of strings
+//   - minItems, maxItems: bounds on the length of arrays
+//
+// other keywords are ignored. Complex values are materialized before checking,
+// which consumes Iterators & ByteReaders
+func ValidateSchema(v Value, schema Value) (bool, []error) {
+	v, err := toJSON(v)
+	if err != nil {
+		return false, []error{err}
+	}
+	s, err := toJSON(schema)
+	if err != nil {
+		return false, []error{fmt.Errorf("invalid schema: %s", err)}
+	}
+
+	errs := validateSchema(".", v, s)
+	return len(errs) == 0, errs
+}
+
+func validateSchema(path string, v, schema Value) (errs []error) {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			errs = append(errs, SchemaError{path, "schema forbids any value"})
+		}
+		return errs
+	}
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return []error{SchemaError{path, fmt.Sprintf("invalid schema: expected object, got %s", schemaTypeName(schema))}}
+	}
+	mismatch := func(format string, args ...interface{}) {
+		errs = append(errs, SchemaError{path, fmt.Sprintf(format, args...)})
+	}
+
+	if t, ok := s["type"]; ok {
+		var types []string
+		switch x := t.(type) {
+		case string:
+			types = []string{x}
+		case []interface{}:
+			for _, el := range x {
+				name, ok := el.(string)
+				if !ok {
+					mismatch("invalid schema: type must be a string or list of strings")
+					return errs
+				}
+				types = append(types, name)
+			}
+		default:
+			mismatch("invalid schema: type must be a string or list of strings")
+			return errs
+		}
+		if !matchesType(v, types) {
+			mismatch("expected %s, got %s", strings.Join(types, " or "), schemaTypeName(v))
+			// further checks on a value of the wrong type only add noise
+			return errs
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, el := range enum {
+			if reflect.DeepEqual(numbersToFloat(el), numbersToFloat(v)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			mismatch("value is not one of the allowed enum values")
+		}
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, key := range required {
+				if k, ok := key.(string); ok {
+					if _, ok := x[k]; !ok {
+						mismatch("missing required key %q", k)
+					}
+				}
+			}
+		}
+		if props, ok := s["properties"].(map[string]interface{}); ok {
+			keys := make([]string, 0, len(props))
+			for key := range props {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if el, ok := x[key]; ok {
+					errs = append(errs, validateSchema(joinSchemaPath(path, "."+key), el, props[key])...)
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"]; ok {
+			for i, el := range x {
+				errs = append(errs, validateSchema(joinSchemaPath(path, fmt.Sprintf("[%d]", i)), el, items)...)
+			}
+		}
+		if min, ok := schemaNumber(s["minItems"]); ok && float64(len(x)) < min {
+			mismatch("expected at least %v items, got %d", min, len(x))
+		}
+		if max, ok := schemaNumber(s["maxItems"]); ok && float64(len(x)) > max {
+			mismatch("expected at most %v items, got %d", max, len(x))
+		}
+	case string, []byte:
+		// lengths count characters, not bytes
+		n := utf8.RuneCountInString(fmt.Sprintf("%s", x))
+		if min, ok := schemaNumber(s["minLength"]); ok && float64(n) < min {
+			mismatch("expected length of at least %v, got %d", min, n)
+		}
+		if max, ok := schemaNumber(s["maxLength"]); ok && float64(n) > max {
+			mismatch("expected length of at most %v, got %d", max, n)
+		}
+	default:
+		if num, ok := schemaNumber(v); ok {
+			if min, ok := schemaNumber(s["minimum"]); ok && num < min {
+				mismatch("expected a minimum of %v, got %v", min, num)
+			}
+			if max, ok := schemaNumber(s["maximum"]); ok && num > max {
+				mismatch("expected a maximum of %v, got %v", max, num)
+			}
+		}
+	}
+
+	return errs
+}
+
+// joinSchemaPath adds an element to a path, dropping the root "."
+func joinSchemaPath(path, el string) string {
+	if path == "." {
+		return el
+	}
+	return path + el
+}
+
+// matchesType reports if a value is any of a list of JSON Schema types
+func matchesType(v Value, types []string) bool {
+	name := schemaTypeName(v)
+	for _, t := range types {
+		if t == name {
+			return true
+		}
+		if t == "integer" && name == "number" {
+			if n, _ := schemaNumber(v); n == math.Trunc(n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaTypeName gives the JSON Schema type name for a JSON value. bytes are
+// numbers & byte slices are strings
+func schemaTypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case uint8, int, float64:
+		return "number"
+	case string, []byte:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// schemaNumber converts numeric values to a float64
+func schemaNumber(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case uint8:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// numbersToFloat converts all numbers & byte slices within a JSON value to
+// float64s & strings, so equal values compare as equal regardless of
+// representation
+func numbersToFloat(v Value) Value {
+	switch x := v.(type) {
+	case []byte:
+		return string(x)
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, el := range x {
+			arr[i] = numbersToFloat(el)
+		}
+		return arr
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for key, el := range x {
+			m[key] = numbersToFloat(el)
+		}
+		return m
+	}
+	if n, ok := schemaNumber(v); ok {
+		return n
+	}
+	return v
+}
@@ -0,0 +1,94 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateSchema(t *testing.T) {
+	schema := mustUnmarshal(t, `{
+		"type": "object",
+		"required": ["name", "status"],
+		"properties": {
+			"name": { "type": "string", "minLength": 1 },
+			"age": { "type": "integer", "minimum": 0, "maximum": 150 },
+			"status": { "enum": ["active", "inactive"] },
+			"tags": { "type": "array", "items": { "type": "string" }, "maxItems": 2 }
+		}
+	}`)
+
+	cases := []struct {
+		in     string
+		errors []string
+	}{
+		{`{"name":"a","status":"active"}`, nil},
+		{`{"name":"a","age":30,"status":"inactive","tags":["x","y"]}`, nil},
+		{`{"name":"a","age":30.0,"status":"active"}`, nil},
+		{`[]`, []string{`.: expected object, got array`}},
+		{`{"name":5,"status":"active"}`, []string{`.name: expected string, got number`}},
+		{`{"name":"a"}`, []string{`.: missing required key "status"`}},
+		{`{"name":"a","status":"deleted"}`, []string{`.status: value is not one of the allowed enum values`}},
+		{`{"name":"","age":1.5,"status":"active"}`, []string{
+			`.age: expected integer, got number`,
+			`.name: expected length of at least 1, got 0`,
+		}},
+		{`{"name":"a","age":200,"status":"active"}`, []string{`.age: expected a maximum of 150, got 200`}},
+		{`{"name":"a","status":"active","tags":["x",2,"z"]}`, []string{
+			`.tags[1]: expected string, got number`,
+			`.tags: expected at most 2 items, got 3`,
+		}},
+	}
+
+	for _, c := range cases {
+		ok, errs := ValidateSchema(mustUnmarshal(t, c.in), schema)
+		if ok != (len(c.errors) == 0) {
+			t.Errorf("%s: expected valid to be %t", c.in, len(c.errors) == 0)
+		}
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if diff := cmp.Diff(c.errors, got); diff != "" {
+			t.Errorf("%s errors mismatch (-want +got):\n%s", c.in, diff)
+		}
+	}
+}
+
+func TestValidateSchemaTypes(t *testing.T) {
+	cases := []struct {
+		v      Value
+		schema string
+		expect bool
+	}{
+		{nil, `{"type":"null"}`, true},
+		{true, `{"type":"boolean"}`, true},
+		{byte(1), `{"type":"integer"}`, true},
+		{[]byte("abc"), `{"type":"string","maxLength":3}`, true},
+		{"é", `{"type":"string","maxLength":1}`, true},
+		{"日本語", `{"type":"string","minLength":3,"maxLength":3}`, true},
+		{[]byte("héllo"), `{"type":"string","maxLength":5}`, true},
+		{"éé", `{"type":"string","maxLength":1}`, false},
+		{1, `{"type":["string","null"]}`, false},
+		{NewIterator([]Value{1, 2}), `{"type":"array","items":{"type":"number"}}`, true},
+		{1, `{"enum":[1.0]}`, true},
+		{"a", `true`, true},
+		{"a", `false`, false},
+		{"a", `{"type":1}`, false},
+	}
+
+	for _, c := range cases {
+		if got, _ := ValidateSchema(c.v, mustUnmarshal(t, c.schema)); got != c.expect {
+			t.Errorf("%#v against %s: expected %t", c.v, c.schema, c.expect)
+		}
+	}
+}
+
+func mustUnmarshal(t *testing.T, s string) Value {
+	t.Helper()
+	v, err := UnmarshalJSON([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
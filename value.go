@@ -153,6 +153,11 @@ func (it *iterator) Next() bool {
 
 // Scan reads the current iteration value into dest
 func (it *iterator) Scan(dest Value) error {
+	return scanValue(dest, it.values[it.i])
+}
+
+// scanValue assigns val to the value dest points to
+func scanValue(dest, val Value) error {
 	v := reflect.ValueOf(dest)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -161,10 +166,10 @@ func (it *iterator) Scan(dest Value) error {
 		return fmt.Errorf("expected pointer value for scan")
 	}
 
-	if it.values[it.i] == nil {
+	if val == nil {
 		v.Set(reflect.Zero(v.Type()))
 	} else {
-		v.Set(reflect.ValueOf(it.values[it.i]))
+		v.Set(reflect.ValueOf(val))
 	}
 	return nil
 }
@@ -0,0 +1,138 @@
+package value
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SkipChildren is returned by a WalkFunc to skip the children of the value
+// it was called with. the walk continues with the value's siblings
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is called for each value visited by Walk, with the path of keys &
+// indexes from the root to the value. returning an error other than
+// SkipChildren stops the walk
+type WalkFunc func(path []interface{}, v Value) error
+
+// Walk visits a value and every value nested within it depth-first, parents
+// before children. the root is visited with an empty path. object keys are
+// visited in sorted order, Map values in the order they iterate. resolved
+// links are followed to their values, unresolved links are visited as-is.
+// Iterators are visited but not descended into, walking them would consume
+// them. errors returned by visit are returned by Walk, except SkipChildren
+func Walk(v Value, visit WalkFunc) error {
+	return WalkResolve(context.Background(), nil, v, visit)
+}
+
+// WalkResolve is Walk, resolving unresolved links with a resolver. resolved
+// values are cached on their links. links that point back to a link being
+// walked are visited as-is instead of followed
+func WalkResolve(ctx context.Context, r Resolver, v Value, visit WalkFunc) error {
+	w := &walker{ctx: ctx, r: r, visit: visit, resolving: map[string]bool{}}
+	if err := w.walk(nil, v); err != nil && err != SkipChildren {
+		return err
+	}
+	return nil
+}
+
+type walker struct {
+	ctx       context.Context
+	r         Resolver
+	visit     WalkFunc
+	resolving map[string]bool
+}
+
+func (w *walker) walk(path []interface{}, v Value) (err error) {
+	if l, ok := v.(Link); ok && !w.resolving[l.Path()] {
+		target, resolved := l.Value()
+		if !resolved && w.r != nil {
+			if target, err = w.r.Resolve(w.ctx, l); err != nil {
+				return err
+			}
+			l.Resolved(target)
+			resolved = true
+		}
+		if resolved {
+			w.resolving[l.Path()] = true
+			defer delete(w.resolving, l.Path())
+			v = target
+		}
+	}
+
+	if err = w.visit(path, v); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+
+	switch x := v.(type) {
+	case []interface{}:
+		for i, el := range x {
+			if err = w.walk(walkPath(path, i), el); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err = w.walk(walkPath(path, key), x[key]); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		keys := make([]interface{}, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
+		})
+		for _, key := range keys {
+			if err = w.walk(walkPath(path, key), x[key]); err != nil {
+				return err
+			}
+		}
+	case Map:
+		return w.walkIterator(path, x.Iterate(), true)
+	case Array:
+		return w.walkIterator(path, x.Iterate(), false)
+	}
+	return nil
+}
+
+// walkIterator walks the values of a Map or Array's iterator. Map values are
+// pathed by their keys, Array values by their position
+func (w *walker) walkIterator(path []interface{}, it Iterator, keyed bool) (err error) {
+	for i := 0; it.Next(); i++ {
+		var el Value
+		if err = it.Scan(&el); err != nil {
+			break
+		}
+		var key interface{} = i
+		if keyed {
+			key = it.Key()
+		}
+		if err = w.walk(walkPath(path, key), el); err != nil {
+			break
+		}
+	}
+	if closeErr := it.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// walkPath copies path with el added to the end, so paths handed to visit
+// are never shared
+func walkPath(path []interface{}, el interface{}) []interface{} {
+	p := make([]interface{}, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, el)
+}
@@ -0,0 +1,148 @@
+package value
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// visit records the paths & values a walk visits
+type visit struct {
+	Path []interface{}
+	V    Value
+}
+
+func record(visits *[]visit) WalkFunc {
+	return func(path []interface{}, v Value) error {
+		switch v.(type) {
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}, Map, Array:
+			v = "container"
+		}
+		*visits = append(*visits, visit{path, v})
+		return nil
+	}
+}
+
+func TestWalk(t *testing.T) {
+	v := map[string]interface{}{
+		"b": []interface{}{1, map[interface{}]interface{}{2: "two"}},
+		"a": "apple",
+		"c": NewMap([]interface{}{"z", "y"}, []Value{true, NewArray([]Value{nil})}),
+	}
+
+	var got []visit
+	if err := Walk(v, record(&got)); err != nil {
+		t.Fatal(err)
+	}
+	expect := []visit{
+		{[]interface{}(nil), "container"},
+		{[]interface{}{"a"}, "apple"},
+		{[]interface{}{"b"}, "container"},
+		{[]interface{}{"b", 0}, 1},
+		{[]interface{}{"b", 1}, "container"},
+		{[]interface{}{"b", 1, 2}, "two"},
+		{[]interface{}{"c"}, "container"},
+		{[]interface{}{"c", "z"}, true},
+		{[]interface{}{"c", "y"}, "container"},
+		{[]interface{}{"c", "y", 0}, nil},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("visits mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWalkSkipChildren(t *testing.T) {
+	v := map[string]interface{}{
+		"a": []interface{}{1, 2},
+		"b": []interface{}{3},
+	}
+	var got []visit
+	err := Walk(v, func(path []interface{}, v Value) error {
+		got = append(got, visit{path, nil})
+		if len(path) == 1 && path[0] == "a" {
+			return SkipChildren
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []visit{
+		{[]interface{}(nil), nil},
+		{[]interface{}{"a"}, nil},
+		{[]interface{}{"b"}, nil},
+		{[]interface{}{"b", 0}, nil},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("visits mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := Walk(v, func([]interface{}, Value) error { return SkipChildren }); err != nil {
+		t.Errorf("expected skipping the root's children not to error, got %s", err)
+	}
+}
+
+func TestWalkStops(t *testing.T) {
+	stop := errors.New("stop")
+	count := 0
+	err := Walk([]interface{}{1, 2, 3}, func(path []interface{}, v Value) error {
+		if count++; v == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expected walk to return the visit error, got %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 visits before stopping, got %d", count)
+	}
+}
+
+// mapResolver resolves links from a map of paths to values
+type mapResolver map[string]Value
+
+func (r mapResolver) Resolve(ctx context.Context, l Link) (Value, error) {
+	if v, ok := r[l.Path()]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("not found: %s", l.Path())
+}
+
+func TestWalkResolve(t *testing.T) {
+	r := mapResolver{
+		"/a": map[string]interface{}{"name": "a", "next": NewLink("/b")},
+		"/b": map[string]interface{}{"name": "b", "next": NewLink("/a")},
+	}
+
+	var unresolved []visit
+	if err := Walk(NewLink("/a"), record(&unresolved)); err != nil {
+		t.Fatal(err)
+	}
+	if len(unresolved) != 1 {
+		t.Errorf("expected walking an unresolved link without a resolver to visit only the link, got %v", unresolved)
+	}
+
+	var got []visit
+	if err := WalkResolve(context.Background(), r, NewLink("/a"), record(&got)); err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(got))
+	for i, v := range got {
+		paths[i] = fmt.Sprintf("%v", v.Path)
+	}
+	expect := []string{"[]", "[name]", "[next]", "[next name]", "[next next]"}
+	if diff := cmp.Diff(expect, paths); diff != "" {
+		t.Errorf("paths mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := got[len(got)-1].V.(Link); !ok {
+		t.Errorf("expected a link back to a link being walked to be visited as-is, got %#v", got[len(got)-1].V)
+	}
+
+	if err := WalkResolve(context.Background(), r, NewLink("/missing"), record(&got)); err == nil {
+		t.Errorf("expected an unresolvable link to error")
+	}
+}